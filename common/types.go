@@ -8,12 +8,144 @@ type User struct {
 
 // Competition represents a competition entity
 type Competition struct {
-	ID        uint           `json:"id"`
-	Name      string         `json:"name"`
-	ScoreRule string         `json:"score_rule"`
-	StartTime string         `json:"start_time"`
-	EndTime   string         `json:"end_time"`
-	Rewards   map[string]int `json:"rewards"`
+	ID          uint           `json:"id"`
+	Name        string         `json:"name"`
+	ScoreRule   string         `json:"score_rule"`
+	StartTime   string         `json:"start_time"`
+	EndTime     string         `json:"end_time"`
+	Rewards     map[string]int `json:"rewards"`
+	RankingMode RankingMode    `json:"ranking_mode"`
+	TieBreaker  TieBreaker     `json:"tie_breaker"`
+	Aggregation Aggregation    `json:"aggregation"`
+	BestN       int            `json:"best_n,omitempty"`      // Number of events summed by the best_n aggregation
+	SkipLosses  bool           `json:"skip_losses,omitempty"` // Loss events are not passed to the score rule
+	// RuleDisabled is set when the score rule failed too many times in a row, the competition is not scored
+	// until it is enabled again
+	RuleDisabled bool `json:"rule_disabled,omitempty"`
+}
+
+// Aggregation is how the rule results of a user's events are folded into their score
+type Aggregation string
+
+const (
+	AggregationSum     Aggregation = "sum"     // Sum of the results
+	AggregationMax     Aggregation = "max"     // Highest single result
+	AggregationCount   Aggregation = "count"   // Number of events with a non-zero result
+	AggregationAverage Aggregation = "average" // Average of the non-zero results
+	AggregationBestN   Aggregation = "best_n"  // Sum of the BestN highest results
+)
+
+// DefaultAggregation is the aggregation of the competitions that do not set one
+const DefaultAggregation = AggregationSum
+
+// AggregationState holds what a user's score is aggregated from in a competition, besides the score itself
+type AggregationState struct {
+	Count int       `json:"count,omitempty"` // Number of aggregated results
+	Sum   float64   `json:"sum,omitempty"`   // Sum of the aggregated results
+	Best  []float64 `json:"best,omitempty"`  // Highest results, best first, kept by the max and best_n aggregations
+}
+
+// UserActivity is the history of a user's events in a competition, read by the rules that use the user context
+type UserActivity struct {
+	Events    int    `json:"events"`     // Number of events
+	Bets      int    `json:"bets"`       // Number of bet events
+	FirstSeen string `json:"first_seen"` // Timestamp of the first event
+	LastSeen  string `json:"last_seen"`  // Timestamp of the last event
+	LastBet   string `json:"last_bet"`   // Timestamp of the last bet event
+}
+
+// RankingMode is how the users of a competition who stay tied after the tie-breaker are ranked
+type RankingMode string
+
+const (
+	RankingStandard RankingMode = "standard" // Tied users share a rank and the next ranks are skipped: 1, 2, 2, 4
+	RankingDense    RankingMode = "dense"    // Tied users share a rank and no rank is skipped: 1, 2, 2, 3
+	RankingOrdinal  RankingMode = "ordinal"  // Every user has a distinct rank: 1, 2, 3, 4
+)
+
+// TieBreaker is how the users of a competition with the same score are ordered
+type TieBreaker string
+
+const (
+	TieBreakerNone         TieBreaker = "none"          // Users with the same score are tied
+	TieBreakerEarliest     TieBreaker = "earliest"      // The user who reached the score first ranks first
+	TieBreakerFewestEvents TieBreaker = "fewest_events" // The user who reached the score with fewer scored events ranks first
+	TieBreakerUserID       TieBreaker = "user_id"       // The user with the lowest ID ranks first
+)
+
+// Ranking of the competitions that do not set one: users with the same score are ordered by user ID
+const (
+	DefaultRankingMode = RankingOrdinal
+	DefaultTieBreaker  = TieBreakerUserID
+)
+
+// CompetitionResult represents the final standing of a user in a finished competition
+type CompetitionResult struct {
+	CompetitionID uint    `json:"competition_id"`
+	UserID        uint    `json:"user_id"`
+	Rank          int     `json:"rank"`
+	Score         float64 `json:"score"`
+	Reward        int     `json:"reward"`
+}
+
+// JobType is the kind of work a job does
+type JobType string
+
+const (
+	JobTypeBackfill    JobType = "backfill"    // Scores a new competition from the stored bet events of its window
+	JobTypeRecalculate JobType = "recalculate" // Rebuilds the scores of a competition from the stored bet events of its window
+)
+
+// JobStatus is the progress of a job
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"   // The job has not started yet
+	JobStatusRunning   JobStatus = "running"   // The job is processing events
+	JobStatusCompleted JobStatus = "completed" // The job has finished
+	JobStatusFailed    JobStatus = "failed"    // The job has stopped, Error says why
+)
+
+// Job is a long-running task on a competition, run in the background
+type Job struct {
+	ID              uint      `json:"id"`
+	Type            JobType   `json:"type"`
+	CompetitionID   uint      `json:"competition_id"`
+	Status          JobStatus `json:"status"`
+	TotalEvents     int       `json:"total_events"`     // Number of events to process, estimated when the job starts
+	ProcessedEvents int       `json:"processed_events"` // Number of events processed so far
+	Error           string    `json:"error,omitempty"`
+	CreatedAt       string    `json:"created_at"`
+	UpdatedAt       string    `json:"updated_at"`
+}
+
+// RankedUser is a user with their rank in a competition
+type RankedUser struct {
+	Rank  int     `json:"rank"`
+	ID    uint    `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// RankingChange is the rank and score of a user before and after the scores of a competition are recalculated.
+// A rank of 0 means the user is not ranked.
+type RankingChange struct {
+	UserID   uint    `json:"user_id"`
+	OldRank  int     `json:"old_rank"`
+	OldScore float64 `json:"old_score"`
+	NewRank  int     `json:"new_rank"`
+	NewScore float64 `json:"new_score"`
+}
+
+// UserStanding is the position of a user in a competition and the users ranked around them
+type UserStanding struct {
+	CompetitionID     uint          `json:"competition_id"`
+	UserID            uint          `json:"user_id"`
+	Rank              int           `json:"rank"`
+	Score             float64       `json:"score"`
+	TotalParticipants int           `json:"total_participants"`
+	GapToNextRank     float64       `json:"gap_to_next_rank"` // Score needed to reach the user ranked above, 0 for the first user
+	Above             []*RankedUser `json:"above"`            // Users ranked above, closest last
+	Below             []*RankedUser `json:"below"`            // Users ranked below, closest first
 }
 
 // EventType represents the type of event in the system
@@ -2,24 +2,24 @@
 // Store competitions by ID for updates
 let competitionsState = {};
 
-function renderCompetitions(dataArr, highlightUser = null, compId = null, highlightScore = null) {
+function renderCompetitions(dataArr, compId = null, highlightUsers = null) {
     const container = document.getElementById("competitions");
     container.innerHTML = "";
-    dataArr.forEach(event => {
-        // Sort users by score descending
-        let table = `<div class='competition-table'><h2>Competition ${event.CompetitionID}</h2><table><thead><tr><th style='text-align:center; width:90px;'>User</th><th style='text-align:center;'>Score</th></tr></thead><tbody>`;
-        event.Users.forEach(user => {
-            let highlightUserClass = (highlightUser && compId === event.CompetitionID && user.id === highlightUser) ? "highlight-row" : "";
-            let scoreFormatted = `$ ${parseFloat(user.score).toFixed(2)}`;
-            let cellId = `score-${event.CompetitionID}-${user.id}`;
-            table += `<tr class='${highlightUserClass}'><td style='text-align:center; width:90px;'>${user.id}</td><td style='text-align:center;' id='${cellId}'>${scoreFormatted}</td></tr>`;
+    dataArr.forEach(competition => {
+        let title = competition.Finished ? `Competition ${competition.CompetitionID} (finished)` : `Competition ${competition.CompetitionID}`;
+        let table = `<div class='competition-table'><h2>${title}</h2><table><thead><tr><th style='text-align:center; width:60px;'>Rank</th><th style='text-align:center; width:90px;'>User</th><th style='text-align:center;'>Score</th></tr></thead><tbody>`;
+        competition.Entries.forEach(entry => {
+            let highlightUserClass = (highlightUsers && compId === competition.CompetitionID && highlightUsers.includes(entry.user_id)) ? "highlight-row" : "";
+            let scoreFormatted = `$ ${parseFloat(entry.score).toFixed(2)}`;
+            let cellId = `score-${competition.CompetitionID}-${entry.user_id}`;
+            table += `<tr class='${highlightUserClass}'><td style='text-align:center; width:60px;'>${entry.rank}</td><td style='text-align:center; width:90px;'>${entry.user_id}</td><td style='text-align:center;' id='${cellId}'>${scoreFormatted}</td></tr>`;
         });
         table += "</tbody></table></div>";
         container.innerHTML += table;
     });
     // After rendering, add highlight class to all updated cells
-    if (Array.isArray(highlightScore) && compId) {
-        highlightScore.forEach(userId => {
+    if (highlightUsers && compId) {
+        highlightUsers.forEach(userId => {
             const cell = document.getElementById(`score-${compId}-${userId}`);
             if (cell) {
                 cell.classList.remove("highlight-cell"); // Remove if present
@@ -28,19 +28,66 @@ function renderCompetitions(dataArr, highlightUser = null, compId = null, highli
                 cell.classList.add("highlight-cell");
             }
         });
-    } else if (highlightScore && compId) {
-        const cell = document.getElementById(`score-${compId}-${highlightScore}`);
-        if (cell) {
-            cell.classList.remove("highlight-cell");
-            void cell.offsetWidth;
-            cell.classList.add("highlight-cell");
+    }
+}
+
+// applySnapshot replaces the entries of a competition with a snapshot
+function applySnapshot(msg) {
+    const previous = competitionsState[msg.competition_id];
+    competitionsState[msg.competition_id] = {
+        CompetitionID: msg.competition_id,
+        Seq: msg.seq,
+        Entries: msg.entries || [],
+        Finished: previous ? previous.Finished : false,
+    };
+    renderCompetitions(Object.values(competitionsState));
+}
+
+// applyDelta applies the changes of a delta to the entries of a competition.
+// A delta that does not follow the last applied sequence number means an update was missed,
+// so a new snapshot is requested and deltas are ignored until it arrives.
+function applyDelta(msg) {
+    let competition = competitionsState[msg.competition_id];
+    if (!competition && msg.seq === 1) {
+        // First delta of a competition, it holds all its entries
+        competition = { CompetitionID: msg.competition_id, Seq: 0, Entries: [], Finished: false };
+        competitionsState[msg.competition_id] = competition;
+    }
+    if (competition && msg.seq <= competition.Seq) {
+        return; // Already included in the last snapshot
+    }
+    if (!competition || competition.SnapshotRequested || msg.seq !== competition.Seq + 1) {
+        if (competition) {
+            competition.SnapshotRequested = true;
         }
+        ws.send(JSON.stringify({ type: "snapshot", competition_ids: [msg.competition_id] }));
+        return;
     }
+
+    const entries = new Map(competition.Entries.map(entry => [entry.user_id, entry]));
+    (msg.removed || []).forEach(userId => entries.delete(userId));
+    (msg.changes || []).forEach(change => entries.set(change.user_id, { rank: change.rank, user_id: change.user_id, score: change.score }));
+    // Tied users share a rank and are ordered by user ID
+    competition.Entries = Array.from(entries.values()).sort((a, b) => a.rank - b.rank || a.user_id - b.user_id);
+    competition.Seq = msg.seq;
+
+    const changedUsers = (msg.changes || []).map(change => change.user_id);
+    renderCompetitions(Object.values(competitionsState), msg.competition_id, changedUsers.length ? changedUsers : null);
 }
 
 // Initial render with dummy data
 renderCompetitions(Object.values(competitionsState));
 
+// Competitions shown on the page, e.g. ?competitions=1,2 for a landing page. All competitions by default.
+function subscriptionRequest() {
+    const param = new URLSearchParams(window.location.search).get("competitions");
+    if (!param || param === "all") {
+        return { type: "subscribe", all: true };
+    }
+    const ids = param.split(",").map(id => parseInt(id, 10)).filter(id => !isNaN(id));
+    return { type: "subscribe", competition_ids: ids };
+}
+
 // WebSocket logic
 let ws;
 function connect() {
@@ -48,28 +95,29 @@ function connect() {
 
     ws.onopen = function() {
         console.log("Connected to WebSocket server");
+        // The server sends a snapshot of every subscribed competition, then their updates
+        ws.send(JSON.stringify(subscriptionRequest()));
     };
 
     ws.onmessage = function(event) {
         try {
             const msg = JSON.parse(event.data);
-            // msg is expected to be {CompetitionID, Users}
-            const compId = msg.CompetitionID;
-            let highlightUser = null;
-            let highlightScore = [];
-            if (competitionsState[compId]) {
-                // Find updated user(s)
-                const oldUsers = competitionsState[compId].Users;
-                msg.Users.forEach(newUser => {
-                    const oldUser = oldUsers.find(u => u.id === newUser.id);
-                    if (!oldUser || oldUser.score !== newUser.score) {
-                        highlightUser = newUser.id;
-                        highlightScore.push(newUser.id);
-                    }
-                });
+            if (msg.type === "competition_finished") {
+                if (competitionsState[msg.competition_id]) {
+                    competitionsState[msg.competition_id].Finished = true;
+                    renderCompetitions(Object.values(competitionsState));
+                }
+                return;
+            }
+            if (msg.type === "error") {
+                console.error("WebSocket request failed:", msg.error);
+                return;
+            }
+            if (msg.type === "leaderboard_snapshot") {
+                applySnapshot(msg);
+            } else if (msg.type === "leaderboard_delta") {
+                applyDelta(msg);
             }
-            competitionsState[compId] = msg;
-            renderCompetitions(Object.values(competitionsState), highlightUser, compId, highlightScore.length ? highlightScore : null);
         } catch (e) {
             console.error("Invalid message format", e);
         }
@@ -5,29 +5,38 @@ import (
 	"encoding/json"
 	"fmt"
 	"leaderboard/internal"
+	"sync"
 
 	"leaderboard/repositories"
 )
 
+// BetEventHandler processes bet events one at a time
 type BetEventHandler struct {
+	mutex            sync.Mutex
 	leaderboardsRepo repositories.LeaderboardsRepository
 	leaderboard      internal.LeaderboardInterface
-	websocketHandler *WebsocketHandler
+	updates          *LeaderboardUpdates
+	publisher        internal.MessageSender
 }
 
 type UserEventHandler struct {
 	leaderboardsRepo repositories.LeaderboardsRepository
 }
 
-func NewBetEventHandler(repo repositories.LeaderboardsRepository, leaderboard internal.LeaderboardInterface, websocketHandler *WebsocketHandler) *BetEventHandler {
+// NewBetEventHandler creates a BetEventHandler that pushes the changes of the top users to the publisher (websocket, SSE...)
+func NewBetEventHandler(repo repositories.LeaderboardsRepository, leaderboard internal.LeaderboardInterface, updates *LeaderboardUpdates, publisher internal.MessageSender) *BetEventHandler {
 	return &BetEventHandler{
 		leaderboardsRepo: repo,
 		leaderboard:      leaderboard,
-		websocketHandler: websocketHandler,
+		updates:          updates,
+		publisher:        publisher,
 	}
 }
 
 func (beh *BetEventHandler) Handle(body []byte) error {
+	beh.mutex.Lock()
+	defer beh.mutex.Unlock()
+
 	var betEvent common.BetEvent
 	if err := json.Unmarshal(body, &betEvent); err != nil {
 		return fmt.Errorf("error unmarshalling bet event: %v", err)
@@ -42,28 +51,75 @@ func (beh *BetEventHandler) Handle(body []byte) error {
 		return nil
 	}
 
-	if err := beh.leaderboardsRepo.StoreBetEvent(&betEvent); err != nil {
+	// The event is marked as processed in the same transaction as the scores it updates, and the leaderboard
+	// is only updated once they are committed, so an event is either fully processed or can be processed again
+	unitOfWork, err := beh.leaderboardsRepo.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting bet event transaction: %v", err)
+	}
+	defer unitOfWork.Rollback()
+
+	if err := unitOfWork.StoreBetEvent(&betEvent); err != nil {
 		return fmt.Errorf("error storing bet event: %v", err)
 	}
 
 	fmt.Printf("Received bet event: %+v\n", betEvent)
-	updatedData, err := beh.leaderboard.Update(betEvent)
+	evaluation, err := beh.leaderboard.Evaluate(betEvent)
 	if err != nil {
 		println("Error updating leaderboard:", err)
 		return fmt.Errorf("error updating leaderboard: %v", err)
 	}
+	if len(evaluation.OutsideWindow) > 0 {
+		fmt.Printf("Bet event %d skipped by competitions %v, outside their window\n", betEvent.EventID, evaluation.OutsideWindow)
+	}
 
-	for _, update := range updatedData {
-		if err := beh.leaderboardsRepo.Update(update.CompetitionID, update.UserID, update.Score); err != nil {
+	for _, update := range evaluation.Updates {
+		if update.RuleDisabled {
+			if err := unitOfWork.DisableRule(update.CompetitionID); err != nil {
+				return fmt.Errorf("error disabling competition in SQLite: %v", err)
+			}
+		}
+		if update.Activity != nil {
+			if err := unitOfWork.UpdateActivity(update.CompetitionID, update.UserID, update.Activity); err != nil {
+				return fmt.Errorf("error storing user activity in SQLite: %v", err)
+			}
+		}
+		if update.ActivityOnly {
+			continue // The score has not changed
+		}
+		if err := unitOfWork.Update(update.CompetitionID, update.UserID, update.Score, update.ReachedAt, &update.State); err != nil {
 			println("Error storing score in SQLite:", err)
 			return fmt.Errorf("error storing score in SQLite: %v", err)
 		}
 	}
 
-	go sendCompetitionsUpdatesToWebsocket(beh.websocketHandler, beh.leaderboardsRepo, updatedData)
+	if err := unitOfWork.Commit(); err != nil {
+		return fmt.Errorf("error committing bet event: %v", err)
+	}
+	beh.leaderboard.Apply(evaluation)
+
+	go sendCompetitionsUpdates(beh.updates, beh.publisher, evaluation.Updates)
 	return nil
 }
 
+// Exclusive runs fn while no bet event is being processed, bet events received meanwhile wait for it to return
+func (beh *BetEventHandler) Exclusive(fn func() error) error {
+	beh.mutex.Lock()
+	defer beh.mutex.Unlock()
+	return fn()
+}
+
+// PublishCompetition pushes the changes of the top users of a competition to the publisher, for competitions
+// whose scores were swapped in by a backfill or a recalculation rather than updated by bet events
+func (beh *BetEventHandler) PublishCompetition(competitionID uint) {
+	if beh.updates == nil || beh.publisher == nil {
+		return
+	}
+	if err := beh.updates.Publish(beh.publisher, competitionID); err != nil {
+		fmt.Printf("Error sending update of competition %d: %v\n", competitionID, err)
+	}
+}
+
 func NewUserEventHandler(repo repositories.LeaderboardsRepository) *UserEventHandler {
 	return &UserEventHandler{
 		leaderboardsRepo: repo,
@@ -81,37 +137,26 @@ func (ueh *UserEventHandler) UserEventHandler(body []byte) error {
 	}
 }
 
-func sendCompetitionsUpdatesToWebsocket(handler *WebsocketHandler, leaderboardsRepo repositories.LeaderboardsRepository, updates []*internal.UpdatedData) {
-	if handler == nil {
-		fmt.Println("WebSocket handler is not initialized")
+// sendCompetitionsUpdates pushes the changes of the top users of every updated competition to the publisher
+func sendCompetitionsUpdates(updates *LeaderboardUpdates, publisher internal.MessageSender, updatedData []*internal.UpdatedData) {
+	if publisher == nil {
+		fmt.Println("Updates publisher is not initialized")
 
-		return // If no WebSocket handler, skip sending updates
+		return // If no publisher, skip sending updates
 	}
 
-	if leaderboardsRepo == nil {
-		fmt.Println("Leaderboards repository is not initialized")
+	if updates == nil {
+		fmt.Println("Leaderboard updates are not initialized")
 		return
 	}
 
-	for _, updatedCompetition := range updates {
-		competitionID := updatedCompetition.CompetitionID
-
-		updates, err := leaderboardsRepo.GetTopN(competitionID, 10)
-		if err != nil {
-			fmt.Printf("Error retrieving top N users for competition %d: %v\n", competitionID, err)
-			return
-		}
-
-		message := struct {
-			CompetitionID uint
-			Users         []*common.User
-		}{
-			CompetitionID: competitionID,
-			Users:         updates,
+	for _, updatedCompetition := range updatedData {
+		if updatedCompetition.ActivityOnly {
+			continue // The leaderboard has not changed
 		}
-
-		if err := handler.SendMessage(message); err != nil {
-			fmt.Printf("Error sending WebSocket message: %v\n", err)
+		competitionID := updatedCompetition.CompetitionID
+		if err := updates.Publish(publisher, competitionID); err != nil {
+			fmt.Printf("Error sending update of competition %d: %v\n", competitionID, err)
 		}
 	}
 }
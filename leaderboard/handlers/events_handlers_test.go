@@ -7,6 +7,7 @@ import (
 	"leaderboard/internal"
 	"leaderboard/repositories"
 	"testing"
+	"time"
 )
 
 func TestBetEventHandler_Success(t *testing.T) {
@@ -20,7 +21,7 @@ func TestBetEventHandler_Success(t *testing.T) {
 	beh := &BetEventHandler{
 		leaderboardsRepo: mockRepo,
 		leaderboard:      mockLB,
-		websocketHandler: nil,
+		publisher:        nil,
 	}
 	err := beh.Handle(body)
 	if err != nil {
@@ -35,6 +36,101 @@ func TestBetEventHandler_Success(t *testing.T) {
 	if len(mockRepo.Updates) != 1 {
 		t.Errorf("expected 1 repo update, got %d", len(mockRepo.Updates))
 	}
+	if len(mockRepo.UnitsOfWork) != 1 || !mockRepo.UnitsOfWork[0].Committed {
+		t.Error("expected the event and its scores to be committed in one unit of work")
+	}
+	if len(mockLB.Applied) != 1 {
+		t.Errorf("expected the update to be applied to the leaderboard once committed, got %d", len(mockLB.Applied))
+	}
+}
+
+func TestBetEventHandler_StoresActivity(t *testing.T) {
+	activity := &common.UserActivity{Events: 1, Bets: 1}
+	mockLB := &internal.MockLeaderboard{
+		ReturnData: []*internal.UpdatedData{
+			{CompetitionID: 1, UserID: 2, Activity: activity, ActivityOnly: true},
+			{CompetitionID: 3, UserID: 2, Score: 10, Activity: activity},
+		},
+	}
+	mockRepo := &repositories.MockLeaderboardsRepo{}
+	body, _ := json.Marshal(common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 2, Amount: 10})
+
+	beh := &BetEventHandler{
+		leaderboardsRepo: mockRepo,
+		leaderboard:      mockLB,
+		publisher:        nil,
+	}
+	if err := beh.Handle(body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mockRepo.Activities[1][2] != activity || mockRepo.Activities[3][2] != activity {
+		t.Errorf("expected the activity of both competitions to be stored, got %+v", mockRepo.Activities)
+	}
+	if len(mockRepo.Updates) != 1 {
+		t.Errorf("expected only the score of competition 3 to be stored, got %+v", mockRepo.Updates)
+	}
+}
+
+func TestBetEventHandler_DisablesRule(t *testing.T) {
+	mockLB := &internal.MockLeaderboard{
+		ReturnData: []*internal.UpdatedData{{CompetitionID: 4, UserID: 2, ActivityOnly: true, RuleDisabled: true}},
+	}
+	mockRepo := &repositories.MockLeaderboardsRepo{}
+	body, _ := json.Marshal(common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 2, Amount: 10})
+
+	beh := &BetEventHandler{
+		leaderboardsRepo: mockRepo,
+		leaderboard:      mockLB,
+		publisher:        nil,
+	}
+	if err := beh.Handle(body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mockRepo.DisabledRules) != 1 || mockRepo.DisabledRules[0] != 4 {
+		t.Errorf("expected competition 4 to be disabled with the event, got %v", mockRepo.DisabledRules)
+	}
+	if len(mockRepo.Updates) != 0 {
+		t.Errorf("expected no score to be stored, got %+v", mockRepo.Updates)
+	}
+}
+
+func TestBetEventHandler_RuleErrorsAfterFailedCommit(t *testing.T) {
+	rule := "amount"
+	lb := internal.NewLeaderboard(&internal.MockRuleEvaluator{Matches: []internal.Match{{Rule: rule, Result: "not a number"}}})
+	lb.RegisterCompetition(&common.Competition{ID: 4, ScoreRule: rule})
+	mockRepo := &repositories.MockLeaderboardsRepo{}
+	beh := &BetEventHandler{leaderboardsRepo: mockRepo, leaderboard: lb}
+	handle := func(eventID uint) error {
+		body, _ := json.Marshal(common.BetEvent{EventID: eventID, EventType: common.EventTypeBet, UserID: 2, ExchangeRate: 1.0})
+		return beh.Handle(body)
+	}
+	for i := uint(1); i < internal.MaxConsecutiveRuleErrors; i++ {
+		if err := handle(i); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The commit of the event disabling the competition fails, it is redelivered as it has not been stored
+	mockRepo.CommitErr = errors.New("database is locked")
+	if err := handle(internal.MaxConsecutiveRuleErrors); err == nil {
+		t.Fatal("expected the failed commit to be reported")
+	}
+	if status := lb.GetRuleErrors()[0]; status.Disabled || status.ErrorCount != internal.MaxConsecutiveRuleErrors-1 {
+		t.Errorf("expected the rule errors of an event that was not committed to be dropped, got %+v", status)
+	}
+	mockRepo.CommitErr = nil
+	delete(mockRepo.BetEvents, internal.MaxConsecutiveRuleErrors)
+	mockRepo.DisabledRules = nil
+	if err := handle(internal.MaxConsecutiveRuleErrors); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if status := lb.GetRuleErrors()[0]; !status.Disabled || status.ErrorCount != internal.MaxConsecutiveRuleErrors {
+		t.Errorf("expected the redelivered event to be counted once and disable the competition, got %+v", status)
+	}
+	if len(mockRepo.DisabledRules) != 1 || mockRepo.DisabledRules[0] != 4 {
+		t.Errorf("expected the disabled competition to be stored with the redelivered event, got %v", mockRepo.DisabledRules)
+	}
 }
 
 func TestBetEventHandler_Idempotency(t *testing.T) {
@@ -46,7 +142,7 @@ func TestBetEventHandler_Idempotency(t *testing.T) {
 	beh := &BetEventHandler{
 		leaderboardsRepo: mockRepo,
 		leaderboard:      mockLB,
-		websocketHandler: nil,
+		publisher:        nil,
 	}
 	err := beh.Handle(body)
 	if err != nil {
@@ -69,7 +165,7 @@ func TestBetEventHandler_StoreBetEventError(t *testing.T) {
 	beh := &BetEventHandler{
 		leaderboardsRepo: mockRepo,
 		leaderboard:      mockLB,
-		websocketHandler: nil,
+		publisher:        nil,
 	}
 	err := beh.Handle(body)
 	if err == nil || err.Error() == "" {
@@ -91,7 +187,7 @@ func TestBetEventHandler_UnmarshalError(t *testing.T) {
 	beh := &BetEventHandler{
 		leaderboardsRepo: mockRepo,
 		leaderboard:      mockLB,
-		websocketHandler: nil,
+		publisher:        nil,
 	}
 	err := beh.Handle(body)
 	if err == nil || err.Error() == "" {
@@ -108,7 +204,7 @@ func TestBetEventHandler_LeaderboardUpdateError(t *testing.T) {
 	beh := &BetEventHandler{
 		leaderboardsRepo: mockRepo,
 		leaderboard:      mockLB,
-		websocketHandler: nil,
+		publisher:        nil,
 	}
 	err := beh.Handle(body)
 	if err == nil || err.Error() == "" {
@@ -125,10 +221,83 @@ func TestBetEventHandler_RepoUpdateError(t *testing.T) {
 	beh := &BetEventHandler{
 		leaderboardsRepo: mockRepo,
 		leaderboard:      mockLB,
-		websocketHandler: nil,
+		publisher:        nil,
 	}
 	err := beh.Handle(body)
 	if err == nil || err.Error() == "" {
 		t.Error("expected error from repo.Update")
 	}
+	if len(mockRepo.UnitsOfWork) != 1 || !mockRepo.UnitsOfWork[0].RolledBack {
+		t.Error("expected the unit of work to be rolled back")
+	}
+	if len(mockLB.Applied) != 0 {
+		t.Errorf("expected the leaderboard to be left untouched, got %d applied updates", len(mockLB.Applied))
+	}
+}
+
+func TestBetEventHandler_CommitError(t *testing.T) {
+	mockLB := &internal.MockLeaderboard{ReturnData: []*internal.UpdatedData{{CompetitionID: 1, UserID: 2, Score: 100}}}
+	mockRepo := &repositories.MockLeaderboardsRepo{CommitErr: errors.New("commit error")}
+	body, _ := json.Marshal(common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 2, Amount: 100})
+
+	beh := &BetEventHandler{
+		leaderboardsRepo: mockRepo,
+		leaderboard:      mockLB,
+		publisher:        nil,
+	}
+	if err := beh.Handle(body); err == nil {
+		t.Error("expected error from the commit")
+	}
+	if !mockRepo.UnitsOfWork[0].RolledBack {
+		t.Error("expected the unit of work to be rolled back")
+	}
+	if len(mockLB.Applied) != 0 {
+		t.Errorf("expected the leaderboard to be left untouched, got %d applied updates", len(mockLB.Applied))
+	}
+}
+
+func TestBetEventHandler_PublishCompetition(t *testing.T) {
+	mockRepo := &repositories.MockLeaderboardsRepo{TopNUsers: []*common.User{{ID: 7, Score: 20}}}
+	publisher := &mockPublisher{}
+	beh := NewBetEventHandler(mockRepo, &internal.MockLeaderboard{}, NewLeaderboardUpdates(mockRepo), publisher)
+
+	// The scores swapped in are pushed as a delta, then nothing is sent until they change again
+	beh.PublishCompetition(1)
+	beh.PublishCompetition(1)
+	if len(publisher.messages) != 1 {
+		t.Fatalf("expected a single delta, got %+v", publisher.messages)
+	}
+	delta := publisher.messages[0].(*LeaderboardDeltaMessage)
+	if delta.CompetitionID != 1 || delta.Seq != 1 || len(delta.Changes) != 1 || delta.Changes[0].UserID != 7 {
+		t.Errorf("unexpected delta: %+v", delta)
+	}
+}
+
+func TestBetEventHandler_Exclusive(t *testing.T) {
+	mockLB := &internal.MockLeaderboard{}
+	mockRepo := &repositories.MockLeaderboardsRepo{}
+	betEvent := common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 2, Amount: 100}
+	body, _ := json.Marshal(betEvent)
+
+	beh := &BetEventHandler{
+		leaderboardsRepo: mockRepo,
+		leaderboard:      mockLB,
+		publisher:        nil,
+	}
+	handled := make(chan error)
+	err := beh.Exclusive(func() error {
+		go func() { handled <- beh.Handle(body) }()
+		select {
+		case <-handled:
+			t.Error("expected the bet event to wait for the exclusive function")
+		case <-time.After(50 * time.Millisecond):
+		}
+		return errors.New("exclusive error")
+	})
+	if err == nil || err.Error() != "exclusive error" {
+		t.Errorf("expected the error of the exclusive function, got %v", err)
+	}
+	if err := <-handled; err != nil || !mockRepo.StoreBetEventCalled {
+		t.Errorf("expected the bet event to be handled afterwards, got %v", err)
+	}
 }
@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"common"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -16,26 +20,77 @@ import (
 // CompetitionsHandler holds dependencies for competition handlers
 type CompetitionsHandler struct {
 	competitionsRepo repositories.CompetitionsRepository
+	leaderboardsRepo repositories.LeaderboardsRepository
 	leaderboard      internal.LeaderboardInterface
+	backfills        internal.BackfillScheduler
+	now              func() time.Time
+}
+
+// createCompetitionRequest is a competition to create along with how it is created
+type createCompetitionRequest struct {
+	common.Competition
+	Backfill bool `json:"backfill"` // Score the bet events stored before the competition was created
+}
+
+// competitionPatch holds the competition fields that can be edited before the competition starts
+type competitionPatch struct {
+	Name        *string             `json:"name"`
+	StartTime   *string             `json:"start_time"`
+	EndTime     *string             `json:"end_time"`
+	Rewards     *map[string]int     `json:"rewards"`
+	RankingMode *common.RankingMode `json:"ranking_mode"`
+	TieBreaker  *common.TieBreaker  `json:"tie_breaker"`
+	Aggregation *common.Aggregation `json:"aggregation"`
+	BestN       *int                `json:"best_n"`
+	SkipLosses  *bool               `json:"skip_losses"`
 }
 
 // NewCompetitionsHandler creates a new CompetitionHandler instance
-func NewCompetitionsHandler(repo repositories.CompetitionsRepository, leaderboard internal.LeaderboardInterface) *CompetitionsHandler {
+func NewCompetitionsHandler(repo repositories.CompetitionsRepository, leaderboardsRepo repositories.LeaderboardsRepository, leaderboard internal.LeaderboardInterface, backfills internal.BackfillScheduler) *CompetitionsHandler {
 	return &CompetitionsHandler{
 		competitionsRepo: repo,
+		leaderboardsRepo: leaderboardsRepo,
 		leaderboard:      leaderboard,
+		backfills:        backfills,
+		now:              time.Now,
 	}
 }
 
-// CreateCompetition creates a new competition
+// currentTime returns the current time, used to decide the status of competitions
+func (ch *CompetitionsHandler) currentTime() time.Time {
+	if ch.now == nil {
+		return time.Now().UTC()
+	}
+	return ch.now().UTC()
+}
+
+// CreateCompetition creates a new competition.
+// With backfill, the competition is first scored from the stored bet events of its window by a background job,
+// which is returned along with the ID.
 func (ch *CompetitionsHandler) CreateCompetition(w http.ResponseWriter, r *http.Request) {
-	var competition common.Competition
+	var request createCompetitionRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&competition); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("invalid JSON"))
 		return
 	}
+	competition := request.Competition
+
+	internal.SetRankingDefaults(&competition)
+	internal.SetAggregationDefault(&competition)
+	if err := validateCompetition(&competition); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if request.Backfill {
+		if status, _ := internal.CompetitionStatus(&competition, ch.currentTime()); status == internal.CompetitionStatusUpcoming {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("backfill requires a competition that has started, there are no bet events to backfill"))
+			return
+		}
+	}
 
 	id, err := ch.competitionsRepo.Create(&competition)
 	if err != nil {
@@ -43,6 +98,24 @@ func (ch *CompetitionsHandler) CreateCompetition(w http.ResponseWriter, r *http.
 		w.Write([]byte(fmt.Sprintf("failed to create competition: %v", err)))
 		return
 	}
+	competition.ID = id
+
+	if request.Backfill {
+		job, err := ch.backfills.Schedule(&competition)
+		if err != nil {
+			// The competition is not created without its backfill, so it can be created again
+			if err := ch.competitionsRepo.Delete(id); err != nil {
+				fmt.Printf("Error deleting competition %d after its backfill failed to start: %v\n", id, err)
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to start backfill: %v", err)))
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/jobs/%d", job.ID))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "backfill_job": job})
+		return
+	}
 
 	ch.leaderboard.RegisterCompetition(&competition)
 
@@ -50,6 +123,265 @@ func (ch *CompetitionsHandler) CreateCompetition(w http.ResponseWriter, r *http.
 	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
 }
 
+// GetCompetitions lists all competitions, optionally filtered by status (active, upcoming or finished)
+func (ch *CompetitionsHandler) GetCompetitions(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", internal.CompetitionStatusActive, internal.CompetitionStatusUpcoming, internal.CompetitionStatusFinished:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("invalid status %q, must be one of active, upcoming or finished", status)))
+		return
+	}
+
+	competitions, err := ch.competitionsRepo.GetAll()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to get competitions: %v", err)))
+		return
+	}
+
+	now := ch.currentTime()
+	filtered := []*common.Competition{}
+	for _, competition := range competitions {
+		if status != "" {
+			compStatus, err := internal.CompetitionStatus(competition, now)
+			if err != nil || compStatus != status {
+				continue
+			}
+		}
+		filtered = append(filtered, competition)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filtered)
+}
+
+// GetCompetitionByID retrieves a single competition
+func (ch *CompetitionsHandler) GetCompetitionByID(w http.ResponseWriter, r *http.Request) {
+	competition, ok := ch.getCompetitionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(competition)
+}
+
+// UpdateCompetition edits the name, start/end times, rewards, ranking, aggregation or loss skipping of a competition that has not started yet
+func (ch *CompetitionsHandler) UpdateCompetition(w http.ResponseWriter, r *http.Request) {
+	competition, ok := ch.getCompetitionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var patch competitionPatch
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("invalid JSON, only name, start_time, end_time, rewards, ranking_mode, tie_breaker, aggregation, best_n and skip_losses can be edited: %v", err)))
+		return
+	}
+
+	status, err := internal.CompetitionStatus(competition, ch.currentTime())
+	if err == nil && status != internal.CompetitionStatusUpcoming {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("competition %d has already started and can no longer be edited", competition.ID)))
+		return
+	}
+
+	updated := *competition
+	if patch.Name != nil {
+		updated.Name = *patch.Name
+	}
+	if patch.StartTime != nil {
+		updated.StartTime = *patch.StartTime
+	}
+	if patch.EndTime != nil {
+		updated.EndTime = *patch.EndTime
+	}
+	if patch.Rewards != nil {
+		updated.Rewards = *patch.Rewards
+	}
+	if patch.RankingMode != nil {
+		updated.RankingMode = *patch.RankingMode
+	}
+	if patch.TieBreaker != nil {
+		updated.TieBreaker = *patch.TieBreaker
+	}
+	if patch.Aggregation != nil {
+		updated.Aggregation = *patch.Aggregation
+	}
+	if patch.BestN != nil {
+		updated.BestN = *patch.BestN
+	}
+	if patch.SkipLosses != nil {
+		updated.SkipLosses = *patch.SkipLosses
+	}
+	internal.SetRankingDefaults(&updated)
+	internal.SetAggregationDefault(&updated)
+	if err := validateCompetition(&updated); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if err := ch.competitionsRepo.Update(&updated); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to update competition: %v", err)))
+		return
+	}
+
+	// Re-register the competition so the leaderboard picks up its new window, aggregation and loss handling
+	ch.leaderboard.UnregisterCompetition(updated.ID)
+	ch.leaderboard.RegisterCompetition(&updated)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteCompetition removes a competition and stops scoring it
+func (ch *CompetitionsHandler) DeleteCompetition(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid competition id"))
+		return
+	}
+
+	err = ch.competitionsRepo.Delete(uint(id))
+	if errors.Is(err, repositories.ErrCompetitionNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("competition with id %d not found", id)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to delete competition: %v", err)))
+		return
+	}
+
+	ch.leaderboard.UnregisterCompetition(uint(id))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EnableCompetition scores a competition again after its score rule was disabled for failing too many times
+// in a row, and clears its rule errors
+func (ch *CompetitionsHandler) EnableCompetition(w http.ResponseWriter, r *http.Request) {
+	competition, ok := ch.getCompetitionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := ch.competitionsRepo.EnableRule(competition.ID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to enable competition: %v", err)))
+		return
+	}
+	ch.leaderboard.EnableCompetition(competition.ID)
+
+	enabled := *competition
+	enabled.RuleDisabled = false
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enabled)
+}
+
+// getCompetitionFromRequest retrieves the competition identified by the id path variable.
+// If it cannot be retrieved, the error response is written and false is returned.
+func (ch *CompetitionsHandler) getCompetitionFromRequest(w http.ResponseWriter, r *http.Request) (*common.Competition, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid competition id"))
+		return nil, false
+	}
+
+	competition, err := ch.competitionsRepo.GetByID(uint(id))
+	if errors.Is(err, repositories.ErrCompetitionNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("competition with id %d not found", id)))
+		return nil, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to get competition: %v", err)))
+		return nil, false
+	}
+	return competition, true
+}
+
+// validateCompetition checks the score rule, start/end times, rewards, ranking and aggregation of a competition
+func validateCompetition(competition *common.Competition) error {
+	if err := internal.ValidateRule(competition.ScoreRule); err != nil {
+		return fmt.Errorf("invalid score_rule: %v", err)
+	}
+	if err := internal.ValidateTimeWindow(competition.StartTime, competition.EndTime); err != nil {
+		return fmt.Errorf("invalid competition window: %v", err)
+	}
+	if _, err := internal.ParseRewards(competition.Rewards); err != nil {
+		return fmt.Errorf("invalid rewards: %v", err)
+	}
+	if err := internal.ValidateRanking(competition.RankingMode, competition.TieBreaker); err != nil {
+		return fmt.Errorf("invalid ranking: %v", err)
+	}
+	if err := internal.ValidateAggregation(competition.Aggregation, competition.BestN); err != nil {
+		return fmt.Errorf("invalid aggregation: %v", err)
+	}
+	return nil
+}
+
+// GetCompetitionRewards returns the reward payout of every ranked user in a competition
+func (ch *CompetitionsHandler) GetCompetitionRewards(w http.ResponseWriter, r *http.Request) {
+	competition, ok := ch.getCompetitionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id := competition.ID
+
+	rewards, err := internal.ParseRewards(competition.Rewards)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("competition %d has invalid rewards: %v", id, err)))
+		return
+	}
+
+	payouts := []internal.Payout{}
+	if maxRank := rewards.MaxRank(); maxRank != 0 {
+		users, err := ch.getRankedUsers(id, maxRank)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to get leaderboard: %v", err)))
+			return
+		}
+		payouts = rewards.Payouts(users)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payouts)
+}
+
+// getRankedUsers retrieves the users of a competition ranked up to maxRank, or all users if maxRank is negative.
+// Tied users share a rank, so there can be more users than maxRank.
+func (ch *CompetitionsHandler) getRankedUsers(competitionID uint, maxRank int) ([]*common.RankedUser, error) {
+	page, err := ch.leaderboardsRepo.GetPage(competitionID, 0, maxRank, nil)
+	if err != nil {
+		return nil, err
+	}
+	users := page.Users
+	for page.More && page.Next != nil && users[len(users)-1].Rank <= maxRank {
+		// The last user of the page may be tied with the next ones
+		if page, err = ch.leaderboardsRepo.GetPage(competitionID, 0, maxRank, page.Next); err != nil {
+			return nil, err
+		}
+		users = append(users, page.Users...)
+	}
+	for len(users) > 0 && maxRank >= 0 && users[len(users)-1].Rank > maxRank {
+		users = users[:len(users)-1]
+	}
+	return users, nil
+}
+
 // LeaderboardsHandler holds dependencies for leaderboard handlers
 type LeaderboardsHandler struct {
 	leaderboardsRepo repositories.LeaderboardsRepository
@@ -62,7 +394,8 @@ func NewLeaderboardsHandler(repo repositories.LeaderboardsRepository) *Leaderboa
 	}
 }
 
-// GetLeaderboardByID retrieves the top N users for a given competition ID
+// GetLeaderboardByID retrieves the top N users for a given competition ID.
+// If any of the offset, limit or cursor query parameters is set, it returns a page of the ranking instead.
 func (lh *LeaderboardsHandler) GetLeaderboardByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	idStr := vars["id"]
@@ -73,6 +406,11 @@ func (lh *LeaderboardsHandler) GetLeaderboardByID(w http.ResponseWriter, r *http
 		w.Write([]byte("invalid leaderboard id"))
 		return
 	}
+	query := r.URL.Query()
+	if query.Has("offset") || query.Has("limit") || query.Has("cursor") {
+		lh.getLeaderboardPage(w, r, uint(id))
+		return
+	}
 	count := 10 // default
 	if countStr != "" {
 		c, err := strconv.Atoi(countStr)
@@ -95,3 +433,470 @@ func (lh *LeaderboardsHandler) GetLeaderboardByID(w http.ResponseWriter, r *http
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
 }
+
+// Default and maximum number of users of a leaderboard page
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// leaderboardPageResponse is a page of the ranking of a competition.
+// NextCursor is empty on the last page.
+type leaderboardPageResponse struct {
+	CompetitionID uint                 `json:"competition_id"`
+	Total         int                  `json:"total"`
+	Users         []*common.RankedUser `json:"users"`
+	NextCursor    string               `json:"next_cursor,omitempty"`
+}
+
+// leaderboardCursor is the JSON form of a repositories.LeaderboardCursor, sent to clients base64 encoded
+type leaderboardCursor struct {
+	Score     float64 `json:"s"`
+	ReachedAt string  `json:"r,omitempty"`
+	Events    int     `json:"e,omitempty"`
+	UserID    uint    `json:"u"`
+}
+
+// getLeaderboardPage writes a page of the ranking of a competition, from an offset or after a cursor
+func (lh *LeaderboardsHandler) getLeaderboardPage(w http.ResponseWriter, r *http.Request, competitionID uint) {
+	query := r.URL.Query()
+	limit := defaultPageLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("limit must be a number between 1 and %d", maxPageLimit)))
+			return
+		}
+	}
+	offset := 0
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("offset must be a non-negative number"))
+			return
+		}
+	}
+	var after *repositories.LeaderboardCursor
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		if query.Has("offset") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("offset and cursor cannot be used together"))
+			return
+		}
+		var err error
+		after, err = decodeLeaderboardCursor(cursorStr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid cursor"))
+			return
+		}
+	}
+
+	page, err := lh.leaderboardsRepo.GetPage(competitionID, offset, limit, after)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to get leaderboard: %v", err)))
+		return
+	}
+	response := leaderboardPageResponse{
+		CompetitionID: competitionID,
+		Total:         page.Total,
+		Users:         page.Users,
+	}
+	if page.More && page.Next != nil {
+		response.NextCursor = encodeLeaderboardCursor(page.Next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// encodeLeaderboardCursor returns the opaque form of a cursor sent to clients
+func encodeLeaderboardCursor(cursor *repositories.LeaderboardCursor) string {
+	data, _ := json.Marshal(leaderboardCursor(*cursor))
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeLeaderboardCursor parses a cursor returned by encodeLeaderboardCursor
+func decodeLeaderboardCursor(encoded string) (*repositories.LeaderboardCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var cursor leaderboardCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	after := repositories.LeaderboardCursor(cursor)
+	return &after, nil
+}
+
+// Default and maximum number of users returned above and below a user by GetUserStanding
+const (
+	defaultStandingWindow = 5
+	maxStandingWindow     = 50
+)
+
+// GetUserStanding retrieves the rank and score of a user in a competition and the users ranked around them.
+// The number of users above and below is set with the window query parameter.
+func (lh *LeaderboardsHandler) GetUserStanding(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil || id < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid leaderboard id"))
+		return
+	}
+	userID, err := strconv.Atoi(vars["userId"])
+	if err != nil || userID < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid user id"))
+		return
+	}
+	window := defaultStandingWindow
+	if windowStr := r.URL.Query().Get("window"); windowStr != "" {
+		window, err = strconv.Atoi(windowStr)
+		if err != nil || window < 0 || window > maxStandingWindow {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("window must be a number between 0 and %d", maxStandingWindow)))
+			return
+		}
+	}
+
+	standing, err := lh.leaderboardsRepo.GetUserStanding(uint(id), uint(userID), window)
+	if errors.Is(err, repositories.ErrUserNotRanked) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("user %d has no score in leaderboard %d", userID, id)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to get user standing: %v", err)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(standing)
+}
+
+// maxRuleTestEventIDs is the maximum number of stored events a rule can be tested against in one request
+const maxRuleTestEventIDs = 500
+
+// RulesHandler holds dependencies for rule handlers
+type RulesHandler struct {
+	leaderboardsRepo repositories.LeaderboardsRepository
+	leaderboard      internal.LeaderboardInterface
+}
+
+// ruleTestRequest is the body of a rule test: the rule and the sample and/or stored events to evaluate it against
+type ruleTestRequest struct {
+	Rule     string            `json:"rule"`
+	Events   []common.BetEvent `json:"events"`
+	EventIDs []uint            `json:"event_ids"`
+}
+
+// NewRulesHandler creates a new RulesHandler instance
+func NewRulesHandler(repo repositories.LeaderboardsRepository, leaderboard internal.LeaderboardInterface) *RulesHandler {
+	return &RulesHandler{
+		leaderboardsRepo: repo,
+		leaderboard:      leaderboard,
+	}
+}
+
+// TestRule evaluates a score rule against sample events and/or stored events without registering it,
+// and returns the score each event would award
+func (rh *RulesHandler) TestRule(w http.ResponseWriter, r *http.Request) {
+	var request ruleTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid JSON"))
+		return
+	}
+
+	if len(request.EventIDs) > maxRuleTestEventIDs {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("at most %d event_ids can be tested at once", maxRuleTestEventIDs)))
+		return
+	}
+
+	events := request.Events
+	unavailable := []uint{}
+	if len(request.EventIDs) > 0 {
+		stored, err := rh.leaderboardsRepo.GetBetEvents(request.EventIDs)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("failed to get stored events: %v", err)))
+			return
+		}
+		// Events that were not stored, or stored before their full payload was kept, cannot be evaluated
+		found := make(map[uint]bool, len(stored))
+		for _, event := range stored {
+			found[event.EventID] = true
+		}
+		for _, id := range request.EventIDs {
+			if !found[id] {
+				unavailable = append(unavailable, id)
+			}
+		}
+		events = append(events, stored...)
+	}
+	if len(events) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no events to test the rule against, provide events or event_ids of stored events"))
+		return
+	}
+
+	results, err := internal.DryRunRule(request.Rule, events)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("invalid rule: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"rule": request.Rule, "results": results, "unavailable_event_ids": unavailable})
+}
+
+// GetRuleErrors lists the competitions whose score rule has failed to evaluate,
+// with their error counts, last error and whether they have been disabled
+func (rh *RulesHandler) GetRuleErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rh.leaderboard.GetRuleErrors())
+}
+
+// JobsHandler holds dependencies for job handlers
+type JobsHandler struct {
+	jobsRepo repositories.JobsRepository
+}
+
+// NewJobsHandler creates a new JobsHandler instance
+func NewJobsHandler(repo repositories.JobsRepository) *JobsHandler {
+	return &JobsHandler{jobsRepo: repo}
+}
+
+// GetJobByID returns the status and progress of a background job
+func (jh *JobsHandler) GetJobByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid job id"))
+		return
+	}
+
+	job, err := jh.jobsRepo.GetByID(uint(id))
+	if errors.Is(err, repositories.ErrJobNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("job with id %d not found", id)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to get job: %v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// RecalculationsHandler holds dependencies for recalculation handlers
+type RecalculationsHandler struct {
+	competitionsRepo repositories.CompetitionsRepository
+	recalculations   internal.Recalculations
+}
+
+// recalculateRequest is the optional body of a recalculation, the competition's rule is used if it is empty
+type recalculateRequest struct {
+	ScoreRule string `json:"score_rule"`
+}
+
+// recalculationResponse is a recalculation with a page of the users whose rank or score it changes
+type recalculationResponse struct {
+	CompetitionID     uint                    `json:"competition_id"`
+	ScoreRule         string                  `json:"score_rule"`
+	Job               *common.Job             `json:"job"`
+	LiveUsers         int                     `json:"live_users"`
+	RecalculatedUsers int                     `json:"recalculated_users"`
+	ChangedUsers      int                     `json:"changed_users"`
+	Changes           []*common.RankingChange `json:"changes"`
+}
+
+// NewRecalculationsHandler creates a new RecalculationsHandler instance
+func NewRecalculationsHandler(repo repositories.CompetitionsRepository, recalculations internal.Recalculations) *RecalculationsHandler {
+	return &RecalculationsHandler{
+		competitionsRepo: repo,
+		recalculations:   recalculations,
+	}
+}
+
+// RecalculateCompetition starts rebuilding the scores of a competition from the stored bet events, with its
+// current rule or the rule of the body, and returns the background job doing it. The live scores are left
+// untouched until the recalculation is confirmed.
+func (rh *RecalculationsHandler) RecalculateCompetition(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid competition id"))
+		return
+	}
+	var request recalculateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid JSON"))
+		return
+	}
+	if request.ScoreRule != "" {
+		if err := internal.ValidateRule(request.ScoreRule); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("invalid score_rule: %v", err)))
+			return
+		}
+	}
+
+	competition, err := rh.competitionsRepo.GetByID(uint(id))
+	if errors.Is(err, repositories.ErrCompetitionNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("competition with id %d not found", id)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to get competition: %v", err)))
+		return
+	}
+
+	job, err := rh.recalculations.Schedule(competition, request.ScoreRule)
+	if errors.Is(err, repositories.ErrRecalculationExists) || errors.Is(err, internal.ErrCompetitionBackfilling) || errors.Is(err, internal.ErrCompetitionEnded) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("competition %d cannot be recalculated: %v", id, err)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to start recalculation: %v", err)))
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/jobs/%d", job.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetRecalculation compares the live ranking of a competition with the ranking of its recalculation.
+// It returns the status of the recalculation job and, once it has completed, a page of the users whose rank
+// or score changes, selected with the offset and limit query parameters.
+func (rh *RecalculationsHandler) GetRecalculation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid competition id"))
+		return
+	}
+	query := r.URL.Query()
+	limit := defaultPageLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("limit must be a number between 1 and %d", maxPageLimit)))
+			return
+		}
+	}
+	offset := 0
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("offset must be a non-negative number"))
+			return
+		}
+	}
+
+	preview, err := rh.recalculations.Preview(uint(id))
+	if errors.Is(err, repositories.ErrRecalculationNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("competition %d has no recalculation", id)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to get recalculation: %v", err)))
+		return
+	}
+
+	changes := preview.Changes
+	if offset > len(changes) {
+		offset = len(changes)
+	}
+	end := offset + limit
+	if end > len(changes) {
+		end = len(changes)
+	}
+	response := recalculationResponse{
+		CompetitionID:     preview.Recalculation.CompetitionID,
+		ScoreRule:         preview.Recalculation.ScoreRule,
+		Job:               preview.Job,
+		LiveUsers:         preview.LiveUsers,
+		RecalculatedUsers: preview.RecalculatedUsers,
+		ChangedUsers:      len(changes),
+		Changes:           append([]*common.RankingChange{}, changes[offset:end]...),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ConfirmRecalculation swaps the recalculated scores and rule of a competition in, once its job has completed
+func (rh *RecalculationsHandler) ConfirmRecalculation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid competition id"))
+		return
+	}
+
+	err = rh.recalculations.Apply(uint(id))
+	if errors.Is(err, repositories.ErrRecalculationNotFound) || errors.Is(err, repositories.ErrCompetitionNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("competition %d has no recalculation", id)))
+		return
+	}
+	if errors.Is(err, internal.ErrRecalculationNotReady) || errors.Is(err, repositories.ErrCompetitionFinished) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("recalculation of competition %d cannot be confirmed: %v", id, err)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to confirm recalculation: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DiscardRecalculation drops the recalculation of a competition, leaving its live scores untouched
+func (rh *RecalculationsHandler) DiscardRecalculation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid competition id"))
+		return
+	}
+
+	err = rh.recalculations.Discard(uint(id))
+	if errors.Is(err, repositories.ErrRecalculationNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("competition %d has no recalculation", id)))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to discard recalculation: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"leaderboard/internal"
 	"leaderboard/repositories"
@@ -22,7 +24,7 @@ func TestCreateCompetitionHandler(t *testing.T) {
 	ch := &CompetitionsHandler{competitionsRepo: repo, leaderboard: mockLB}
 	competition := common.Competition{
 		Name:      "Test Comp",
-		ScoreRule: "rule",
+		ScoreRule: "event_type=='bet' ? amount : 0",
 		StartTime: "2025-07-10T00:00:00Z",
 		EndTime:   "2025-07-11T00:00:00Z",
 		Rewards:   map[string]int{"1": 100},
@@ -42,6 +44,52 @@ func TestCreateCompetitionHandler(t *testing.T) {
 	if !mockLB.called {
 		t.Errorf("expected RegisterCompetition to be called")
 	}
+	if mockLB.registered == nil || mockLB.registered.ID != repo.LastID {
+		t.Errorf("expected competition to be registered with the ID returned by the repository")
+	}
+}
+
+func TestCreateCompetitionHandler_RankingAndAggregation(t *testing.T) {
+	cases := []struct {
+		name               string
+		body               string
+		expected           int
+		expectedMode       common.RankingMode
+		expectedTieBreaker common.TieBreaker
+	}{
+		{"defaults", `{}`, http.StatusCreated, common.RankingOrdinal, common.TieBreakerUserID},
+		{"dense earliest", `{"ranking_mode": "dense", "tie_breaker": "earliest"}`, http.StatusCreated, common.RankingDense, common.TieBreakerEarliest},
+		{"unknown mode", `{"ranking_mode": "olympic"}`, http.StatusBadRequest, "", ""},
+		{"unknown tie-breaker", `{"tie_breaker": "luck"}`, http.StatusBadRequest, "", ""},
+		{"best_n aggregation", `{"aggregation": "best_n", "best_n": 3}`, http.StatusCreated, common.RankingOrdinal, common.TieBreakerUserID},
+		{"best_n without a count", `{"aggregation": "best_n"}`, http.StatusBadRequest, "", ""},
+		{"unknown aggregation", `{"aggregation": "median"}`, http.StatusBadRequest, "", ""},
+	}
+	for _, c := range cases {
+		repo := &repositories.MockCompetitions{}
+		ch := &CompetitionsHandler{competitionsRepo: repo, leaderboard: &mockLeaderboard{}}
+		var competition map[string]any
+		json.Unmarshal([]byte(c.body), &competition)
+		competition["name"] = "Ranked"
+		competition["score_rule"] = "amount"
+		body, _ := json.Marshal(competition)
+
+		w := httptest.NewRecorder()
+		ch.CreateCompetition(w, httptest.NewRequest("POST", "/competitions", bytes.NewReader(body)))
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", c.name, c.expected, w.Code, w.Body.String())
+			continue
+		}
+		if c.expected != http.StatusCreated {
+			continue
+		}
+		if repo.LastCreated.RankingMode != c.expectedMode || repo.LastCreated.TieBreaker != c.expectedTieBreaker {
+			t.Errorf("%s: expected ranking %s/%s, got %s/%s", c.name, c.expectedMode, c.expectedTieBreaker, repo.LastCreated.RankingMode, repo.LastCreated.TieBreaker)
+		}
+		if repo.LastCreated.Aggregation == "" {
+			t.Errorf("%s: expected the default aggregation to be set", c.name)
+		}
+	}
 }
 
 func TestCreateCompetitionHandler_BadJSON(t *testing.T) {
@@ -64,7 +112,7 @@ func TestCreateCompetitionHandler_CreateError(t *testing.T) {
 	repo := &repositories.MockCompetitions{CreateErr: fmt.Errorf("create error")}
 	mockLB := &mockLeaderboard{}
 	ch := &CompetitionsHandler{competitionsRepo: repo, leaderboard: mockLB}
-	competition := common.Competition{Name: "Test Comp"}
+	competition := common.Competition{Name: "Test Comp", ScoreRule: "amount"}
 	body, _ := json.Marshal(competition)
 	req := httptest.NewRequest("POST", "/competitions", bytes.NewReader(body))
 	w := httptest.NewRecorder()
@@ -78,6 +126,271 @@ func TestCreateCompetitionHandler_CreateError(t *testing.T) {
 	}
 }
 
+// mockBackfills records the competitions it schedules a backfill for
+type mockBackfills struct {
+	scheduled []*common.Competition
+	err       error
+}
+
+func (m *mockBackfills) Schedule(comp *common.Competition) (*common.Job, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.scheduled = append(m.scheduled, comp)
+	return &common.Job{ID: 5, Type: common.JobTypeBackfill, CompetitionID: comp.ID, Status: common.JobStatusPending}, nil
+}
+
+func TestCreateCompetitionHandler_Backfill(t *testing.T) {
+	now := time.Date(2025, 7, 15, 0, 0, 0, 0, time.UTC)
+	newRequest := func(startTime string) *http.Request {
+		body := fmt.Sprintf(`{"name": "Backfilled", "score_rule": "amount", "start_time": %q, "backfill": true}`, startTime)
+		return httptest.NewRequest("POST", "/competitions", bytes.NewReader([]byte(body)))
+	}
+
+	repo := &repositories.MockCompetitions{}
+	mockLB := &mockLeaderboard{}
+	backfills := &mockBackfills{}
+	ch := &CompetitionsHandler{competitionsRepo: repo, leaderboard: mockLB, backfills: backfills, now: func() time.Time { return now }}
+	w := httptest.NewRecorder()
+	ch.CreateCompetition(w, newRequest("2025-07-01T00:00:00Z"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID          uint        `json:"id"`
+		BackfillJob *common.Job `json:"backfill_job"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	if created.ID != repo.LastID || created.BackfillJob == nil || created.BackfillJob.ID != 5 || w.Header().Get("Location") != "/jobs/5" {
+		t.Errorf("expected the competition and its backfill job, got %+v and location %q", created, w.Header().Get("Location"))
+	}
+	if len(backfills.scheduled) != 1 || backfills.scheduled[0].ID != repo.LastID {
+		t.Errorf("expected a backfill to be scheduled for the competition, got %+v", backfills.scheduled)
+	}
+	if mockLB.called {
+		t.Errorf("expected the competition to be registered by its backfill")
+	}
+
+	// Upcoming competitions have nothing to backfill
+	w = httptest.NewRecorder()
+	ch.CreateCompetition(w, newRequest("2025-08-01T00:00:00Z"))
+	if w.Code != http.StatusBadRequest || len(backfills.scheduled) != 1 {
+		t.Errorf("expected status 400 for an upcoming competition, got %d", w.Code)
+	}
+
+	// The competition is deleted if its backfill cannot start
+	repo = &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{1: {ID: 1}}}
+	ch = &CompetitionsHandler{competitionsRepo: repo, leaderboard: mockLB, backfills: &mockBackfills{err: fmt.Errorf("jobs error")}, now: func() time.Time { return now }}
+	w = httptest.NewRecorder()
+	ch.CreateCompetition(w, newRequest("2025-07-01T00:00:00Z"))
+	if w.Code != http.StatusInternalServerError || repo.LastDeleted != 1 || mockLB.called {
+		t.Errorf("expected status 500 and the competition to be deleted, got %d and deleted %d", w.Code, repo.LastDeleted)
+	}
+}
+
+func TestGetJobByID(t *testing.T) {
+	jobsRepo := &repositories.MockJobs{}
+	id, _ := jobsRepo.Create(&common.Job{Type: common.JobTypeBackfill, CompetitionID: 3, Status: common.JobStatusRunning, TotalEvents: 2000, ProcessedEvents: 1000})
+	jh := NewJobsHandler(jobsRepo)
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs/{id}", jh.GetJobByID)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/jobs/%d", id), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var job common.Job
+	json.NewDecoder(w.Body).Decode(&job)
+	if job.ID != id || job.CompetitionID != 3 || job.Status != common.JobStatusRunning || job.ProcessedEvents != 1000 || job.TotalEvents != 2000 {
+		t.Errorf("unexpected job: %+v", job)
+	}
+
+	for path, expected := range map[string]int{"/jobs/42": http.StatusNotFound, "/jobs/abc": http.StatusBadRequest} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", path, expected, w.Code)
+		}
+	}
+}
+
+// mockRecalculations records the recalculations it schedules, applies and discards
+type mockRecalculations struct {
+	scheduled map[uint]string // map[competitionID]scoreRule
+	preview   *internal.RecalculationPreview
+	applied   []uint
+	discarded []uint
+	err       error
+}
+
+func (m *mockRecalculations) Schedule(comp *common.Competition, scoreRule string) (*common.Job, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.scheduled == nil {
+		m.scheduled = map[uint]string{}
+	}
+	m.scheduled[comp.ID] = scoreRule
+	return &common.Job{ID: 6, Type: common.JobTypeRecalculate, CompetitionID: comp.ID, Status: common.JobStatusPending}, nil
+}
+
+func (m *mockRecalculations) Preview(competitionID uint) (*internal.RecalculationPreview, error) {
+	if m.preview == nil || m.preview.Recalculation.CompetitionID != competitionID {
+		return nil, repositories.ErrRecalculationNotFound
+	}
+	return m.preview, nil
+}
+
+func (m *mockRecalculations) Apply(competitionID uint) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.applied = append(m.applied, competitionID)
+	return nil
+}
+
+func (m *mockRecalculations) Discard(competitionID uint) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.discarded = append(m.discarded, competitionID)
+	return nil
+}
+
+func newRecalculationsRouter(recalculations *mockRecalculations) *mux.Router {
+	repo := &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{1: {ID: 1, Name: "Recalculated", ScoreRule: "amount"}}}
+	rh := NewRecalculationsHandler(repo, recalculations)
+	r := mux.NewRouter()
+	r.HandleFunc("/competitions/{id}/recalculate", rh.RecalculateCompetition).Methods("POST")
+	r.HandleFunc("/competitions/{id}/recalculation", rh.GetRecalculation).Methods("GET")
+	r.HandleFunc("/competitions/{id}/recalculation", rh.DiscardRecalculation).Methods("DELETE")
+	r.HandleFunc("/competitions/{id}/recalculation/confirm", rh.ConfirmRecalculation).Methods("POST")
+	return r
+}
+
+func TestRecalculateCompetition(t *testing.T) {
+	recalculations := &mockRecalculations{}
+	r := newRecalculationsRouter(recalculations)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/competitions/1/recalculate", bytes.NewReader([]byte(`{"score_rule": "amount * 2"}`))))
+	if w.Code != http.StatusAccepted || w.Header().Get("Location") != "/jobs/6" {
+		t.Fatalf("expected status 202 with the job location, got %d and %q: %s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+	var job common.Job
+	json.NewDecoder(w.Body).Decode(&job)
+	if job.ID != 6 || job.Type != common.JobTypeRecalculate || recalculations.scheduled[1] != "amount * 2" {
+		t.Errorf("expected a recalculation with the new rule, got %+v and %v", job, recalculations.scheduled)
+	}
+
+	// Without a body, the competition is recalculated with its current rule
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/competitions/1/recalculate", nil))
+	if w.Code != http.StatusAccepted || recalculations.scheduled[1] != "" {
+		t.Errorf("expected status 202 with the current rule, got %d and %v", w.Code, recalculations.scheduled)
+	}
+
+	for _, tc := range []struct {
+		path, body string
+		err        error
+		expected   int
+	}{
+		{"/competitions/2/recalculate", "", nil, http.StatusNotFound},
+		{"/competitions/abc/recalculate", "", nil, http.StatusBadRequest},
+		{"/competitions/1/recalculate", `{"score_rule": "amount +"}`, nil, http.StatusBadRequest},
+		{"/competitions/1/recalculate", "", repositories.ErrRecalculationExists, http.StatusConflict},
+		{"/competitions/1/recalculate", "", internal.ErrCompetitionEnded, http.StatusConflict},
+		{"/competitions/1/recalculate", "", fmt.Errorf("jobs error"), http.StatusInternalServerError},
+	} {
+		recalculations.err = tc.err
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", tc.path, bytes.NewReader([]byte(tc.body))))
+		if w.Code != tc.expected {
+			t.Errorf("%s %s (%v): expected status %d, got %d", tc.path, tc.body, tc.err, tc.expected, w.Code)
+		}
+	}
+}
+
+func TestGetRecalculation(t *testing.T) {
+	recalculations := &mockRecalculations{preview: &internal.RecalculationPreview{
+		Recalculation:     &repositories.Recalculation{CompetitionID: 1, JobID: 6, ScoreRule: "amount * 2"},
+		Job:               &common.Job{ID: 6, Status: common.JobStatusCompleted},
+		LiveUsers:         3,
+		RecalculatedUsers: 3,
+		Changes: []*common.RankingChange{
+			{UserID: 8, OldRank: 2, OldScore: 50, NewRank: 1, NewScore: 200},
+			{UserID: 7, OldRank: 1, OldScore: 100, NewRank: 2, NewScore: 100},
+			{UserID: 9, OldRank: 3, OldScore: 10},
+		},
+	}}
+	r := newRecalculationsRouter(recalculations)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/competitions/1/recalculation?offset=1&limit=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response recalculationResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	if response.ScoreRule != "amount * 2" || response.Job.ID != 6 || response.LiveUsers != 3 || response.ChangedUsers != 3 {
+		t.Errorf("unexpected recalculation: %+v", response)
+	}
+	if len(response.Changes) != 1 || response.Changes[0].UserID != 7 {
+		t.Errorf("expected the second change, got %+v", response.Changes)
+	}
+
+	for path, expected := range map[string]int{
+		"/competitions/1/recalculation?offset=10": http.StatusOK,
+		"/competitions/1/recalculation?limit=0":   http.StatusBadRequest,
+		"/competitions/2/recalculation":           http.StatusNotFound,
+	} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", path, expected, w.Code)
+		}
+	}
+}
+
+func TestConfirmAndDiscardRecalculation(t *testing.T) {
+	recalculations := &mockRecalculations{}
+	r := newRecalculationsRouter(recalculations)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/competitions/1/recalculation/confirm", nil))
+	if w.Code != http.StatusNoContent || len(recalculations.applied) != 1 {
+		t.Errorf("expected status 204 and the recalculation to be applied, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("DELETE", "/competitions/1/recalculation", nil))
+	if w.Code != http.StatusNoContent || len(recalculations.discarded) != 1 {
+		t.Errorf("expected status 204 and the recalculation to be discarded, got %d", w.Code)
+	}
+
+	for _, tc := range []struct {
+		err      error
+		expected int
+	}{
+		{internal.ErrRecalculationNotReady, http.StatusConflict},
+		{repositories.ErrCompetitionFinished, http.StatusConflict},
+		{repositories.ErrRecalculationNotFound, http.StatusNotFound},
+	} {
+		recalculations.err = tc.err
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/competitions/1/recalculation/confirm", nil))
+		if w.Code != tc.expected {
+			t.Errorf("confirm (%v): expected status %d, got %d", tc.err, tc.expected, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("DELETE", "/competitions/1/recalculation", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 when discarding a missing recalculation, got %d", w.Code)
+	}
+}
+
 func TestGetLeaderboardByID_NotFound(t *testing.T) {
 	repo := &repositories.MockLeaderboardsRepo{}
 	repo.GetTopNFunc = func(competitionID uint, n int) ([]*common.User, error) {
@@ -147,6 +460,114 @@ func TestGetLeaderboardByID_RepoError(t *testing.T) {
 	}
 }
 
+func TestGetLeaderboardByID_Page(t *testing.T) {
+	var gotOffset, gotLimit int
+	var gotAfter *repositories.LeaderboardCursor
+	repo := &repositories.MockLeaderboardsRepo{
+		GetPageFunc: func(competitionID uint, offset, limit int, after *repositories.LeaderboardCursor) (*repositories.LeaderboardPage, error) {
+			gotOffset, gotLimit, gotAfter = offset, limit, after
+			return &repositories.LeaderboardPage{
+				Users: []*common.RankedUser{{Rank: offset + 1, ID: 5, Score: 500}, {Rank: offset + 2, ID: 2, Score: 300.5}},
+				Total: 6,
+				More:  true,
+				Next:  &repositories.LeaderboardCursor{Score: 300.5, UserID: 2},
+			}, nil
+		},
+	}
+	h := NewLeaderboardsHandler(repo)
+	r := mux.NewRouter()
+	r.HandleFunc("/leaderboards/{id}", h.GetLeaderboardByID)
+
+	get := func(url string) (*http.Response, leaderboardPageResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		var page leaderboardPageResponse
+		json.NewDecoder(w.Result().Body).Decode(&page)
+		return w.Result(), page
+	}
+
+	resp, page := get("/leaderboards/1?offset=10&limit=2")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if gotOffset != 10 || gotLimit != 2 || gotAfter != nil {
+		t.Errorf("unexpected page request: offset %d, limit %d, after %+v", gotOffset, gotLimit, gotAfter)
+	}
+	if page.CompetitionID != 1 || page.Total != 6 || len(page.Users) != 2 || page.Users[0].Rank != 11 || page.NextCursor == "" {
+		t.Errorf("unexpected page: %+v", page)
+	}
+
+	// The next cursor is the position of the last user of the page
+	resp, _ = get("/leaderboards/1?cursor=" + page.NextCursor)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if gotAfter == nil || gotAfter.Score != 300.5 || gotAfter.UserID != 2 || gotLimit != defaultPageLimit {
+		t.Errorf("expected the page to start after user 2, got %+v (limit %d)", gotAfter, gotLimit)
+	}
+
+	for _, url := range []string{
+		"/leaderboards/1?limit=0",
+		"/leaderboards/1?limit=5000",
+		"/leaderboards/1?offset=-1",
+		"/leaderboards/1?cursor=not-a-cursor",
+		"/leaderboards/1?offset=0&cursor=" + page.NextCursor,
+	} {
+		if resp, _ := get(url); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", url, resp.StatusCode)
+		}
+	}
+
+	repo.GetPageFunc = nil
+	repo.ReturnErr = errTest
+	if resp, _ := get("/leaderboards/1?limit=10"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", resp.StatusCode)
+	}
+}
+
+func TestGetUserStanding(t *testing.T) {
+	standing := &common.UserStanding{
+		CompetitionID: 1, UserID: 4, Rank: 4, Score: 200, TotalParticipants: 6, GapToNextRank: 100,
+		Above: []*common.RankedUser{{Rank: 3, ID: 3, Score: 300}},
+		Below: []*common.RankedUser{{Rank: 5, ID: 1, Score: 100}},
+	}
+	tests := []struct {
+		name           string
+		url            string
+		repo           *repositories.MockLeaderboardsRepo
+		expectedStatus int
+	}{
+		{"Success", "/leaderboards/1/users/4?window=1", &repositories.MockLeaderboardsRepo{Standing: standing}, http.StatusOK},
+		{"DefaultWindow", "/leaderboards/1/users/4", &repositories.MockLeaderboardsRepo{Standing: standing}, http.StatusOK},
+		{"NotRanked", "/leaderboards/1/users/42", &repositories.MockLeaderboardsRepo{}, http.StatusNotFound},
+		{"BadLeaderboardID", "/leaderboards/abc/users/4", &repositories.MockLeaderboardsRepo{Standing: standing}, http.StatusBadRequest},
+		{"BadUserID", "/leaderboards/1/users/abc", &repositories.MockLeaderboardsRepo{Standing: standing}, http.StatusBadRequest},
+		{"BadWindow", "/leaderboards/1/users/4?window=500", &repositories.MockLeaderboardsRepo{Standing: standing}, http.StatusBadRequest},
+		{"RepoError", "/leaderboards/1/users/4", &repositories.MockLeaderboardsRepo{ReturnErr: errTest}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewLeaderboardsHandler(tt.repo)
+			r := mux.NewRouter()
+			r.HandleFunc("/leaderboards/{id}/users/{userId}", h.GetUserStanding)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+			resp := w.Result()
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var got common.UserStanding
+			json.NewDecoder(resp.Body).Decode(&got)
+			if got.Rank != 4 || got.TotalParticipants != 6 || got.GapToNextRank != 100 || len(got.Above) != 1 || len(got.Below) != 1 {
+				t.Errorf("unexpected standing: %+v", got)
+			}
+		})
+	}
+}
+
 var errTest = &mockError{"repo error"}
 
 type mockError struct{ msg string }
@@ -154,14 +575,423 @@ type mockError struct{ msg string }
 func (e *mockError) Error() string { return e.msg }
 
 type mockLeaderboard struct {
-	called bool
+	called       bool
+	registered   *common.Competition
+	unregistered []uint
+	enabled      []uint
+	ruleErrors   []*internal.RuleErrorStatus
 }
 
 func (m *mockLeaderboard) RegisterCompetition(c *common.Competition) {
 	m.called = true
+	m.registered = c
+}
+
+func (m *mockLeaderboard) UnregisterCompetition(competitionID uint) {
+	m.unregistered = append(m.unregistered, competitionID)
+}
+
+func (m *mockLeaderboard) GetRuleErrors() []*internal.RuleErrorStatus {
+	return m.ruleErrors
+}
+
+func (m *mockLeaderboard) EnableCompetition(competitionID uint) {
+	m.enabled = append(m.enabled, competitionID)
 }
 
 func (m *mockLeaderboard) Update(event common.BetEvent) ([]*internal.UpdatedData, error) {
 	return nil, nil
 }
+func (m *mockLeaderboard) Evaluate(event common.BetEvent) (*internal.Evaluation, error) {
+	return &internal.Evaluation{}, nil
+}
+func (m *mockLeaderboard) Apply(evaluation *internal.Evaluation)    {}
 func (m *mockLeaderboard) Load(data map[uint]map[uint]*common.User) {}
+
+func TestCreateCompetitionHandler_InvalidRewards(t *testing.T) {
+	repo := &repositories.MockCompetitions{}
+	mockLB := &mockLeaderboard{}
+	ch := &CompetitionsHandler{competitionsRepo: repo, leaderboard: mockLB}
+	competition := common.Competition{
+		Name:      "Test Comp",
+		ScoreRule: "amount",
+		Rewards:   map[string]int{"1": 100, "3-5": 50},
+	}
+	body, _ := json.Marshal(competition)
+	req := httptest.NewRequest("POST", "/competitions", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	ch.CreateCompetition(w, req)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", resp.StatusCode)
+	}
+	if repo.LastCreated != nil {
+		t.Errorf("competition with invalid rewards should not be stored")
+	}
+	if mockLB.called {
+		t.Errorf("RegisterCompetition should not be called on invalid rewards")
+	}
+}
+
+func TestGetCompetitionRewards_Success(t *testing.T) {
+	compRepo := &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{
+		1: {ID: 1, Rewards: map[string]int{"1": 100, "2-3": 50}},
+	}}
+	lbRepo := &repositories.MockLeaderboardsRepo{}
+	var requestedN int
+	lbRepo.GetPageFunc = func(competitionID uint, offset, limit int, after *repositories.LeaderboardCursor) (*repositories.LeaderboardPage, error) {
+		requestedN = limit
+		// Users 6 and 7 are tied at rank 2 and both get its reward
+		return &repositories.LeaderboardPage{Users: []*common.RankedUser{
+			{Rank: 1, ID: 5, Score: 30},
+			{Rank: 2, ID: 6, Score: 20},
+			{Rank: 2, ID: 7, Score: 20},
+		}}, nil
+	}
+	ch := NewCompetitionsHandler(compRepo, lbRepo, &mockLeaderboard{}, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/competitions/{id}/rewards", ch.GetCompetitionRewards)
+	req := httptest.NewRequest("GET", "/competitions/1/rewards", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if requestedN != 3 {
+		t.Errorf("expected leaderboard to be fetched up to the last rewarded rank 3, got %d", requestedN)
+	}
+	var got []internal.Payout
+	json.NewDecoder(resp.Body).Decode(&got)
+	if len(got) != 3 || got[0].UserID != 5 || got[0].Reward != 100 || got[1].UserID != 6 || got[1].Reward != 50 || got[2].UserID != 7 || got[2].Reward != 50 {
+		t.Errorf("unexpected payouts: %+v", got)
+	}
+}
+
+func TestGetCompetitionRewards_NotFound(t *testing.T) {
+	ch := NewCompetitionsHandler(&repositories.MockCompetitions{}, &repositories.MockLeaderboardsRepo{}, &mockLeaderboard{}, nil)
+	r := mux.NewRouter()
+	r.HandleFunc("/competitions/{id}/rewards", ch.GetCompetitionRewards)
+	req := httptest.NewRequest("GET", "/competitions/42/rewards", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestGetCompetitionRewards_RepoError(t *testing.T) {
+	compRepo := &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{
+		1: {ID: 1, Rewards: map[string]int{"1+": 10}},
+	}}
+	lbRepo := &repositories.MockLeaderboardsRepo{ReturnErr: errTest}
+	ch := NewCompetitionsHandler(compRepo, lbRepo, &mockLeaderboard{}, nil)
+	r := mux.NewRouter()
+	r.HandleFunc("/competitions/{id}/rewards", ch.GetCompetitionRewards)
+	req := httptest.NewRequest("GET", "/competitions/1/rewards", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Result().StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Result().StatusCode)
+	}
+}
+
+func newCompetitionsTestRouter(ch *CompetitionsHandler) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/competitions", ch.GetCompetitions).Methods("GET")
+	r.HandleFunc("/competitions/{id}", ch.GetCompetitionByID).Methods("GET")
+	r.HandleFunc("/competitions/{id}", ch.UpdateCompetition).Methods("PATCH")
+	r.HandleFunc("/competitions/{id}", ch.DeleteCompetition).Methods("DELETE")
+	r.HandleFunc("/competitions/{id}/enable", ch.EnableCompetition).Methods("POST")
+	return r
+}
+
+func newCompetitionsTestHandler() (*CompetitionsHandler, *repositories.MockCompetitions, *mockLeaderboard) {
+	repo := &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{
+		1: {ID: 1, Name: "Finished", ScoreRule: "amount", StartTime: "2025-06-01T00:00:00Z", EndTime: "2025-06-30T23:59:59Z"},
+		2: {ID: 2, Name: "Active", ScoreRule: "amount", StartTime: "2025-07-01T00:00:00Z", EndTime: "2025-07-31T23:59:59Z"},
+		3: {ID: 3, Name: "Upcoming", ScoreRule: "amount", StartTime: "2025-08-01T00:00:00Z", EndTime: "2025-08-31T23:59:59Z", Rewards: map[string]int{"1": 100}},
+	}}
+	mockLB := &mockLeaderboard{}
+	ch := NewCompetitionsHandler(repo, &repositories.MockLeaderboardsRepo{}, mockLB, nil)
+	ch.now = func() time.Time { return time.Date(2025, 7, 15, 0, 0, 0, 0, time.UTC) }
+	return ch, repo, mockLB
+}
+
+func TestGetCompetitions_FilterByStatus(t *testing.T) {
+	ch, _, _ := newCompetitionsTestHandler()
+	r := newCompetitionsTestRouter(ch)
+
+	cases := map[string][]uint{
+		"":                 {1, 2, 3},
+		"?status=finished": {1},
+		"?status=active":   {2},
+		"?status=upcoming": {3},
+	}
+	for query, expected := range cases {
+		req := httptest.NewRequest("GET", "/competitions"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected status 200, got %d", query, w.Code)
+		}
+		var got []common.Competition
+		json.NewDecoder(w.Body).Decode(&got)
+		ids := map[uint]bool{}
+		for _, c := range got {
+			ids[c.ID] = true
+		}
+		if len(got) != len(expected) {
+			t.Errorf("%q: expected %d competitions, got %d", query, len(expected), len(got))
+		}
+		for _, id := range expected {
+			if !ids[id] {
+				t.Errorf("%q: expected competition %d in the response", query, id)
+			}
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/competitions?status=paused", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for unknown status, got %d", w.Code)
+	}
+}
+
+func TestGetCompetitionByID(t *testing.T) {
+	ch, _, _ := newCompetitionsTestHandler()
+	r := newCompetitionsTestRouter(ch)
+
+	req := httptest.NewRequest("GET", "/competitions/2", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var got common.Competition
+	json.NewDecoder(w.Body).Decode(&got)
+	if got.ID != 2 || got.Name != "Active" {
+		t.Errorf("unexpected competition: %+v", got)
+	}
+
+	req = httptest.NewRequest("GET", "/competitions/42", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestUpdateCompetition_BeforeStart(t *testing.T) {
+	ch, repo, mockLB := newCompetitionsTestHandler()
+	r := newCompetitionsTestRouter(ch)
+
+	body := []byte(`{"name": "Renamed", "end_time": "2025-09-30T23:59:59Z", "rewards": {"1": 200, "2+": 10}}`)
+	req := httptest.NewRequest("PATCH", "/competitions/3", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	updated := repo.LastUpdated
+	if updated == nil || updated.Name != "Renamed" || updated.EndTime != "2025-09-30T23:59:59Z" || updated.Rewards["1"] != 200 {
+		t.Fatalf("unexpected updated competition: %+v", updated)
+	}
+	if updated.StartTime != "2025-08-01T00:00:00Z" || updated.ScoreRule != "amount" {
+		t.Errorf("fields not present in the patch should be unchanged: %+v", updated)
+	}
+	if len(mockLB.unregistered) != 1 || mockLB.registered == nil || mockLB.registered.Name != "Renamed" {
+		t.Errorf("expected competition to be re-registered in the leaderboard")
+	}
+}
+
+func TestUpdateCompetition_Invalid(t *testing.T) {
+	cases := []struct {
+		name     string
+		id       string
+		body     string
+		expected int
+	}{
+		{"already started", "2", `{"name": "Renamed"}`, http.StatusConflict},
+		{"already finished", "1", `{"name": "Renamed"}`, http.StatusConflict},
+		{"score rule edit", "3", `{"score_rule": "amount * 2"}`, http.StatusBadRequest},
+		{"invalid rewards", "3", `{"rewards": {"2-5": 10}}`, http.StatusBadRequest},
+		{"end before start", "3", `{"end_time": "2025-07-31T00:00:00Z"}`, http.StatusBadRequest},
+		{"invalid ranking mode", "3", `{"ranking_mode": "olympic"}`, http.StatusBadRequest},
+		{"ranking edit after start", "2", `{"tie_breaker": "none"}`, http.StatusConflict},
+		{"not found", "42", `{"name": "Renamed"}`, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		ch, repo, _ := newCompetitionsTestHandler()
+		r := newCompetitionsTestRouter(ch)
+		req := httptest.NewRequest("PATCH", "/competitions/"+c.id, bytes.NewReader([]byte(c.body)))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.expected, w.Code)
+		}
+		if repo.LastUpdated != nil {
+			t.Errorf("%s: competition should not be updated", c.name)
+		}
+	}
+}
+
+func TestDeleteCompetition(t *testing.T) {
+	ch, repo, mockLB := newCompetitionsTestHandler()
+	r := newCompetitionsTestRouter(ch)
+
+	req := httptest.NewRequest("DELETE", "/competitions/2", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", w.Code)
+	}
+	if repo.LastDeleted != 2 {
+		t.Errorf("expected competition 2 to be deleted, got %d", repo.LastDeleted)
+	}
+	if len(mockLB.unregistered) != 1 || mockLB.unregistered[0] != 2 {
+		t.Errorf("expected competition 2 to be unregistered from the leaderboard, got %v", mockLB.unregistered)
+	}
+
+	req = httptest.NewRequest("DELETE", "/competitions/2", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 when deleting twice, got %d", w.Code)
+	}
+}
+
+func TestEnableCompetition(t *testing.T) {
+	ch, repo, mockLB := newCompetitionsTestHandler()
+	r := newCompetitionsTestRouter(ch)
+	repo.Competitions[2].RuleDisabled = true
+
+	req := httptest.NewRequest("POST", "/competitions/2/enable", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var got common.Competition
+	json.NewDecoder(w.Body).Decode(&got)
+	if got.ID != 2 || got.RuleDisabled || repo.Competitions[2].RuleDisabled {
+		t.Errorf("expected competition 2 to be enabled, got %+v", got)
+	}
+	if len(mockLB.enabled) != 1 || mockLB.enabled[0] != 2 {
+		t.Errorf("expected competition 2 to be enabled in the leaderboard, got %v", mockLB.enabled)
+	}
+
+	req = httptest.NewRequest("POST", "/competitions/42/enable", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown competition, got %d", w.Code)
+	}
+}
+
+func TestCreateCompetitionHandler_InvalidScoreRule(t *testing.T) {
+	cases := map[string]string{
+		"empty rule":         "",
+		"syntax error":       "event_type == ",
+		"unknown identifier": "event_type=='bet' ? bet_amount : 0",
+		"string result":      "game",
+		"boolean result":     "event_type=='bet'",
+		"string branch":      "event_type=='bet' ? amount : 'none'",
+	}
+	for name, rule := range cases {
+		repo := &repositories.MockCompetitions{}
+		mockLB := &mockLeaderboard{}
+		ch := &CompetitionsHandler{competitionsRepo: repo, leaderboard: mockLB}
+		body, _ := json.Marshal(common.Competition{Name: "Test Comp", ScoreRule: rule})
+		req := httptest.NewRequest("POST", "/competitions", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		ch.CreateCompetition(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", name, w.Code)
+		}
+		if !bytes.Contains(w.Body.Bytes(), []byte("invalid score_rule")) {
+			t.Errorf("%s: expected a descriptive error, got %q", name, w.Body.String())
+		}
+		if repo.LastCreated != nil || mockLB.called {
+			t.Errorf("%s: competition with invalid rule should not be created", name)
+		}
+	}
+}
+
+func TestRulesHandler_TestRule(t *testing.T) {
+	repo := &repositories.MockLeaderboardsRepo{
+		StoredBetEvents: []common.BetEvent{
+			{EventID: 7, EventType: common.EventTypeBet, Amount: 30, ExchangeRate: 1.0},
+			{EventID: 8, Amount: 30}, // Stored before the full payload was kept
+		},
+	}
+	rh := NewRulesHandler(repo, &mockLeaderboard{})
+	body := []byte(`{
+		"rule": "event_type=='bet' ? amount * 2 : 0",
+		"events": [{"event_id": 1, "event_type": "bet", "amount": 10, "exchange_rate": 0.5}, {"event_id": 2, "event_type": "win", "amount": 10, "exchange_rate": 1}],
+		"event_ids": [7, 8, 9]
+	}`)
+	req := httptest.NewRequest("POST", "/rules/test", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	rh.TestRule(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var got struct {
+		Rule        string                    `json:"rule"`
+		Results     []internal.RuleTestResult `json:"results"`
+		Unavailable []uint                    `json:"unavailable_event_ids"`
+	}
+	json.NewDecoder(w.Body).Decode(&got)
+	if len(got.Unavailable) != 2 || got.Unavailable[0] != 8 || got.Unavailable[1] != 9 {
+		t.Errorf("expected events 8 and 9 to be reported as unavailable, got %v", got.Unavailable)
+	}
+	expected := map[uint]float64{1: 10, 2: 0, 7: 60}
+	if len(got.Results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(got.Results))
+	}
+	for _, result := range got.Results {
+		if result.Score != expected[result.EventID] {
+			t.Errorf("event %d: expected score %f, got %f", result.EventID, expected[result.EventID], result.Score)
+		}
+	}
+}
+
+func TestRulesHandler_TestRule_Invalid(t *testing.T) {
+	rh := NewRulesHandler(&repositories.MockLeaderboardsRepo{}, &mockLeaderboard{})
+	cases := map[string]string{
+		"invalid JSON": `notjson`,
+		"invalid rule": `{"rule": "unknown_field * 2", "events": [{"event_id": 1}]}`,
+		"no events":    `{"rule": "amount"}`,
+		"too many ids": fmt.Sprintf(`{"rule": "amount", "event_ids": [%s1]}`, strings.Repeat("1,", maxRuleTestEventIDs)),
+	}
+	for name, body := range cases {
+		req := httptest.NewRequest("POST", "/rules/test", bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		rh.TestRule(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", name, w.Code)
+		}
+	}
+}
+
+func TestRulesHandler_GetRuleErrors(t *testing.T) {
+	mockLB := &mockLeaderboard{ruleErrors: []*internal.RuleErrorStatus{
+		{CompetitionID: 3, Rule: "amount / x", ErrorCount: 12, LastError: "unknown name x", Disabled: true},
+	}}
+	rh := NewRulesHandler(&repositories.MockLeaderboardsRepo{}, mockLB)
+	req := httptest.NewRequest("GET", "/rules/errors", nil)
+	w := httptest.NewRecorder()
+	rh.GetRuleErrors(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	var got []internal.RuleErrorStatus
+	json.NewDecoder(w.Body).Decode(&got)
+	if len(got) != 1 || got[0].CompetitionID != 3 || got[0].ErrorCount != 12 || !got[0].Disabled {
+		t.Errorf("unexpected rule errors: %+v", got)
+	}
+}
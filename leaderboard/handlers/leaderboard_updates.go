@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"common"
+	"fmt"
+	"sync"
+
+	"leaderboard/internal"
+	"leaderboard/repositories"
+)
+
+// Types of the leaderboard messages pushed to the clients
+const (
+	LeaderboardSnapshotType = "leaderboard_snapshot"
+	LeaderboardDeltaType    = "leaderboard_delta"
+)
+
+// leaderboardTopN is the number of users pushed to the clients for each competition
+const leaderboardTopN = 10
+
+// LeaderboardEntry is a user's position in the top users of a competition
+type LeaderboardEntry struct {
+	Rank   int     `json:"rank"`
+	UserID uint    `json:"user_id"`
+	Score  float64 `json:"score"`
+}
+
+// LeaderboardChange is an entry of the top users that moved, changed score or entered the top users.
+// PreviousRank is 0 for users that were not in the top users.
+type LeaderboardChange struct {
+	LeaderboardEntry
+	PreviousRank int `json:"previous_rank"`
+}
+
+// LeaderboardSnapshotMessage is the full list of top users of a competition at a sequence number
+type LeaderboardSnapshotMessage struct {
+	Type          string              `json:"type"`
+	CompetitionID uint                `json:"competition_id"`
+	Seq           uint64              `json:"seq"`
+	Entries       []*LeaderboardEntry `json:"entries"`
+}
+
+// LeaderboardDeltaMessage holds the changes of the top users of a competition since the previous sequence number.
+// Removed are the users that left the top users.
+type LeaderboardDeltaMessage struct {
+	Type          string               `json:"type"`
+	CompetitionID uint                 `json:"competition_id"`
+	Seq           uint64               `json:"seq"`
+	Changes       []*LeaderboardChange `json:"changes"`
+	Removed       []uint               `json:"removed"`
+}
+
+// leaderboardState is the last top users pushed for a competition
+type leaderboardState struct {
+	seq     uint64
+	entries []*LeaderboardEntry
+}
+
+// LeaderboardUpdates encodes the changes of the top users of each competition as deltas with an increasing
+// sequence number per competition. Clients apply the deltas to a snapshot: a delta whose sequence number
+// is not the next one means an update was missed and a new snapshot is needed.
+type LeaderboardUpdates struct {
+	leaderboardsRepo repositories.LeaderboardsRepository
+	mutex            sync.Mutex
+	states           map[uint]*leaderboardState // map[competitionID]state
+	topN             int
+}
+
+// NewLeaderboardUpdates creates a LeaderboardUpdates that reads the top users from the repository
+func NewLeaderboardUpdates(leaderboardsRepo repositories.LeaderboardsRepository) *LeaderboardUpdates {
+	return &LeaderboardUpdates{
+		leaderboardsRepo: leaderboardsRepo,
+		states:           map[uint]*leaderboardState{},
+		topN:             leaderboardTopN,
+	}
+}
+
+// Publish sends the changes of the top users of a competition since the last delta or snapshot to the publisher.
+// Nothing is sent if the top users did not change, and the first delta of a competition holds all its entries.
+// Deltas are built and sent one at a time, so they reach the publisher in sequence order.
+func (lu *LeaderboardUpdates) Publish(publisher internal.MessageSender, competitionID uint) error {
+	lu.mutex.Lock()
+	defer lu.mutex.Unlock()
+
+	entries, err := lu.readEntries(competitionID)
+	if err != nil {
+		return err
+	}
+	state, exists := lu.states[competitionID]
+	if !exists {
+		// Nothing has been pushed for the competition yet, every entry is new
+		state = &leaderboardState{}
+		lu.states[competitionID] = state
+	}
+	changes, removed := diffEntries(state.entries, entries)
+	if len(changes) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	state.seq++
+	state.entries = entries
+	return publisher.SendCompetitionMessage(competitionID, &LeaderboardDeltaMessage{
+		Type:          LeaderboardDeltaType,
+		CompetitionID: competitionID,
+		Seq:           state.seq,
+		Changes:       changes,
+		Removed:       removed,
+	})
+}
+
+// Snapshot passes the top users of a competition at the latest sequence number to send.
+// No delta is published while send runs, so a snapshot queued by send is never overtaken by a later delta.
+// Clients must be subscribed to the deltas before the snapshot is taken, so no delta is missed. A delta published
+// in between is queued before the snapshot, and dropped by the client as its sequence number is at or below the
+// snapshot's.
+func (lu *LeaderboardUpdates) Snapshot(competitionID uint, send func(message *LeaderboardSnapshotMessage)) error {
+	lu.mutex.Lock()
+	defer lu.mutex.Unlock()
+
+	state, err := lu.stateLocked(competitionID)
+	if err != nil {
+		return err
+	}
+	send(&LeaderboardSnapshotMessage{
+		Type:          LeaderboardSnapshotType,
+		CompetitionID: competitionID,
+		Seq:           state.seq,
+		Entries:       state.entries,
+	})
+	return nil
+}
+
+// stateLocked returns the last state pushed for a competition, reading it if nothing has been pushed or snapshotted yet.
+// The caller must hold the mutex.
+func (lu *LeaderboardUpdates) stateLocked(competitionID uint) (*leaderboardState, error) {
+	if state, exists := lu.states[competitionID]; exists {
+		return state, nil
+	}
+	entries, err := lu.readEntries(competitionID)
+	if err != nil {
+		return nil, err
+	}
+	state := &leaderboardState{entries: entries}
+	lu.states[competitionID] = state
+	return state, nil
+}
+
+// readEntries reads the current top users of a competition, ranked with the competition's ranking mode
+func (lu *LeaderboardUpdates) readEntries(competitionID uint) ([]*LeaderboardEntry, error) {
+	page, err := lu.leaderboardsRepo.GetPage(competitionID, 0, lu.topN, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving top users of competition %d: %w", competitionID, err)
+	}
+	return newLeaderboardEntries(page.Users), nil
+}
+
+// newLeaderboardEntries returns the entries of ranked users
+func newLeaderboardEntries(users []*common.RankedUser) []*LeaderboardEntry {
+	entries := make([]*LeaderboardEntry, 0, len(users))
+	for _, user := range users {
+		entries = append(entries, &LeaderboardEntry{Rank: user.Rank, UserID: user.ID, Score: user.Score})
+	}
+	return entries
+}
+
+// diffEntries returns the entries that are new or whose rank or score changed, and the users that are no longer ranked
+func diffEntries(previous, current []*LeaderboardEntry) ([]*LeaderboardChange, []uint) {
+	previousByUser := make(map[uint]*LeaderboardEntry, len(previous))
+	for _, entry := range previous {
+		previousByUser[entry.UserID] = entry
+	}
+
+	changes := []*LeaderboardChange{}
+	currentUsers := make(map[uint]bool, len(current))
+	for _, entry := range current {
+		currentUsers[entry.UserID] = true
+		old, existed := previousByUser[entry.UserID]
+		if existed && old.Rank == entry.Rank && old.Score == entry.Score {
+			continue
+		}
+		change := &LeaderboardChange{LeaderboardEntry: *entry}
+		if existed {
+			change.PreviousRank = old.Rank
+		}
+		changes = append(changes, change)
+	}
+
+	removed := []uint{}
+	for _, entry := range previous {
+		if !currentUsers[entry.UserID] {
+			removed = append(removed, entry.UserID)
+		}
+	}
+	return changes, removed
+}
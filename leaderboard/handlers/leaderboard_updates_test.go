@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"common"
+	"errors"
+	"reflect"
+	"testing"
+
+	"leaderboard/repositories"
+)
+
+// mockPublisher records the competition messages it is sent
+type mockPublisher struct {
+	messages []any
+}
+
+func (m *mockPublisher) SendCompetitionMessage(competitionID uint, message any) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func TestLeaderboardUpdates_Publish(t *testing.T) {
+	topUsers := []*common.User{{ID: 1, Score: 300}, {ID: 2, Score: 200}, {ID: 3, Score: 100}}
+	repo := &repositories.MockLeaderboardsRepo{
+		GetTopNFunc: func(competitionID uint, n int) ([]*common.User, error) {
+			if n >= 0 && len(topUsers) > n {
+				return topUsers[:n], nil
+			}
+			return topUsers, nil
+		},
+	}
+	updates := NewLeaderboardUpdates(repo)
+	updates.topN = 3
+	publisher := &mockPublisher{}
+
+	// The first snapshot is read from the repository
+	var snapshot *LeaderboardSnapshotMessage
+	updates.Snapshot(1, func(message *LeaderboardSnapshotMessage) { snapshot = message })
+	if snapshot.Type != LeaderboardSnapshotType || snapshot.Seq != 0 || len(snapshot.Entries) != 3 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+
+	// User 3 overtakes user 2 and user 4 enters the top users, pushing user 2 out
+	topUsers = []*common.User{{ID: 1, Score: 300}, {ID: 3, Score: 250}, {ID: 4, Score: 220}, {ID: 2, Score: 200}}
+	if err := updates.Publish(publisher, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	delta := publisher.messages[0].(*LeaderboardDeltaMessage)
+	expectedChanges := []*LeaderboardChange{
+		{LeaderboardEntry: LeaderboardEntry{Rank: 2, UserID: 3, Score: 250}, PreviousRank: 3},
+		{LeaderboardEntry: LeaderboardEntry{Rank: 3, UserID: 4, Score: 220}, PreviousRank: 0},
+	}
+	if delta.Type != LeaderboardDeltaType || delta.CompetitionID != 1 || delta.Seq != 1 {
+		t.Errorf("unexpected delta: %+v", delta)
+	}
+	if !reflect.DeepEqual(delta.Changes, expectedChanges) {
+		t.Errorf("expected changes %+v, got %+v", expectedChanges, delta.Changes)
+	}
+	if !reflect.DeepEqual(delta.Removed, []uint{2}) {
+		t.Errorf("expected user 2 to be removed, got %v", delta.Removed)
+	}
+
+	// Nothing is published when the top users did not change
+	if err := updates.Publish(publisher, 1); err != nil || len(publisher.messages) != 1 {
+		t.Errorf("expected no delta without changes, got %d messages (err %v)", len(publisher.messages), err)
+	}
+
+	// A score change is a delta with the next sequence number
+	topUsers = []*common.User{{ID: 1, Score: 310}, {ID: 3, Score: 250}, {ID: 4, Score: 220}}
+	updates.Publish(publisher, 1)
+	delta = publisher.messages[1].(*LeaderboardDeltaMessage)
+	if delta.Seq != 2 || len(delta.Changes) != 1 || delta.Changes[0].UserID != 1 || delta.Changes[0].PreviousRank != 1 || len(delta.Removed) != 0 {
+		t.Errorf("unexpected delta: %+v", delta)
+	}
+
+	// Snapshots are taken at the latest sequence number
+	updates.Snapshot(1, func(message *LeaderboardSnapshotMessage) { snapshot = message })
+	if snapshot.Seq != 2 || snapshot.Entries[0].Score != 310 {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+
+	// Sequence numbers are per competition, and the first delta of a competition holds all its entries
+	updates.Publish(publisher, 2)
+	delta = publisher.messages[2].(*LeaderboardDeltaMessage)
+	if delta.CompetitionID != 2 || delta.Seq != 1 || len(delta.Changes) != 3 {
+		t.Errorf("unexpected first delta: %+v", delta)
+	}
+}
+
+func TestLeaderboardUpdates_Error(t *testing.T) {
+	updates := NewLeaderboardUpdates(&repositories.MockLeaderboardsRepo{ReturnErr: errors.New("db down")})
+	publisher := &mockPublisher{}
+	if err := updates.Publish(publisher, 1); err == nil {
+		t.Errorf("expected error when the top users cannot be read")
+	}
+	if err := updates.Snapshot(1, func(*LeaderboardSnapshotMessage) {}); err == nil {
+		t.Errorf("expected error when the top users cannot be read")
+	}
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"errors"
+
+	"leaderboard/internal"
+)
+
+// Publishers sends competition messages to every channel clients can follow them on (websocket, SSE...)
+type Publishers []internal.MessageSender
+
+// SendCompetitionMessage sends the message to every publisher, a failing publisher does not prevent the others from sending it
+func (p Publishers) SendCompetitionMessage(competitionID uint, message any) error {
+	var errs []error
+	for _, publisher := range p {
+		if err := publisher.SendCompetitionMessage(competitionID, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
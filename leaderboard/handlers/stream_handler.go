@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"leaderboard/repositories"
+)
+
+const (
+	// streamHistorySize is the number of updates kept per competition to resume reconnecting clients
+	streamHistorySize = 100
+	// streamSendBufferSize is the number of updates queued per client before it is considered too slow
+	streamSendBufferSize = 64
+	// streamKeepAlivePeriod is how often a comment is sent to idle clients so that proxies keep the connection open
+	streamKeepAlivePeriod = 30 * time.Second
+	// streamIdleTimeout is how long the history of a competition without clients is kept for clients to resume
+	streamIdleTimeout = 5 * time.Minute
+)
+
+// streamEvent is an update of a competition, identified by its position in the streams of the handler
+type streamEvent struct {
+	id   uint64
+	data []byte
+}
+
+// competitionStream holds the recent updates of a competition and the clients streaming it
+type competitionStream struct {
+	lastID        uint64        // ID of the latest event, or the last ID of the handler when the stream was created
+	resumableFrom uint64        // Clients whose last event is older have missed events that are no longer in the history
+	history       []streamEvent // oldest first
+	subscribers   map[chan streamEvent]bool
+	idleSince     time.Time // Zero while the stream has clients
+}
+
+// StreamHandler streams the updates of a competition to clients as Server-Sent Events.
+// Each update gets an increasing ID, and the latest updates are kept so that a client reconnecting with
+// a Last-Event-ID header receives the ones it missed. Clients that are too far behind to be resumed
+// receive a snapshot of the current top users instead.
+//
+// Event IDs are "<epoch>-<id>": the epoch identifies the process, so IDs received before a restart force a
+// snapshot, and IDs increase across all the streams, so IDs of a stream removed while idle are never resumed
+// from the stream created for the competition afterwards.
+type StreamHandler struct {
+	updates          *LeaderboardUpdates
+	competitionsRepo repositories.CompetitionsRepository
+	mutex            sync.Mutex
+	streams          map[uint]*competitionStream // map[competitionID]stream
+	epoch            string
+	lastID           uint64
+	historySize      int
+	sendBufferSize   int
+	keepAlivePeriod  time.Duration
+	idleTimeout      time.Duration
+	lastSweep        time.Time
+}
+
+// NewStreamHandler creates a StreamHandler that sends snapshots of the updates to new clients.
+// Only the competitions of the repository can be streamed.
+func NewStreamHandler(updates *LeaderboardUpdates, competitionsRepo repositories.CompetitionsRepository) *StreamHandler {
+	return &StreamHandler{
+		updates:          updates,
+		competitionsRepo: competitionsRepo,
+		streams:          map[uint]*competitionStream{},
+		epoch:            strconv.FormatInt(time.Now().UnixNano(), 36),
+		historySize:      streamHistorySize,
+		sendBufferSize:   streamSendBufferSize,
+		keepAlivePeriod:  streamKeepAlivePeriod,
+		idleTimeout:      streamIdleTimeout,
+	}
+}
+
+// SendCompetitionMessage appends the message to the competition's stream and queues it to its clients.
+// Clients whose queue is full are disconnected, they can resume from their last received update.
+func (sh *StreamHandler) SendCompetitionMessage(competitionID uint, message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error encoding stream message: %w", err)
+	}
+
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.removeIdleStreamsLocked()
+	stream := sh.streamLocked(competitionID)
+	sh.lastID++
+	event := streamEvent{id: sh.lastID, data: data}
+	stream.lastID = event.id
+	stream.history = append(stream.history, event)
+	if dropped := len(stream.history) - sh.historySize; dropped > 0 {
+		stream.resumableFrom = stream.history[dropped-1].id
+		stream.history = stream.history[dropped:]
+	}
+
+	for subscriber := range stream.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			sh.removeSubscriberLocked(stream, subscriber)
+		}
+	}
+	return nil
+}
+
+// StreamLeaderboard streams the updates of a competition until the client disconnects
+func (sh *StreamHandler) StreamLeaderboard(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid leaderboard id"))
+		return
+	}
+	competitionID := uint(id)
+
+	lastEventID, err := sh.parseEventID(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid Last-Event-ID"))
+		return
+	}
+
+	if _, err := sh.competitionsRepo.GetByID(competitionID); err != nil {
+		if errors.Is(err, repositories.ErrCompetitionNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("competition with id %d not found", competitionID)))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("failed to get competition: %v", err)))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("streaming is not supported"))
+		return
+	}
+
+	subscriber, missed, snapshotID, resumed := sh.subscribe(competitionID, lastEventID)
+	defer sh.unsubscribe(competitionID, subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable response buffering in nginx
+	w.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		// The client has been subscribed above, as LeaderboardUpdates.Snapshot requires
+		var snapshot *LeaderboardSnapshotMessage
+		err := sh.updates.Snapshot(competitionID, func(message *LeaderboardSnapshotMessage) {
+			snapshot = message
+		})
+		if err != nil {
+			fmt.Printf("Error retrieving snapshot of competition %d: %v\n", competitionID, err)
+			return
+		}
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			fmt.Printf("Error encoding snapshot of competition %d: %v\n", competitionID, err)
+			return
+		}
+		missed = []streamEvent{{id: snapshotID, data: data}}
+	}
+	for _, event := range missed {
+		sh.writeStreamEvent(w, event)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sh.keepAlivePeriod)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscriber:
+			if !ok {
+				return // The client was too slow and has been disconnected
+			}
+			sh.writeStreamEvent(w, event)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// parseEventID parses the Last-Event-ID header of a client. It returns nil if the header is empty or the ID is
+// from another process, in which case the client needs a snapshot.
+func (sh *StreamHandler) parseEventID(header string) (*uint64, error) {
+	epoch, id, found := strings.Cut(header, "-")
+	if !found || epoch != sh.epoch {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// subscribe registers a client to a competition's stream. If the client's last event is still in the history,
+// it returns the events the client missed and resumed is true. Otherwise the client needs a snapshot,
+// which takes the ID of the latest event.
+func (sh *StreamHandler) subscribe(competitionID uint, lastEventID *uint64) (subscriber chan streamEvent, missed []streamEvent, snapshotID uint64, resumed bool) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.removeIdleStreamsLocked()
+	stream := sh.streamLocked(competitionID)
+	subscriber = make(chan streamEvent, sh.sendBufferSize)
+	stream.subscribers[subscriber] = true
+	stream.idleSince = time.Time{}
+
+	if lastEventID == nil {
+		return subscriber, nil, stream.lastID, false // New client, or IDs from before a restart
+	}
+	if *lastEventID < stream.resumableFrom || *lastEventID > stream.lastID {
+		return subscriber, nil, stream.lastID, false // The missed events are no longer in the history
+	}
+	for _, event := range stream.history {
+		if event.id > *lastEventID {
+			missed = append(missed, event)
+		}
+	}
+	return subscriber, missed, stream.lastID, true
+}
+
+// unsubscribe removes a client from a competition's stream
+func (sh *StreamHandler) unsubscribe(competitionID uint, subscriber chan streamEvent) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	if stream, exists := sh.streams[competitionID]; exists && stream.subscribers[subscriber] {
+		sh.removeSubscriberLocked(stream, subscriber)
+	}
+}
+
+// removeSubscriberLocked removes a client from a stream and closes its queue, the stream becomes idle when its
+// last client is removed. The caller must hold the mutex.
+func (sh *StreamHandler) removeSubscriberLocked(stream *competitionStream, subscriber chan streamEvent) {
+	delete(stream.subscribers, subscriber)
+	close(subscriber)
+	if len(stream.subscribers) == 0 {
+		stream.idleSince = time.Now()
+	}
+}
+
+// removeIdleStreamsLocked removes the streams that have been without clients for longer than the idle timeout,
+// such as the streams of deleted competitions. Streams are checked at most once per idle timeout.
+// The caller must hold the mutex.
+func (sh *StreamHandler) removeIdleStreamsLocked() {
+	now := time.Now()
+	if now.Sub(sh.lastSweep) < sh.idleTimeout {
+		return
+	}
+	sh.lastSweep = now
+	for competitionID, stream := range sh.streams {
+		if len(stream.subscribers) == 0 && now.Sub(stream.idleSince) >= sh.idleTimeout {
+			delete(sh.streams, competitionID)
+		}
+	}
+}
+
+// streamLocked returns the stream of a competition, creating it if needed. A new stream is idle until a client
+// subscribes to it, and only the events sent after its creation can be resumed.
+// The caller must hold the mutex.
+func (sh *StreamHandler) streamLocked(competitionID uint) *competitionStream {
+	stream, exists := sh.streams[competitionID]
+	if !exists {
+		stream = &competitionStream{
+			lastID:        sh.lastID,
+			resumableFrom: sh.lastID,
+			subscribers:   map[chan streamEvent]bool{},
+			idleSince:     time.Now(),
+		}
+		sh.streams[competitionID] = stream
+	}
+	return stream
+}
+
+// writeStreamEvent writes an event in the Server-Sent Events format
+func (sh *StreamHandler) writeStreamEvent(w http.ResponseWriter, event streamEvent) {
+	fmt.Fprintf(w, "id: %s-%d\ndata: %s\n\n", sh.epoch, event.id, event.data)
+}
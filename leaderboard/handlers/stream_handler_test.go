@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"bufio"
+	"common"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"leaderboard/repositories"
+)
+
+// receivedStreamEvent is an event read from an SSE stream
+type receivedStreamEvent struct {
+	ID      string
+	Message LeaderboardSnapshotMessage
+}
+
+func newTestStreamServer(sh *StreamHandler) *httptest.Server {
+	r := mux.NewRouter()
+	r.HandleFunc("/leaderboards/{id}/stream", sh.StreamLeaderboard).Methods("GET")
+	return httptest.NewServer(r)
+}
+
+// newTestStreamHandler creates a StreamHandler for competitions 1 and 2, whose snapshots have a single user with
+// ID 10 times the competition ID
+func newTestStreamHandler() *StreamHandler {
+	updates := NewLeaderboardUpdates(&repositories.MockLeaderboardsRepo{
+		GetTopNFunc: func(competitionID uint, n int) ([]*common.User, error) {
+			return []*common.User{{ID: competitionID * 10, Score: 100}}, nil
+		},
+	})
+	return NewStreamHandler(updates, &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{1: {ID: 1}, 2: {ID: 2}}})
+}
+
+// streamEventID returns the Last-Event-ID of an event sent by the handler
+func streamEventID(sh *StreamHandler, id uint64) string {
+	return fmt.Sprintf("%s-%d", sh.epoch, id)
+}
+
+// openStream connects to the stream of a competition, resuming after lastEventID if it is not empty
+func openStream(t *testing.T, server *httptest.Server, competitionID string, lastEventID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", server.URL+"/leaderboards/"+competitionID+"/stream", nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// readStreamEvent reads the next event of an SSE stream, skipping comments
+func readStreamEvent(t *testing.T, reader *bufio.Reader) receivedStreamEvent {
+	t.Helper()
+	result := make(chan receivedStreamEvent, 1)
+	go func() {
+		var event receivedStreamEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(result)
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && event.ID != "":
+				result <- event
+				return
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Message)
+			}
+		}
+	}()
+	select {
+	case event, ok := <-result:
+		if !ok {
+			t.Fatalf("stream closed before an event was received")
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for a stream event")
+	}
+	return receivedStreamEvent{}
+}
+
+// waitForStreamSubscribers waits until the competition's stream has the expected number of clients
+func waitForStreamSubscribers(t *testing.T, sh *StreamHandler, competitionID uint, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sh.mutex.Lock()
+		count := 0
+		if stream, exists := sh.streams[competitionID]; exists {
+			count = len(stream.subscribers)
+		}
+		sh.mutex.Unlock()
+		if count == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d stream clients, got %d", expected, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamHandler_SnapshotAndUpdates(t *testing.T) {
+	sh := newTestStreamHandler()
+	server := newTestStreamServer(sh)
+	defer server.Close()
+
+	resp, reader := openStream(t, server, "1", "")
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("expected text/event-stream content type, got %q", contentType)
+	}
+
+	snapshot := readStreamEvent(t, reader)
+	if snapshot.ID != streamEventID(sh, 0) || len(snapshot.Message.Entries) != 1 || snapshot.Message.Entries[0].UserID != 10 {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+
+	// Only the updates of the streamed competition are received, event IDs increase across competitions
+	sh.SendCompetitionMessage(2, LeaderboardSnapshotMessage{CompetitionID: 2})
+	sh.SendCompetitionMessage(1, LeaderboardSnapshotMessage{CompetitionID: 1, Entries: []*LeaderboardEntry{{Rank: 1, UserID: 7, Score: 50}}})
+	update := readStreamEvent(t, reader)
+	if update.ID != streamEventID(sh, 2) || update.Message.CompetitionID != 1 || update.Message.Entries[0].UserID != 7 {
+		t.Errorf("unexpected update: %+v", update)
+	}
+}
+
+func TestStreamHandler_ResumeFromLastEventID(t *testing.T) {
+	sh := newTestStreamHandler()
+	server := newTestStreamServer(sh)
+	defer server.Close()
+
+	for i := uint(1); i <= 3; i++ {
+		sh.SendCompetitionMessage(1, LeaderboardSnapshotMessage{CompetitionID: 1, Entries: []*LeaderboardEntry{{Rank: 1, UserID: i}}})
+	}
+
+	resp, reader := openStream(t, server, "1", streamEventID(sh, 1))
+	defer resp.Body.Close()
+
+	// The updates missed since the last received event are replayed, then live updates follow
+	for _, expectedID := range []string{streamEventID(sh, 2), streamEventID(sh, 3)} {
+		if event := readStreamEvent(t, reader); event.ID != expectedID {
+			t.Errorf("expected missed event %s, got %+v", expectedID, event)
+		}
+	}
+	waitForStreamSubscribers(t, sh, 1, 1)
+	sh.SendCompetitionMessage(1, LeaderboardSnapshotMessage{CompetitionID: 1})
+	if event := readStreamEvent(t, reader); event.ID != streamEventID(sh, 4) {
+		t.Errorf("expected live event 4, got %+v", event)
+	}
+}
+
+func TestStreamHandler_ResumeTooOld(t *testing.T) {
+	sh := newTestStreamHandler()
+	sh.historySize = 2
+	server := newTestStreamServer(sh)
+	defer server.Close()
+
+	for i := 0; i < 5; i++ {
+		sh.SendCompetitionMessage(1, LeaderboardSnapshotMessage{CompetitionID: 1})
+	}
+
+	// Events 2 and 3 are no longer in the history, so a snapshot is sent instead
+	resp, reader := openStream(t, server, "1", streamEventID(sh, 1))
+	defer resp.Body.Close()
+	snapshot := readStreamEvent(t, reader)
+	if snapshot.ID != streamEventID(sh, 5) || len(snapshot.Message.Entries) != 1 || snapshot.Message.Entries[0].UserID != 10 {
+		t.Errorf("expected a snapshot with the latest event ID, got %+v", snapshot)
+	}
+
+	// IDs from before a restart have another epoch, a snapshot is sent too even if the ID is in the history
+	for _, staleID := range []string{"previous-5", "5"} {
+		resp, reader = openStream(t, server, "1", staleID)
+		defer resp.Body.Close()
+		if snapshot := readStreamEvent(t, reader); snapshot.ID != streamEventID(sh, 5) || len(snapshot.Message.Entries) != 1 {
+			t.Errorf("expected a snapshot for the stale ID %q, got %+v", staleID, snapshot)
+		}
+	}
+}
+
+func TestStreamHandler_RemovesIdleStreams(t *testing.T) {
+	sh := newTestStreamHandler()
+	sh.idleTimeout = 0 // Streams are removed as soon as they have no clients
+	server := newTestStreamServer(sh)
+	defer server.Close()
+
+	subscriber, _, _, _ := sh.subscribe(1, nil)
+	sh.SendCompetitionMessage(1, LeaderboardSnapshotMessage{CompetitionID: 1})
+	sh.SendCompetitionMessage(2, LeaderboardSnapshotMessage{CompetitionID: 2})
+	sh.unsubscribe(1, subscriber)
+
+	// The next event removes the streams without clients before it creates its own
+	sh.SendCompetitionMessage(2, LeaderboardSnapshotMessage{CompetitionID: 2})
+	sh.mutex.Lock()
+	_, kept1 := sh.streams[1]
+	stream2 := sh.streams[2]
+	sh.mutex.Unlock()
+	if kept1 || stream2 == nil || len(stream2.history) != 1 {
+		t.Fatalf("expected only the new stream of competition 2 to be kept, got %+v", sh.streams)
+	}
+
+	// The events of a removed stream cannot be resumed, even though their IDs are from this process
+	resp, reader := openStream(t, server, "1", streamEventID(sh, 1))
+	defer resp.Body.Close()
+	if snapshot := readStreamEvent(t, reader); snapshot.ID != streamEventID(sh, 3) || len(snapshot.Message.Entries) != 1 {
+		t.Errorf("expected a snapshot after the stream was removed, got %+v", snapshot)
+	}
+}
+
+func TestStreamHandler_DropsSlowClient(t *testing.T) {
+	sh := newTestStreamHandler()
+	subscriber, _, _, _ := sh.subscribe(1, nil)
+	for i := 0; i <= sh.sendBufferSize; i++ {
+		sh.SendCompetitionMessage(1, LeaderboardSnapshotMessage{CompetitionID: 1})
+	}
+
+	received := 0
+	for range subscriber {
+		received++
+	}
+	if received != sh.sendBufferSize {
+		t.Errorf("expected the %d queued events before the client was dropped, got %d", sh.sendBufferSize, received)
+	}
+	sh.unsubscribe(1, subscriber) // Unsubscribing a dropped client is a no-op
+}
+
+func TestStreamHandler_BadRequest(t *testing.T) {
+	sh := newTestStreamHandler()
+	server := newTestStreamServer(sh)
+	defer server.Close()
+
+	resp, _ := openStream(t, server, "abc", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid id, got %d", resp.StatusCode)
+	}
+
+	resp, _ = openStream(t, server, "1", sh.epoch+"-not-an-id")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid Last-Event-ID, got %d", resp.StatusCode)
+	}
+
+	resp, _ = openStream(t, server, "3", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown competition, got %d", resp.StatusCode)
+	}
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	if _, exists := sh.streams[3]; exists {
+		t.Error("expected no stream to be created for an unknown competition")
+	}
+}
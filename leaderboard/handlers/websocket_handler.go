@@ -1,22 +1,87 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+
+	"leaderboard/repositories"
+)
+
+const (
+	// websocketWriteWait is the time allowed to write a message to a client
+	websocketWriteWait = 10 * time.Second
+	// websocketPongWait is the time allowed to read the next pong from a client before it is disconnected
+	websocketPongWait = 60 * time.Second
+	// websocketPingPeriod is how often clients are pinged, it must be shorter than websocketPongWait
+	websocketPingPeriod = websocketPongWait * 9 / 10
+	// websocketSendBufferSize is the number of messages queued per client before it is considered too slow
+	websocketSendBufferSize = 256
+)
+
+// Types of the messages clients send to manage their subscriptions and request snapshots
+const (
+	websocketSubscribe   = "subscribe"
+	websocketUnsubscribe = "unsubscribe"
+	websocketSnapshot    = "snapshot"
 )
 
+// websocketRequest is a message sent by a client to subscribe to or unsubscribe from competitions,
+// or to request a new snapshot after missing a delta.
+// All subscribes to (or unsubscribes from) every competition, including the ones created later.
+type websocketRequest struct {
+	Type           string `json:"type"`
+	CompetitionIDs []uint `json:"competition_ids"`
+	All            bool   `json:"all"`
+}
+
+// websocketError is sent to a client whose request could not be processed
+type websocketError struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// websocketClient is a connected client with its own queue of messages waiting to be written
+// and the competitions it is subscribed to. Subscriptions are guarded by the hub's mutex.
+type websocketClient struct {
+	conn          *websocket.Conn
+	send          chan []byte
+	subscriptions map[uint]bool
+	all           bool
+}
+
+// isSubscribed reports whether the client receives the updates of the competition
+func (c *websocketClient) isSubscribed(competitionID uint) bool {
+	return c.all || c.subscriptions[competitionID]
+}
+
+// WebsocketHandler is a hub that pushes competition updates to the clients subscribed to them.
+// Each client has a buffered send queue written by its own goroutine, so a slow client never blocks
+// the sender: clients whose queue is full are disconnected.
 type WebsocketHandler struct {
-	Connection      *websocket.Conn
-	ConnectionMutex *sync.Mutex
+	updates          *LeaderboardUpdates
+	competitionsRepo repositories.CompetitionsRepository
+	clientsMutex     sync.Mutex
+	clients          map[*websocketClient]bool
+	pingPeriod       time.Duration
+	pongWait         time.Duration
+	sendBufferSize   int
 }
 
-func NewWebsocketHandler() *WebsocketHandler {
+// NewWebsocketHandler creates a hub that sends snapshots of the updates to new subscribers.
+// The competitions repository lists the competitions of the snapshots sent when subscribing to all of them.
+func NewWebsocketHandler(updates *LeaderboardUpdates, competitionsRepo repositories.CompetitionsRepository) *WebsocketHandler {
 	return &WebsocketHandler{
-		Connection:      nil,
-		ConnectionMutex: &sync.Mutex{},
+		updates:          updates,
+		competitionsRepo: competitionsRepo,
+		clients:          map[*websocketClient]bool{},
+		pingPeriod:       websocketPingPeriod,
+		pongWait:         websocketPongWait,
+		sendBufferSize:   websocketSendBufferSize,
 	}
 }
 
@@ -29,35 +94,219 @@ func (wsh *WebsocketHandler) WebsocketHandler(w http.ResponseWriter, r *http.Req
 		fmt.Printf("Error in websocket upgrade: %v\n", err)
 		return
 	}
-	wsh.ConnectionMutex.Lock()
-	wsh.Connection = c
-	wsh.ConnectionMutex.Unlock()
 
-	// Wait for the client to disconnect
-	for {
-		if _, _, err := wsh.Connection.NextReader(); err != nil {
-			wsh.ConnectionMutex.Lock()
-			wsh.Connection = nil
-			wsh.ConnectionMutex.Unlock()
-			c.Close()
-			break
-		}
+	client := &websocketClient{
+		conn:          c,
+		send:          make(chan []byte, wsh.sendBufferSize),
+		subscriptions: map[uint]bool{},
 	}
+	wsh.register(client)
+
+	go wsh.writePump(client)
+	wsh.readPump(client)
+}
+
+// ClientCount returns the number of connected clients
+func (wsh *WebsocketHandler) ClientCount() int {
+	wsh.clientsMutex.Lock()
+	defer wsh.clientsMutex.Unlock()
+	return len(wsh.clients)
 }
 
+// SendMessage queues the message to every connected client, regardless of their subscriptions.
+// Clients whose send queue is full are disconnected instead of blocking the sender.
 func (wsh *WebsocketHandler) SendMessage(message any) error {
-	if wsh.ConnectionMutex == nil {
-		return fmt.Errorf("websocket connection mutex is not initialized")
-	}
-	wsh.ConnectionMutex.Lock()
-	defer wsh.ConnectionMutex.Unlock()
+	return wsh.broadcast(message, func(*websocketClient) bool { return true })
+}
+
+// SendCompetitionMessage queues the message to the clients subscribed to the competition
+func (wsh *WebsocketHandler) SendCompetitionMessage(competitionID uint, message any) error {
+	return wsh.broadcast(message, func(client *websocketClient) bool {
+		return client.isSubscribed(competitionID)
+	})
+}
 
-	if wsh.Connection == nil {
-		return fmt.Errorf("no WebSocket connection available")
+// broadcast queues the message to the connected clients accepted by the filter
+func (wsh *WebsocketHandler) broadcast(message any, filter func(*websocketClient) bool) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error encoding WebSocket message: %w", err)
 	}
 
-	if err := wsh.Connection.WriteJSON(message); err != nil {
-		return fmt.Errorf("error sending WebSocket message: %w", err)
+	wsh.clientsMutex.Lock()
+	defer wsh.clientsMutex.Unlock()
+
+	for client := range wsh.clients {
+		if filter(client) {
+			wsh.enqueueLocked(client, data)
+		}
 	}
 	return nil
 }
+
+// sendTo queues a message to a single client
+func (wsh *WebsocketHandler) sendTo(client *websocketClient, message any) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		fmt.Printf("Error encoding WebSocket message: %v\n", err)
+		return
+	}
+	wsh.clientsMutex.Lock()
+	defer wsh.clientsMutex.Unlock()
+	wsh.enqueueLocked(client, data)
+}
+
+// enqueueLocked queues data to a client, disconnecting it if its queue is full.
+// The caller must hold clientsMutex.
+func (wsh *WebsocketHandler) enqueueLocked(client *websocketClient, data []byte) {
+	if _, exists := wsh.clients[client]; !exists {
+		return // The client has already been removed and its queue closed
+	}
+	select {
+	case client.send <- data:
+	default:
+		fmt.Printf("Disconnecting slow WebSocket client %s\n", client.conn.RemoteAddr())
+		wsh.removeLocked(client)
+	}
+}
+
+func (wsh *WebsocketHandler) register(client *websocketClient) {
+	wsh.clientsMutex.Lock()
+	defer wsh.clientsMutex.Unlock()
+	wsh.clients[client] = true
+}
+
+func (wsh *WebsocketHandler) unregister(client *websocketClient) {
+	wsh.clientsMutex.Lock()
+	defer wsh.clientsMutex.Unlock()
+	wsh.removeLocked(client)
+}
+
+// removeLocked removes a client and closes its send queue, which makes its write pump close the connection.
+// The caller must hold clientsMutex.
+func (wsh *WebsocketHandler) removeLocked(client *websocketClient) {
+	if _, exists := wsh.clients[client]; !exists {
+		return
+	}
+	delete(wsh.clients, client)
+	close(client.send)
+}
+
+// handleRequest updates the subscriptions of a client and sends it a snapshot of the competitions it subscribed to
+// or requested a snapshot of
+func (wsh *WebsocketHandler) handleRequest(client *websocketClient, data []byte) {
+	var request websocketRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		wsh.sendTo(client, websocketError{Type: "error", Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	if !request.All && len(request.CompetitionIDs) == 0 {
+		wsh.sendTo(client, websocketError{Type: "error", Error: "competition_ids or all is required"})
+		return
+	}
+
+	switch request.Type {
+	case websocketSubscribe:
+		wsh.clientsMutex.Lock()
+		if request.All {
+			client.all = true
+		}
+		for _, id := range request.CompetitionIDs {
+			client.subscriptions[id] = true
+		}
+		wsh.clientsMutex.Unlock()
+		wsh.sendSnapshot(client, request)
+	case websocketUnsubscribe:
+		wsh.clientsMutex.Lock()
+		if request.All {
+			client.all = false
+			client.subscriptions = map[uint]bool{}
+		}
+		for _, id := range request.CompetitionIDs {
+			delete(client.subscriptions, id)
+		}
+		wsh.clientsMutex.Unlock()
+	case websocketSnapshot:
+		wsh.sendSnapshot(client, request)
+	default:
+		wsh.sendTo(client, websocketError{Type: "error", Error: fmt.Sprintf("unknown request type %q", request.Type)})
+	}
+}
+
+// sendSnapshot sends the current top users of the competitions of a request to the client.
+// The client must already be subscribed to them, see LeaderboardUpdates.Snapshot.
+func (wsh *WebsocketHandler) sendSnapshot(client *websocketClient, request websocketRequest) {
+	if wsh.updates == nil {
+		return
+	}
+	competitionIDs := request.CompetitionIDs
+	if request.All && wsh.competitionsRepo != nil {
+		competitions, err := wsh.competitionsRepo.GetAll()
+		if err != nil {
+			fmt.Printf("Error retrieving competitions for WebSocket snapshot: %v\n", err)
+			return
+		}
+		competitionIDs = make([]uint, 0, len(competitions))
+		for _, comp := range competitions {
+			competitionIDs = append(competitionIDs, comp.ID)
+		}
+	}
+
+	for _, id := range competitionIDs {
+		err := wsh.updates.Snapshot(id, func(message *LeaderboardSnapshotMessage) {
+			wsh.sendTo(client, message)
+		})
+		if err != nil {
+			fmt.Printf("Error retrieving snapshot of competition %d: %v\n", id, err)
+		}
+	}
+}
+
+// readPump reads the requests of the client until it disconnects or stops answering pings
+func (wsh *WebsocketHandler) readPump(client *websocketClient) {
+	defer func() {
+		wsh.unregister(client)
+		client.conn.Close()
+	}()
+
+	client.conn.SetReadDeadline(time.Now().Add(wsh.pongWait))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(wsh.pongWait))
+	})
+	for {
+		_, data, err := client.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		wsh.handleRequest(client, data)
+	}
+}
+
+// writePump writes the queued messages to the client and pings it periodically
+func (wsh *WebsocketHandler) writePump(client *websocketClient) {
+	ticker := time.NewTicker(wsh.pingPeriod)
+	defer func() {
+		ticker.Stop()
+		client.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+			if !ok {
+				// The client has been removed from the hub
+				client.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
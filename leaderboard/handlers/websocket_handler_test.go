@@ -1,58 +1,67 @@
 package handlers
 
 import (
+	"common"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/gorilla/websocket"
-)
 
-// TestWebsocketHandler_Upgrade tests that the handler upgrades the connection and stores it
-func TestWebsocketHandler_Upgrade(t *testing.T) {
-	wsh := NewWebsocketHandler()
-	server := httptest.NewServer(http.HandlerFunc(wsh.WebsocketHandler))
-	defer server.Close()
+	"leaderboard/repositories"
+)
 
+// dialWebsocket connects a client to the test server
+func dialWebsocket(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
 	// Convert http://127.0.0.1:port to ws://127.0.0.1:port
 	url := "ws" + server.URL[len("http"):]
-
 	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
 	if err != nil {
 		t.Fatalf("failed to dial websocket: %v", err)
 	}
-	defer ws.Close()
-
-	// Give the handler a moment to store the connection
-	time.Sleep(100 * time.Millisecond)
+	return ws
+}
 
-	wsh.ConnectionMutex.Lock()
-	defer wsh.ConnectionMutex.Unlock()
-	if wsh.Connection == nil {
-		t.Errorf("expected connection to be set")
+// waitForClients waits until the handler has registered the expected number of clients
+func waitForClients(t *testing.T, wsh *WebsocketHandler, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for wsh.ClientCount() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d clients, got %d", expected, wsh.ClientCount())
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
 }
 
+// TestWebsocketHandler_Upgrade tests that the handler upgrades the connection and registers the client
+func TestWebsocketHandler_Upgrade(t *testing.T) {
+	wsh := NewWebsocketHandler(nil, nil)
+	server := httptest.NewServer(http.HandlerFunc(wsh.WebsocketHandler))
+	defer server.Close()
+
+	ws := dialWebsocket(t, server)
+	waitForClients(t, wsh, 1)
+
+	// The client is removed once it disconnects
+	ws.Close()
+	waitForClients(t, wsh, 0)
+}
+
 // TestWebsocketHandler_SendMessage tests sending a message over the websocket
 func TestWebsocketHandler_SendMessage(t *testing.T) {
-	wsh := NewWebsocketHandler()
+	wsh := NewWebsocketHandler(nil, nil)
 	server := httptest.NewServer(http.HandlerFunc(wsh.WebsocketHandler))
 	defer server.Close()
 
-	url := "ws" + server.URL[len("http"):]
-
-	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
-	if err != nil {
-		t.Fatalf("failed to dial websocket: %v", err)
-	}
+	ws := dialWebsocket(t, server)
 	defer ws.Close()
-
-	// Wait for the handler to store the connection
-	time.Sleep(100 * time.Millisecond)
+	waitForClients(t, wsh, 1)
 
 	msg := map[string]any{"hello": "world"}
-	err = wsh.SendMessage(msg)
+	err := wsh.SendMessage(msg)
 	if err != nil {
 		t.Errorf("SendMessage returned error: %v", err)
 	}
@@ -67,11 +76,236 @@ func TestWebsocketHandler_SendMessage(t *testing.T) {
 	}
 }
 
+// TestWebsocketHandler_SendMessage_MultipleClients tests that every connected client receives every message
+func TestWebsocketHandler_SendMessage_MultipleClients(t *testing.T) {
+	wsh := NewWebsocketHandler(nil, nil)
+	server := httptest.NewServer(http.HandlerFunc(wsh.WebsocketHandler))
+	defer server.Close()
+
+	const clients = 5
+	connections := make([]*websocket.Conn, 0, clients)
+	for i := 0; i < clients; i++ {
+		ws := dialWebsocket(t, server)
+		defer ws.Close()
+		connections = append(connections, ws)
+	}
+	waitForClients(t, wsh, clients)
+
+	for i := 0; i < 3; i++ {
+		if err := wsh.SendMessage(map[string]any{"seq": i}); err != nil {
+			t.Fatalf("SendMessage returned error: %v", err)
+		}
+	}
+
+	for c, ws := range connections {
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for i := 0; i < 3; i++ {
+			var received map[string]any
+			if err := ws.ReadJSON(&received); err != nil {
+				t.Fatalf("client %d: failed to read message %d: %v", c, i, err)
+			}
+			if received["seq"] != float64(i) {
+				t.Errorf("client %d: expected message %d, got %+v", c, i, received)
+			}
+		}
+	}
+}
+
+// TestWebsocketHandler_SendMessage_DropsSlowClient tests that a client whose queue is full is disconnected
+// without blocking the other clients
+func TestWebsocketHandler_SendMessage_DropsSlowClient(t *testing.T) {
+	wsh := NewWebsocketHandler(nil, nil)
+	server := httptest.NewServer(http.HandlerFunc(wsh.WebsocketHandler))
+	defer server.Close()
+
+	ws := dialWebsocket(t, server)
+	defer ws.Close()
+	waitForClients(t, wsh, 1)
+
+	// A client whose queue is never drained
+	slow := &websocketClient{conn: ws, send: make(chan []byte, 1)}
+	wsh.register(slow)
+
+	for i := 0; i < 3; i++ {
+		if err := wsh.SendMessage(map[string]any{"seq": i}); err != nil {
+			t.Fatalf("SendMessage returned error: %v", err)
+		}
+	}
+
+	if wsh.ClientCount() != 1 {
+		t.Errorf("expected slow client to be removed, got %d clients", wsh.ClientCount())
+	}
+	if _, open := <-slow.send; !open {
+		t.Errorf("expected the queued message to be kept")
+	}
+	if _, open := <-slow.send; open {
+		t.Errorf("expected slow client queue to be closed")
+	}
+
+	// The other client still receives every message
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < 3; i++ {
+		var received map[string]any
+		if err := ws.ReadJSON(&received); err != nil {
+			t.Fatalf("failed to read message %d: %v", i, err)
+		}
+	}
+}
+
+// TestWebsocketHandler_PingPong tests that clients are pinged and that clients which do not answer are disconnected
+func TestWebsocketHandler_PingPong(t *testing.T) {
+	wsh := NewWebsocketHandler(nil, nil)
+	wsh.pingPeriod = 20 * time.Millisecond
+	wsh.pongWait = 100 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(wsh.WebsocketHandler))
+	defer server.Close()
+
+	// A client that reads answers pings with pongs and stays connected
+	pings := make(chan struct{}, 100)
+	alive := dialWebsocket(t, server)
+	defer alive.Close()
+	alive.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return alive.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := alive.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// A client that never reads does not answer pings
+	silent := dialWebsocket(t, server)
+	defer silent.Close()
+
+	waitForClients(t, wsh, 2)
+	select {
+	case <-pings:
+	case <-time.After(time.Second):
+		t.Fatalf("expected client to be pinged")
+	}
+
+	waitForClients(t, wsh, 1)
+	time.Sleep(3 * wsh.pongWait)
+	if wsh.ClientCount() != 1 {
+		t.Errorf("expected the client answering pings to stay connected")
+	}
+}
+
 // TestWebsocketHandler_SendMessage_NoConnection tests SendMessage when no connection is present
 func TestWebsocketHandler_SendMessage_NoConnection(t *testing.T) {
-	wsh := NewWebsocketHandler()
-	err := wsh.SendMessage(map[string]any{"test": 1})
-	if err == nil {
-		t.Errorf("expected error when no connection is available")
+	wsh := NewWebsocketHandler(nil, nil)
+	if err := wsh.SendMessage(map[string]any{"test": 1}); err != nil {
+		t.Errorf("expected no error without subscribers, got %v", err)
+	}
+	if err := wsh.SendMessage(map[string]any{"test": func() {}}); err == nil {
+		t.Errorf("expected error when the message cannot be encoded")
+	}
+}
+
+// readCompetitionUpdate reads the next competition update pushed to a client
+func readCompetitionUpdate(t *testing.T, ws *websocket.Conn) LeaderboardSnapshotMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var message LeaderboardSnapshotMessage
+	if err := ws.ReadJSON(&message); err != nil {
+		t.Fatalf("failed to read competition update: %v", err)
+	}
+	return message
+}
+
+// TestWebsocketHandler_Subscriptions tests that clients only receive the updates of the competitions they subscribed to
+func TestWebsocketHandler_Subscriptions(t *testing.T) {
+	leaderboardsRepo := &repositories.MockLeaderboardsRepo{
+		GetTopNFunc: func(competitionID uint, n int) ([]*common.User, error) {
+			return []*common.User{{ID: competitionID * 10, Score: 100}}, nil
+		},
+	}
+	competitionsRepo := &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{
+		1: {ID: 1}, 2: {ID: 2},
+	}}
+	wsh := NewWebsocketHandler(NewLeaderboardUpdates(leaderboardsRepo), competitionsRepo)
+	server := httptest.NewServer(http.HandlerFunc(wsh.WebsocketHandler))
+	defer server.Close()
+
+	ws := dialWebsocket(t, server)
+	defer ws.Close()
+	waitForClients(t, wsh, 1)
+
+	// Clients without subscriptions do not receive competition updates
+	wsh.SendCompetitionMessage(1, LeaderboardSnapshotMessage{CompetitionID: 1})
+
+	// Subscribing sends a snapshot of the competition
+	ws.WriteJSON(map[string]any{"type": "subscribe", "competition_ids": []uint{1}})
+	snapshot := readCompetitionUpdate(t, ws)
+	if snapshot.CompetitionID != 1 || len(snapshot.Entries) != 1 || snapshot.Entries[0].UserID != 10 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+
+	wsh.SendCompetitionMessage(2, LeaderboardSnapshotMessage{CompetitionID: 2})
+	wsh.SendCompetitionMessage(1, LeaderboardSnapshotMessage{CompetitionID: 1})
+	if update := readCompetitionUpdate(t, ws); update.CompetitionID != 1 {
+		t.Errorf("expected only updates of competition 1, got competition %d", update.CompetitionID)
+	}
+
+	// Subscribing to all sends a snapshot of every competition
+	ws.WriteJSON(map[string]any{"type": "subscribe", "all": true})
+	received := map[uint]bool{}
+	for i := 0; i < 2; i++ {
+		received[readCompetitionUpdate(t, ws).CompetitionID] = true
+	}
+	if !received[1] || !received[2] {
+		t.Errorf("expected snapshots of every competition, got %v", received)
+	}
+	wsh.SendCompetitionMessage(3, LeaderboardSnapshotMessage{CompetitionID: 3})
+	if update := readCompetitionUpdate(t, ws); update.CompetitionID != 3 {
+		t.Errorf("expected updates of competitions created later, got competition %d", update.CompetitionID)
+	}
+
+	// Unsubscribing from all stops every update
+	ws.WriteJSON(map[string]any{"type": "unsubscribe", "all": true})
+	ws.WriteJSON(map[string]any{"type": "subscribe", "competition_ids": []uint{2}})
+	if snapshot := readCompetitionUpdate(t, ws); snapshot.CompetitionID != 2 {
+		t.Fatalf("expected snapshot of competition 2, got competition %d", snapshot.CompetitionID)
+	}
+	wsh.SendCompetitionMessage(1, LeaderboardSnapshotMessage{CompetitionID: 1})
+	wsh.SendCompetitionMessage(2, LeaderboardSnapshotMessage{CompetitionID: 2})
+	if update := readCompetitionUpdate(t, ws); update.CompetitionID != 2 {
+		t.Errorf("expected only updates of competition 2, got competition %d", update.CompetitionID)
+	}
+
+	// Clients that missed a delta can request a new snapshot
+	ws.WriteJSON(map[string]any{"type": "snapshot", "competition_ids": []uint{2}})
+	if snapshot := readCompetitionUpdate(t, ws); snapshot.Type != LeaderboardSnapshotType || snapshot.CompetitionID != 2 {
+		t.Errorf("expected snapshot of competition 2, got %+v", snapshot)
+	}
+}
+
+// TestWebsocketHandler_InvalidRequest tests that clients are told when their request cannot be processed
+func TestWebsocketHandler_InvalidRequest(t *testing.T) {
+	wsh := NewWebsocketHandler(NewLeaderboardUpdates(&repositories.MockLeaderboardsRepo{}), &repositories.MockCompetitions{})
+	server := httptest.NewServer(http.HandlerFunc(wsh.WebsocketHandler))
+	defer server.Close()
+
+	ws := dialWebsocket(t, server)
+	defer ws.Close()
+
+	requests := []any{
+		"not a request",
+		map[string]any{"type": "subscribe"},
+		map[string]any{"type": "resubscribe", "all": true},
+	}
+	for _, request := range requests {
+		ws.WriteJSON(request)
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var response websocketError
+		if err := ws.ReadJSON(&response); err != nil {
+			t.Fatalf("failed to read response to %v: %v", request, err)
+		}
+		if response.Type != "error" || response.Error == "" {
+			t.Errorf("expected an error for request %v, got %+v", request, response)
+		}
 	}
 }
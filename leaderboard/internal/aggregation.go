@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"common"
+	"fmt"
+	"sort"
+)
+
+// SetAggregationDefault sets the default aggregation of a competition that does not set one
+func SetAggregationDefault(comp *common.Competition) {
+	if comp.Aggregation == "" {
+		comp.Aggregation = common.DefaultAggregation
+	}
+}
+
+// ValidateAggregation checks that the aggregation of a competition is supported.
+// bestN is required by the best_n aggregation and must not be set by the others.
+func ValidateAggregation(aggregation common.Aggregation, bestN int) error {
+	switch aggregation {
+	case common.AggregationSum, common.AggregationMax, common.AggregationCount, common.AggregationAverage:
+		if bestN != 0 {
+			return fmt.Errorf("best_n is only used by the best_n aggregation")
+		}
+	case common.AggregationBestN:
+		if bestN < 1 {
+			return fmt.Errorf("best_n must be at least 1, got %d", bestN)
+		}
+	default:
+		return fmt.Errorf("unknown aggregation %q, must be one of sum, max, count, average or best_n", aggregation)
+	}
+	return nil
+}
+
+// aggregate folds a rule result into a user's aggregation state and returns their new score.
+// Scores aggregated with sum are built from the current score, so scores stored before the
+// aggregation state existed keep adding up.
+func aggregate(comp *registeredCompetition, state *common.AggregationState, score, amount float64) float64 {
+	state.Count++
+	state.Sum += amount
+	switch comp.aggregation {
+	case common.AggregationMax:
+		state.Best = keepBest(state.Best, amount, 1)
+		return state.Best[0]
+	case common.AggregationCount:
+		return float64(state.Count)
+	case common.AggregationAverage:
+		return state.Sum / float64(state.Count)
+	case common.AggregationBestN:
+		state.Best = keepBest(state.Best, amount, comp.bestN)
+		total := 0.0
+		for _, result := range state.Best {
+			total += result
+		}
+		return total
+	default:
+		return score + amount
+	}
+}
+
+// keepBest inserts a result into the highest results, best first, and keeps at most n of them
+func keepBest(best []float64, result float64, n int) []float64 {
+	i := sort.Search(len(best), func(i int) bool { return best[i] < result })
+	if i >= n {
+		return best
+	}
+	best = append(best, 0)
+	copy(best[i+1:], best[i:])
+	best[i] = result
+	if len(best) > n {
+		best = best[:n]
+	}
+	return best
+}
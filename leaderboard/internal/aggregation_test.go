@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"common"
+	"reflect"
+	"testing"
+)
+
+func TestLeaderboard_Aggregations(t *testing.T) {
+	tests := []struct {
+		aggregation common.Aggregation
+		bestN       int
+		expected    float64
+	}{
+		{common.AggregationSum, 0, 100},
+		{common.AggregationMax, 0, 40},
+		{common.AggregationCount, 0, 4},
+		{common.AggregationAverage, 0, 25},
+		{common.AggregationBestN, 2, 70},
+		{"", 0, 100}, // Competitions without an aggregation are summed
+	}
+	for _, tt := range tests {
+		comp := &common.Competition{ID: 1, ScoreRule: "amount", Aggregation: tt.aggregation, BestN: tt.bestN}
+		evaluator := &MockRuleEvaluator{}
+		lb := NewLeaderboard(evaluator)
+		lb.RegisterCompetition(comp)
+
+		var updates []*UpdatedData
+		for i, amount := range []float64{10, 40, 20, 30} {
+			evaluator.Matches = []Match{{Rule: comp.ScoreRule, Result: amount}}
+			var err error
+			updates, err = lb.Update(common.BetEvent{EventID: uint(i), EventType: common.EventTypeWin, UserID: 7, ExchangeRate: 1.0})
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.aggregation, err)
+			}
+		}
+		if len(updates) != 1 || updates[0].Score != tt.expected {
+			t.Errorf("%s: expected score %v, got %+v", tt.aggregation, tt.expected, updates)
+		}
+	}
+}
+
+func TestLeaderboard_LoadAggregationStates(t *testing.T) {
+	comp := &common.Competition{ID: 1, ScoreRule: "amount", Aggregation: common.AggregationBestN, BestN: 2}
+	evaluator := &MockRuleEvaluator{Matches: []Match{{Rule: comp.ScoreRule, Result: 35.0}}}
+	lb := NewLeaderboard(evaluator)
+	lb.RegisterCompetition(comp)
+
+	// The score restored after a restart keeps being aggregated from its state
+	lb.Load(map[uint][]common.User{1: {{ID: 7, Score: 70}}})
+	lb.LoadAggregationStates(map[uint]map[uint]*common.AggregationState{1: {7: {Count: 3, Sum: 90, Best: []float64{40, 30}}}})
+
+	updates, err := lb.Update(common.BetEvent{EventID: 1, EventType: common.EventTypeWin, UserID: 7, ExchangeRate: 1.0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedState := common.AggregationState{Count: 4, Sum: 125, Best: []float64{40, 35}}
+	if len(updates) != 1 || updates[0].Score != 75 || !reflect.DeepEqual(updates[0].State, expectedState) {
+		t.Errorf("expected score 75 with state %+v, got %+v", expectedState, updates)
+	}
+}
+
+func TestValidateAggregation(t *testing.T) {
+	valid := []struct {
+		aggregation common.Aggregation
+		bestN       int
+	}{
+		{common.AggregationSum, 0}, {common.AggregationMax, 0}, {common.AggregationCount, 0},
+		{common.AggregationAverage, 0}, {common.AggregationBestN, 3},
+	}
+	for _, c := range valid {
+		if err := ValidateAggregation(c.aggregation, c.bestN); err != nil {
+			t.Errorf("%s/%d: unexpected error: %v", c.aggregation, c.bestN, err)
+		}
+	}
+
+	invalid := []struct {
+		aggregation common.Aggregation
+		bestN       int
+	}{
+		{"median", 0}, {common.AggregationBestN, 0}, {common.AggregationSum, 3},
+	}
+	for _, c := range invalid {
+		if err := ValidateAggregation(c.aggregation, c.bestN); err == nil {
+			t.Errorf("%s/%d: expected an error", c.aggregation, c.bestN)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"common"
+	"fmt"
+	"time"
+
+	"leaderboard/repositories"
+)
+
+// BackfillScheduler starts the backfill of new competitions
+type BackfillScheduler interface {
+	// Schedule starts backfilling a competition in the background and returns the job reporting its progress
+	Schedule(comp *common.Competition) (*common.Job, error)
+}
+
+// BackfillTarget abstracts the live leaderboard backfilled competitions are handed over to
+type BackfillTarget interface {
+	StartBackfill(competitionID uint)
+	CompleteBackfill(comp *common.Competition, replay *Replay) bool
+	CancelBackfill(competitionID uint)
+}
+
+// EventProcessor abstracts the processing of the live bet events
+type EventProcessor interface {
+	// Exclusive runs fn while no bet event is being processed
+	Exclusive(fn func() error) error
+	// PublishCompetition pushes the changes of the top users of a competition whose scores were replaced
+	// outside of the processing of bet events to the clients following it
+	PublishCompetition(competitionID uint)
+}
+
+// Backfiller scores new competitions from the bet events stored before they were created.
+//
+// A backfilled competition is not scored live until its backfill completes. The backfill first replays the events
+// received until it starts, then pauses the live processing of events to replay the events received since, store
+// the scores and register the competition in the live leaderboard. Bet events are processed one at a time, so every
+// event is either replayed or scored live, never both.
+type Backfiller struct {
+	jobRunner
+	competitionsRepo repositories.CompetitionsRepository
+	leaderboardsRepo repositories.LeaderboardsRepository
+	leaderboard      BackfillTarget
+	events           EventProcessor
+}
+
+// NewBackfiller creates and returns a new Backfiller instance
+func NewBackfiller(
+	jobsRepo repositories.JobsRepository,
+	competitionsRepo repositories.CompetitionsRepository,
+	leaderboardsRepo repositories.LeaderboardsRepository,
+	leaderboard BackfillTarget,
+	events EventProcessor,
+) *Backfiller {
+	return &Backfiller{
+		jobRunner:        jobRunner{jobsRepo: jobsRepo, now: time.Now, retryInterval: jobRetryInterval},
+		competitionsRepo: competitionsRepo,
+		leaderboardsRepo: leaderboardsRepo,
+		leaderboard:      leaderboard,
+		events:           events,
+	}
+}
+
+// Schedule creates a backfill job for a competition that has just been created and runs it in the background.
+// The competition is kept out of the live leaderboard until the job completes.
+func (b *Backfiller) Schedule(comp *common.Competition) (*common.Job, error) {
+	job, err := b.create(common.JobTypeBackfill, comp.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	b.leaderboard.StartBackfill(comp.ID)
+	scheduled := *job
+	b.run(job)
+	return &scheduled, nil
+}
+
+// Resume restarts the backfill jobs left unfinished when the service stopped. Their competitions must have been
+// kept out of the live leaderboard with StartBackfill before the competitions were registered.
+// The jobs start over, as the scores of their competitions are only stored once they complete.
+func (b *Backfiller) Resume(jobs []*common.Job) {
+	for _, job := range jobs {
+		fmt.Printf("Resuming backfill job %d of competition %d\n", job.ID, job.CompetitionID)
+		b.run(job)
+	}
+}
+
+// run backfills the competition of a job in the background
+func (b *Backfiller) run(job *common.Job) {
+	b.start(job, b.backfill, func() { b.leaderboard.CancelBackfill(job.CompetitionID) })
+}
+
+// backfill replays the stored bet events of the competition of a job and hands it over to the live leaderboard
+func (b *Backfiller) backfill(job *common.Job) error {
+	comp, err := b.competitionsRepo.GetByID(job.CompetitionID)
+	if err != nil {
+		return fmt.Errorf("error retrieving competition: %w", err)
+	}
+	replayer, err := NewReplayer(comp)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errJobAborted, err)
+	}
+
+	// Events received until the cutoff are replayed while events keep being processed, they are all stored
+	// as events are stored one at a time. The events received later are replayed during the hand over.
+	cutoff, err := b.leaderboardsRepo.LastReceivedAt()
+	if err != nil {
+		return fmt.Errorf("error retrieving the last received bet event: %w", err)
+	}
+	beforeCutoff := repositories.BetEventFilter{ReceivedUntil: cutoff}
+	job.Status = common.JobStatusRunning
+	job.ProcessedEvents = 0
+	job.TotalEvents = 0
+	if !cutoff.IsZero() {
+		if job.TotalEvents, err = b.leaderboardsRepo.CountBetEvents(replayer.Filter(beforeCutoff)); err != nil {
+			return fmt.Errorf("error counting bet events: %w", err)
+		}
+	}
+	b.save(job)
+
+	if !cutoff.IsZero() {
+		if err := replayer.Replay(b.leaderboardsRepo, beforeCutoff, b.progress(job)); err != nil {
+			return fmt.Errorf("error replaying bet events: %w", err)
+		}
+	}
+
+	err = b.events.Exclusive(func() error {
+		if err := replayer.Replay(b.leaderboardsRepo, repositories.BetEventFilter{ReceivedAfter: cutoff}, nil); err != nil {
+			return fmt.Errorf("error replaying bet events: %w", err)
+		}
+		replay := replayer.Result()
+		if err := b.leaderboardsRepo.ReplaceScores(comp.ID, replay.Scores, replay.Activities); err != nil {
+			return fmt.Errorf("error storing backfilled scores: %w", err)
+		}
+		if !b.leaderboard.CompleteBackfill(comp, replay) {
+			return repositories.ErrCompetitionNotFound
+		}
+		job.ProcessedEvents = replay.Events
+		if job.TotalEvents < replay.Events {
+			job.TotalEvents = replay.Events
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.events.PublishCompetition(comp.ID)
+	return nil
+}
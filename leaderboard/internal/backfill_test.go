@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"common"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"leaderboard/repositories"
+)
+
+// mockEventProcessor runs beforeExclusive before the functions run while no event is processed, and records
+// the competitions it publishes
+type mockEventProcessor struct {
+	calls           int
+	beforeExclusive func()
+	published       []uint
+}
+
+func (m *mockEventProcessor) Exclusive(fn func() error) error {
+	m.calls++
+	if m.beforeExclusive != nil {
+		m.beforeExclusive()
+	}
+	return fn()
+}
+
+func (m *mockEventProcessor) PublishCompetition(competitionID uint) {
+	m.published = append(m.published, competitionID)
+}
+
+func newTestBackfill() (*repositories.MockCompetitions, *repositories.MockLeaderboardsRepo, *Leaderboard) {
+	comp := &common.Competition{
+		ID:        1,
+		Name:      "Backfilled",
+		ScoreRule: "event_type=='bet' ? amount : 0",
+		StartTime: "2024-06-01T00:00:00Z",
+		EndTime:   "2024-06-30T23:59:59Z",
+	}
+	competitionsRepo := &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{1: comp}}
+	cutoff := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	leaderboardsRepo := &repositories.MockLeaderboardsRepo{
+		StoredBetEvents: []common.BetEvent{
+			{EventID: 1, EventType: common.EventTypeBet, UserID: 7, Amount: 10, ExchangeRate: 1.0, Timestamp: "2024-06-01T00:00:00Z"},
+			{EventID: 2, EventType: common.EventTypeBet, UserID: 8, Amount: 5, ExchangeRate: 1.0, Timestamp: "2024-06-02T00:00:00Z"},
+			{EventID: 3, EventType: common.EventTypeBet, UserID: 7, Amount: 99, ExchangeRate: 1.0, Timestamp: "2024-05-31T00:00:00Z"}, // Before the competition
+		},
+		ReceivedAt: map[uint]time.Time{1: cutoff.Add(-time.Hour), 2: cutoff, 3: cutoff.Add(-2 * time.Hour)},
+	}
+	return competitionsRepo, leaderboardsRepo, NewLeaderboard(&BetRuleEvaluator{})
+}
+
+func TestBackfiller_Schedule(t *testing.T) {
+	competitionsRepo, leaderboardsRepo, lb := newTestBackfill()
+	jobsRepo := &repositories.MockJobs{}
+	liveEvent := common.BetEvent{EventID: 4, EventType: common.EventTypeBet, UserID: 8, Amount: 20, ExchangeRate: 1.0, Timestamp: "2024-06-11T00:00:00Z"}
+	processor := &mockEventProcessor{beforeExclusive: func() {
+		// An event received after the backfill started is not scored live, it is replayed by the hand over
+		updates, err := lb.Update(liveEvent)
+		if err != nil || len(updates) != 0 {
+			t.Errorf("expected the competition not to be scored live before the hand over, got %v, %v", updates, err)
+		}
+		leaderboardsRepo.StoredBetEvents = append(leaderboardsRepo.StoredBetEvents, liveEvent)
+		leaderboardsRepo.ReceivedAt[liveEvent.EventID] = time.Date(2024, 6, 11, 0, 0, 0, 0, time.UTC)
+	}}
+	backfiller := NewBackfiller(jobsRepo, competitionsRepo, leaderboardsRepo, lb, processor)
+
+	job, err := backfiller.Schedule(competitionsRepo.Competitions[1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if job.ID == 0 || job.Type != common.JobTypeBackfill || job.CompetitionID != 1 || job.Status != common.JobStatusPending {
+		t.Errorf("unexpected scheduled job: %+v", job)
+	}
+	backfiller.Wait()
+
+	stored, _ := jobsRepo.GetByID(job.ID)
+	if stored.Status != common.JobStatusCompleted || stored.ProcessedEvents != 3 || stored.TotalEvents != 3 || stored.Error != "" {
+		t.Errorf("expected the job to complete with the 3 events of the window, got %+v", stored)
+	}
+	scores := leaderboardsRepo.ReplacedScores[1]
+	if len(scores) != 2 || scores[0].UserID != 7 || scores[0].Score != 10 || scores[1].UserID != 8 || scores[1].Score != 25 || scores[1].Events != 2 {
+		t.Fatalf("unexpected backfilled scores: %+v", scores)
+	}
+
+	// The competition is scored live from the backfilled scores, which are pushed to its clients
+	if lb.IsBackfilling(1) {
+		t.Errorf("expected the backfill to be completed")
+	}
+	if !reflect.DeepEqual(processor.published, []uint{1}) {
+		t.Errorf("expected the backfilled competition to be published, got %v", processor.published)
+	}
+	updates, err := lb.Update(common.BetEvent{EventID: 5, EventType: common.EventTypeBet, UserID: 8, Amount: 1, ExchangeRate: 1.0, Timestamp: "2024-06-12T00:00:00Z"})
+	if err != nil || len(updates) != 1 || updates[0].Score != 26 || updates[0].State.Count != 3 {
+		t.Errorf("expected the live event to add to the backfilled score, got %+v, %v", updates, err)
+	}
+}
+
+func TestBackfiller_Schedule_NoEvents(t *testing.T) {
+	competitionsRepo, _, lb := newTestBackfill()
+	jobsRepo := &repositories.MockJobs{}
+	backfiller := NewBackfiller(jobsRepo, competitionsRepo, &repositories.MockLeaderboardsRepo{}, lb, &mockEventProcessor{})
+
+	job, err := backfiller.Schedule(competitionsRepo.Competitions[1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	backfiller.Wait()
+
+	stored, _ := jobsRepo.GetByID(job.ID)
+	if stored.Status != common.JobStatusCompleted || stored.ProcessedEvents != 0 {
+		t.Errorf("expected the job to complete without events, got %+v", stored)
+	}
+	if _, registered := lb.competitions[1]; !registered {
+		t.Errorf("expected the competition to be registered")
+	}
+}
+
+func TestBackfiller_Schedule_Deleted(t *testing.T) {
+	competitionsRepo, leaderboardsRepo, lb := newTestBackfill()
+	jobsRepo := &repositories.MockJobs{}
+	processor := &mockEventProcessor{beforeExclusive: func() {
+		lb.UnregisterCompetition(1)
+	}}
+	backfiller := NewBackfiller(jobsRepo, competitionsRepo, leaderboardsRepo, lb, processor)
+
+	job, err := backfiller.Schedule(competitionsRepo.Competitions[1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	backfiller.Wait()
+
+	stored, _ := jobsRepo.GetByID(job.ID)
+	if stored.Status != common.JobStatusFailed || stored.Error == "" {
+		t.Errorf("expected the job of a deleted competition to fail, got %+v", stored)
+	}
+	if _, registered := lb.competitions[1]; registered || lb.IsBackfilling(1) {
+		t.Errorf("expected the deleted competition not to be registered")
+	}
+	if len(processor.published) != 0 {
+		t.Errorf("expected the deleted competition not to be published, got %v", processor.published)
+	}
+}
+
+func TestBackfiller_Schedule_Retry(t *testing.T) {
+	competitionsRepo, leaderboardsRepo, lb := newTestBackfill()
+	jobsRepo := &repositories.MockJobs{}
+	leaderboardsRepo.ReplaceScoresErr = errors.New("database is locked")
+	processor := &mockEventProcessor{}
+	processor.beforeExclusive = func() {
+		if processor.calls == 2 {
+			leaderboardsRepo.ReplaceScoresErr = nil
+		}
+	}
+	backfiller := NewBackfiller(jobsRepo, competitionsRepo, leaderboardsRepo, lb, processor)
+	backfiller.retryInterval = time.Millisecond
+
+	job, err := backfiller.Schedule(competitionsRepo.Competitions[1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	backfiller.Wait()
+
+	stored, _ := jobsRepo.GetByID(job.ID)
+	if stored.Status != common.JobStatusCompleted || stored.Error != "" || processor.calls != 2 {
+		t.Errorf("expected the job to complete on its second attempt, got %+v after %d attempts", stored, processor.calls)
+	}
+	if len(leaderboardsRepo.ReplacedScores[1]) != 2 {
+		t.Errorf("expected the scores to be stored once, got %+v", leaderboardsRepo.ReplacedScores[1])
+	}
+}
+
+func TestBackfiller_Resume(t *testing.T) {
+	competitionsRepo, leaderboardsRepo, lb := newTestBackfill()
+	jobsRepo := &repositories.MockJobs{}
+	jobID, _ := jobsRepo.Create(&common.Job{Type: common.JobTypeBackfill, CompetitionID: 1, Status: common.JobStatusRunning, ProcessedEvents: 1000})
+	missingID, _ := jobsRepo.Create(&common.Job{Type: common.JobTypeBackfill, CompetitionID: 2, Status: common.JobStatusPending})
+	jobs, _ := jobsRepo.GetUnfinished(common.JobTypeBackfill)
+	for _, job := range jobs {
+		lb.StartBackfill(job.CompetitionID)
+	}
+	lb.RegisterCompetition(competitionsRepo.Competitions[1])
+	if _, registered := lb.competitions[1]; registered {
+		t.Fatalf("expected a competition being backfilled not to be registered")
+	}
+
+	backfiller := NewBackfiller(jobsRepo, competitionsRepo, leaderboardsRepo, lb, &mockEventProcessor{})
+	backfiller.Resume(jobs)
+	backfiller.Wait()
+
+	resumed, _ := jobsRepo.GetByID(jobID)
+	if resumed.Status != common.JobStatusCompleted || resumed.ProcessedEvents != 2 {
+		t.Errorf("expected the job to start over and complete, got %+v", resumed)
+	}
+	missing, _ := jobsRepo.GetByID(missingID)
+	if missing.Status != common.JobStatusFailed || lb.IsBackfilling(2) {
+		t.Errorf("expected the job of a missing competition to fail, got %+v", missing)
+	}
+}
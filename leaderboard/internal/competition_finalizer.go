@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"common"
+	"errors"
+	"fmt"
+	"time"
+
+	"leaderboard/repositories"
+)
+
+// CompetitionFinishedEventType is the event type of the messages emitted when a competition finishes
+const CompetitionFinishedEventType = "competition_finished"
+
+// CompetitionFinishedMessage is emitted over the websocket and to the results queue when a competition finishes
+type CompetitionFinishedMessage struct {
+	Type          string                      `json:"type"`
+	CompetitionID uint                        `json:"competition_id"`
+	Name          string                      `json:"name"`
+	FinishedAt    string                      `json:"finished_at"`
+	Results       []*common.CompetitionResult `json:"results"`
+}
+
+// MessageSender abstracts the websocket the finished competitions are pushed to
+type MessageSender interface {
+	// SendCompetitionMessage sends the message to the clients subscribed to the competition
+	SendCompetitionMessage(competitionID uint, message any) error
+}
+
+// CompetitionFinisher abstracts the in-memory leaderboard whose scores are frozen when a competition finishes.
+// Competitions whose scores are being backfilled are finished once the backfill completes.
+type CompetitionFinisher interface {
+	FinishCompetition(competitionID uint)
+	IsBackfilling(competitionID uint) bool
+}
+
+// CompetitionFinalizer periodically detects the competitions whose end time has passed,
+// freezes their scores, stores their final ranks and rewards and publishes the results.
+// Competitions are finalized while no bet event is being processed, so the stored results are the frozen scores.
+type CompetitionFinalizer struct {
+	competitionsRepo repositories.CompetitionsRepository
+	leaderboardsRepo repositories.LeaderboardsRepository
+	resultsRepo      repositories.ResultsRepository
+	leaderboard      CompetitionFinisher
+	events           EventProcessor
+	websocket        MessageSender
+	resultsQueue     Sender
+}
+
+// NewCompetitionFinalizer creates and returns a new CompetitionFinalizer instance
+func NewCompetitionFinalizer(
+	competitionsRepo repositories.CompetitionsRepository,
+	leaderboardsRepo repositories.LeaderboardsRepository,
+	resultsRepo repositories.ResultsRepository,
+	leaderboard CompetitionFinisher,
+	events EventProcessor,
+	websocket MessageSender,
+	resultsQueue Sender,
+) *CompetitionFinalizer {
+	return &CompetitionFinalizer{
+		competitionsRepo: competitionsRepo,
+		leaderboardsRepo: leaderboardsRepo,
+		resultsRepo:      resultsRepo,
+		leaderboard:      leaderboard,
+		events:           events,
+		websocket:        websocket,
+		resultsQueue:     resultsQueue,
+	}
+}
+
+// Run finalizes the ended competitions every interval until stop is closed
+func (cf *CompetitionFinalizer) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := cf.FinalizeEnded(time.Now().UTC()); err != nil {
+			fmt.Printf("Error finalizing competitions: %v\n", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// FinalizeEnded finalizes every competition whose end time is before now and publishes
+// the results of finished competitions that have not been published yet
+func (cf *CompetitionFinalizer) FinalizeEnded(now time.Time) error {
+	finished, err := cf.resultsRepo.GetFinished()
+	if err != nil {
+		return fmt.Errorf("error retrieving finished competitions: %w", err)
+	}
+	isFinished := make(map[uint]bool, len(finished))
+	for _, f := range finished {
+		isFinished[f.CompetitionID] = true
+	}
+
+	competitions, err := cf.competitionsRepo.GetAll()
+	if err != nil {
+		return fmt.Errorf("error retrieving competitions: %w", err)
+	}
+	for _, comp := range competitions {
+		if isFinished[comp.ID] || !hasEnded(comp, now) || cf.leaderboard.IsBackfilling(comp.ID) {
+			continue
+		}
+		if err := cf.finalize(comp, now); err != nil {
+			fmt.Printf("Error finalizing competition %d: %v\n", comp.ID, err)
+		}
+	}
+
+	return cf.publishPending()
+}
+
+// finalize freezes the scores of a competition and stores its final results
+func (cf *CompetitionFinalizer) finalize(comp *common.Competition, now time.Time) error {
+	rewards, err := ParseRewards(comp.Rewards)
+	if err != nil {
+		return fmt.Errorf("invalid rewards: %w", err)
+	}
+
+	// No event evaluated before the scores are frozen can be stored after they are ranked
+	finishedAt := now.Format(time.RFC3339)
+	var results []*common.CompetitionResult
+	err = cf.events.Exclusive(func() error {
+		cf.leaderboard.FinishCompetition(comp.ID)
+
+		// Users are ranked with the competition's ranking mode, tied users get the reward of their shared rank
+		page, err := cf.leaderboardsRepo.GetPage(comp.ID, 0, -1, nil)
+		if err != nil {
+			return fmt.Errorf("error retrieving leaderboard: %w", err)
+		}
+		results = make([]*common.CompetitionResult, 0, len(page.Users))
+		for _, user := range page.Users {
+			results = append(results, &common.CompetitionResult{
+				CompetitionID: comp.ID,
+				UserID:        user.ID,
+				Rank:          user.Rank,
+				Score:         user.Score,
+				Reward:        rewards.RewardFor(user.Rank),
+			})
+		}
+
+		if err := cf.resultsRepo.SaveResults(comp.ID, finishedAt, results); err != nil {
+			return fmt.Errorf("error storing results: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Competition %d finished with %d ranked users\n", comp.ID, len(results))
+
+	if cf.websocket != nil {
+		message := newCompetitionFinishedMessage(comp, finishedAt, results)
+		if err := cf.websocket.SendCompetitionMessage(comp.ID, message); err != nil {
+			fmt.Printf("Error sending competition %d results to websocket: %v\n", comp.ID, err)
+		}
+	}
+	return nil
+}
+
+// publishPending sends the results of every finished competition that has not been published yet
+// to the results queue. Results are only marked as published once the queue has accepted them,
+// so they are retried on the next run if publishing fails. The results of competitions deleted before they were
+// published are marked as published, as there is no competition left to publish them for.
+func (cf *CompetitionFinalizer) publishPending() error {
+	if cf.resultsQueue == nil {
+		return nil
+	}
+	finished, err := cf.resultsRepo.GetFinished()
+	if err != nil {
+		return fmt.Errorf("error retrieving finished competitions: %w", err)
+	}
+	for _, f := range finished {
+		if f.Published {
+			continue
+		}
+		comp, err := cf.competitionsRepo.GetByID(f.CompetitionID)
+		if errors.Is(err, repositories.ErrCompetitionNotFound) {
+			fmt.Printf("Competition %d was deleted before its results were published, skipping them\n", f.CompetitionID)
+			if err := cf.resultsRepo.MarkPublished(f.CompetitionID); err != nil {
+				return fmt.Errorf("error marking results of competition %d as published: %w", f.CompetitionID, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error retrieving competition %d: %w", f.CompetitionID, err)
+		}
+		results, err := cf.resultsRepo.GetResults(f.CompetitionID)
+		if err != nil {
+			return fmt.Errorf("error retrieving results of competition %d: %w", f.CompetitionID, err)
+		}
+		message := newCompetitionFinishedMessage(comp, f.FinishedAt, results)
+		if err := cf.resultsQueue.Send(message, CompetitionFinishedEventType); err != nil {
+			return fmt.Errorf("error publishing results of competition %d: %w", f.CompetitionID, err)
+		}
+		if err := cf.resultsRepo.MarkPublished(f.CompetitionID); err != nil {
+			return fmt.Errorf("error marking results of competition %d as published: %w", f.CompetitionID, err)
+		}
+	}
+	return nil
+}
+
+// hasEnded reports whether the competition's end time is before now.
+// Competitions without an end time (or with malformed times) never end.
+func hasEnded(comp *common.Competition, now time.Time) bool {
+	status, err := CompetitionStatus(comp, now)
+	return err == nil && status == CompetitionStatusFinished
+}
+
+func newCompetitionFinishedMessage(comp *common.Competition, finishedAt string, results []*common.CompetitionResult) CompetitionFinishedMessage {
+	return CompetitionFinishedMessage{
+		Type:          CompetitionFinishedEventType,
+		CompetitionID: comp.ID,
+		Name:          comp.Name,
+		FinishedAt:    finishedAt,
+		Results:       results,
+	}
+}
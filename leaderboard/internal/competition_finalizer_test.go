@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"common"
+	"errors"
+	"testing"
+	"time"
+
+	"leaderboard/repositories"
+)
+
+type mockSender struct {
+	Sent    []any
+	SendErr error
+}
+
+func (m *mockSender) Send(msg any, eventType string) error {
+	if m.SendErr != nil {
+		return m.SendErr
+	}
+	m.Sent = append(m.Sent, msg)
+	return nil
+}
+
+func (m *mockSender) SendCompetitionMessage(competitionID uint, message any) error {
+	return m.Send(message, "")
+}
+
+type mockFinisher struct {
+	finished    []uint
+	backfilling map[uint]bool
+}
+
+func (m *mockFinisher) FinishCompetition(competitionID uint) {
+	m.finished = append(m.finished, competitionID)
+}
+
+func (m *mockFinisher) IsBackfilling(competitionID uint) bool {
+	return m.backfilling[competitionID]
+}
+
+func newTestFinalizer(queue *mockSender) (*CompetitionFinalizer, *repositories.MockResults, *mockFinisher, *mockSender) {
+	finalizer, resultsRepo, finisher, websocket, _ := newTestFinalizerWithEvents(queue)
+	return finalizer, resultsRepo, finisher, websocket
+}
+
+// newTestFinalizerWithEvents creates a finalizer like newTestFinalizer, along with the processor of its bet events
+func newTestFinalizerWithEvents(queue *mockSender) (*CompetitionFinalizer, *repositories.MockResults, *mockFinisher, *mockSender, *mockEventProcessor) {
+	competitionsRepo := &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{
+		1: {ID: 1, Name: "Ended", EndTime: "2023-07-31T23:59:59Z", Rewards: map[string]int{"1": 100, "2+": 10}},
+		2: {ID: 2, Name: "Running", EndTime: "2099-07-31T23:59:59Z", Rewards: map[string]int{"1": 100}},
+		3: {ID: 3, Name: "Endless"},
+	}}
+	leaderboardsRepo := &repositories.MockLeaderboardsRepo{
+		TopNUsers: []*common.User{{ID: 7, Score: 300}, {ID: 8, Score: 200}, {ID: 9, Score: 100}},
+	}
+	resultsRepo := &repositories.MockResults{}
+	finisher := &mockFinisher{}
+	websocket := &mockSender{}
+	processor := &mockEventProcessor{}
+	finalizer := NewCompetitionFinalizer(competitionsRepo, leaderboardsRepo, resultsRepo, finisher, processor, websocket, queue)
+	return finalizer, resultsRepo, finisher, websocket, processor
+}
+
+func TestCompetitionFinalizer_FinalizeEnded(t *testing.T) {
+	queue := &mockSender{}
+	finalizer, resultsRepo, finisher, websocket := newTestFinalizer(queue)
+	now := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := finalizer.FinalizeEnded(now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(finisher.finished) != 1 || finisher.finished[0] != 1 {
+		t.Errorf("expected only competition 1 to be frozen, got %v", finisher.finished)
+	}
+	if len(resultsRepo.Finished) != 1 || resultsRepo.Finished[1] == nil {
+		t.Fatalf("expected only competition 1 to be finished, got %v", resultsRepo.Finished)
+	}
+	if resultsRepo.Finished[1].FinishedAt != "2023-08-01T00:00:00Z" {
+		t.Errorf("unexpected finished at: %s", resultsRepo.Finished[1].FinishedAt)
+	}
+
+	results := resultsRepo.Results[1]
+	expected := []common.CompetitionResult{
+		{CompetitionID: 1, UserID: 7, Rank: 1, Score: 300, Reward: 100},
+		{CompetitionID: 1, UserID: 8, Rank: 2, Score: 200, Reward: 10},
+		{CompetitionID: 1, UserID: 9, Rank: 3, Score: 100, Reward: 10},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if *result != expected[i] {
+			t.Errorf("result %d: expected %+v, got %+v", i, expected[i], *result)
+		}
+	}
+
+	if len(websocket.Sent) != 1 {
+		t.Errorf("expected 1 websocket message, got %d", len(websocket.Sent))
+	}
+	if len(queue.Sent) != 1 {
+		t.Fatalf("expected 1 queue message, got %d", len(queue.Sent))
+	}
+	message := queue.Sent[0].(CompetitionFinishedMessage)
+	if message.Type != CompetitionFinishedEventType || message.CompetitionID != 1 || message.Name != "Ended" || len(message.Results) != 3 {
+		t.Errorf("unexpected queue message: %+v", message)
+	}
+	if !resultsRepo.Finished[1].Published {
+		t.Errorf("expected results to be marked as published")
+	}
+
+	// A second run does not finalize or publish the competition again
+	if err := finalizer.FinalizeEnded(now.Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(finisher.finished) != 1 || len(queue.Sent) != 1 {
+		t.Errorf("expected competition to be finalized only once")
+	}
+}
+
+func TestCompetitionFinalizer_TiedUsersShareRewards(t *testing.T) {
+	finalizer, resultsRepo, _, _ := newTestFinalizer(&mockSender{})
+	// Users 8 and 9 are tied at rank 2 in a competition with standard ranking
+	finalizer.leaderboardsRepo = &repositories.MockLeaderboardsRepo{
+		GetPageFunc: func(competitionID uint, offset, limit int, after *repositories.LeaderboardCursor) (*repositories.LeaderboardPage, error) {
+			return &repositories.LeaderboardPage{Users: []*common.RankedUser{
+				{Rank: 1, ID: 7, Score: 300},
+				{Rank: 2, ID: 8, Score: 200},
+				{Rank: 2, ID: 9, Score: 200},
+				{Rank: 4, ID: 10, Score: 100},
+			}}, nil
+		},
+	}
+	finalizer.competitionsRepo = &repositories.MockCompetitions{Competitions: map[uint]*common.Competition{
+		1: {ID: 1, Name: "Ended", EndTime: "2023-07-31T23:59:59Z", Rewards: map[string]int{"1": 100, "2": 50, "3+": 10}},
+	}}
+
+	if err := finalizer.FinalizeEnded(time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []common.CompetitionResult{
+		{CompetitionID: 1, UserID: 7, Rank: 1, Score: 300, Reward: 100},
+		{CompetitionID: 1, UserID: 8, Rank: 2, Score: 200, Reward: 50},
+		{CompetitionID: 1, UserID: 9, Rank: 2, Score: 200, Reward: 50},
+		{CompetitionID: 1, UserID: 10, Rank: 4, Score: 100, Reward: 10},
+	}
+	results := resultsRepo.Results[1]
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i, result := range results {
+		if *result != expected[i] {
+			t.Errorf("result %d: expected %+v, got %+v", i, expected[i], *result)
+		}
+	}
+}
+
+func TestCompetitionFinalizer_RetriesFailedPublish(t *testing.T) {
+	queue := &mockSender{SendErr: errors.New("queue down")}
+	finalizer, resultsRepo, _, _ := newTestFinalizer(queue)
+	now := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := finalizer.FinalizeEnded(now); err == nil {
+		t.Errorf("expected error when the results cannot be published")
+	}
+	if resultsRepo.Finished[1] == nil || resultsRepo.Finished[1].Published {
+		t.Fatalf("expected competition to be finished but not published")
+	}
+
+	queue.SendErr = nil
+	if err := finalizer.FinalizeEnded(now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(queue.Sent) != 1 || !resultsRepo.Finished[1].Published {
+		t.Errorf("expected results to be published on retry")
+	}
+}
+
+func TestCompetitionFinalizer_FinalizesWhileNoEventIsProcessed(t *testing.T) {
+	finalizer, resultsRepo, finisher, _, processor := newTestFinalizerWithEvents(&mockSender{})
+	processor.beforeExclusive = func() {
+		// A bet event processed before the competition is frozen is included in its results
+		if len(finisher.finished) != 0 {
+			t.Errorf("expected the competition to be frozen while no event is processed")
+		}
+		leaderboardsRepo := finalizer.leaderboardsRepo.(*repositories.MockLeaderboardsRepo)
+		leaderboardsRepo.TopNUsers = append([]*common.User{{ID: 6, Score: 400}}, leaderboardsRepo.TopNUsers...)
+	}
+	if err := finalizer.FinalizeEnded(time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if processor.calls != 1 || len(resultsRepo.Results[1]) != 4 || resultsRepo.Results[1][0].UserID != 6 {
+		t.Errorf("expected the results to include the score stored before the competition was frozen, got %+v", resultsRepo.Results[1])
+	}
+}
+
+func TestCompetitionFinalizer_SkipsDeletedCompetition(t *testing.T) {
+	queue := &mockSender{SendErr: errors.New("queue down")}
+	finalizer, resultsRepo, _, _ := newTestFinalizer(queue)
+	now := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	finalizer.FinalizeEnded(now)
+
+	// Competition 1 is deleted before its results are published, then competition 4 ends
+	competitions := finalizer.competitionsRepo.(*repositories.MockCompetitions).Competitions
+	delete(competitions, 1)
+	competitions[4] = &common.Competition{ID: 4, Name: "Ended later", EndTime: "2023-07-31T23:59:59Z"}
+	queue.SendErr = nil
+	if err := finalizer.FinalizeEnded(now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(queue.Sent) != 1 || queue.Sent[0].(CompetitionFinishedMessage).CompetitionID != 4 {
+		t.Errorf("expected only the results of competition 4 to be published, got %+v", queue.Sent)
+	}
+	if !resultsRepo.Finished[1].Published || !resultsRepo.Finished[4].Published {
+		t.Errorf("expected the results of the deleted competition not to be retried")
+	}
+}
+
+func TestCompetitionFinalizer_SkipsBackfilling(t *testing.T) {
+	finalizer, resultsRepo, finisher, _ := newTestFinalizer(&mockSender{})
+	finisher.backfilling = map[uint]bool{1: true}
+	now := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := finalizer.FinalizeEnded(now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(finisher.finished) != 0 || len(resultsRepo.Finished) != 0 {
+		t.Errorf("expected the competition being backfilled not to be finished, got %v", finisher.finished)
+	}
+
+	// It is finished once its backfill completes
+	finisher.backfilling = nil
+	if err := finalizer.FinalizeEnded(now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(finisher.finished) != 1 || finisher.finished[0] != 1 {
+		t.Errorf("expected competition 1 to be finished, got %v", finisher.finished)
+	}
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Sender is an interface for sending messages to downstream systems
+type Sender interface {
+	Send(msg any, eventType string) error
+}
+
+// RabbitMQSender implements Sender and sends messages to a RabbitMQ queue
+// Usage: NewRabbitMQSender(url, queueName)
+type RabbitMQSender struct {
+	conn      *amqp091.Connection
+	channel   *amqp091.Channel
+	queueName string
+}
+
+func NewRabbitMQSender(url, queueName string) (*RabbitMQSender, error) {
+	conn, err := amqp091.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_, err = ch.QueueDeclare(
+		queueName,
+		true,  // durable
+		false, // autoDelete
+		false, // exclusive
+		false, // noWait
+		nil,   // args
+	)
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+	return &RabbitMQSender{conn: conn, channel: ch, queueName: queueName}, nil
+}
+
+// Send serialises msg to JSON and publishes it to the queue with the event type as a header
+func (s *RabbitMQSender) Send(msg any, eventType string) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.channel.Publish(
+		"", // exchange
+		s.queueName,
+		false, // mandatory
+		false, // immediate
+		amqp091.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp091.Persistent,
+			Body:         body,
+			Headers:      amqp091.Table{"event_type": eventType},
+		},
+	)
+}
+
+func (s *RabbitMQSender) Close() error {
+	err1 := s.channel.Close()
+	err2 := s.conn.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// ReconnectingRabbitMQSender implements Sender, it connects to the RabbitMQ queue when a message is sent and
+// reconnects after a failed send. Its users keep running while RabbitMQ is unavailable, only the sends fail.
+type ReconnectingRabbitMQSender struct {
+	mutex     sync.Mutex
+	url       string
+	queueName string
+	sender    *RabbitMQSender
+}
+
+func NewReconnectingRabbitMQSender(url, queueName string) *ReconnectingRabbitMQSender {
+	return &ReconnectingRabbitMQSender{url: url, queueName: queueName}
+}
+
+// Send publishes msg to the queue, connecting to RabbitMQ first if there is no open connection
+func (s *ReconnectingRabbitMQSender) Send(msg any, eventType string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sender == nil {
+		sender, err := NewRabbitMQSender(s.url, s.queueName)
+		if err != nil {
+			return fmt.Errorf("error connecting to RabbitMQ: %w", err)
+		}
+		s.sender = sender
+	}
+	if err := s.sender.Send(msg, eventType); err != nil {
+		// The connection may be broken, the next send opens a new one
+		s.sender.Close()
+		s.sender = nil
+		return err
+	}
+	return nil
+}
+
+func (s *ReconnectingRabbitMQSender) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sender == nil {
+		return nil
+	}
+	err := s.sender.Close()
+	s.sender = nil
+	return err
+}
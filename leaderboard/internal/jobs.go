@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"common"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"leaderboard/repositories"
+)
+
+// jobProgressInterval is the number of replayed events between two updates of the progress of a job
+const jobProgressInterval = 1000
+
+// jobRetryInterval is the time a job waits before retrying after an error
+const jobRetryInterval = 10 * time.Second
+
+// errJobAborted is returned by jobs that cannot succeed, so they are not retried
+var errJobAborted = errors.New("job aborted")
+
+// jobRunner runs background jobs over the stored bet events, storing their status and progress
+type jobRunner struct {
+	jobsRepo      repositories.JobsRepository
+	now           func() time.Time
+	retryInterval time.Duration
+	running       sync.WaitGroup
+}
+
+// create stores a new pending job of a competition
+func (r *jobRunner) create(jobType common.JobType, competitionID uint) (*common.Job, error) {
+	now := r.currentTime()
+	job := &common.Job{
+		Type:          jobType,
+		CompetitionID: competitionID,
+		Status:        common.JobStatusPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	id, err := r.jobsRepo.Create(job)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s job: %w", jobType, err)
+	}
+	job.ID = id
+	return job, nil
+}
+
+// start runs a job in the background with work, retrying until it completes or cannot succeed.
+// Jobs cannot succeed once work returns errJobAborted or ErrCompetitionNotFound, fail is then called.
+func (r *jobRunner) start(job *common.Job, work func(job *common.Job) error, fail func()) {
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		for {
+			err := work(job)
+			if err == nil {
+				job.Status = common.JobStatusCompleted
+				job.Error = ""
+				r.save(job)
+				fmt.Printf("Job %d (%s) of competition %d completed with %d events\n", job.ID, job.Type, job.CompetitionID, job.ProcessedEvents)
+				return
+			}
+			if errors.Is(err, errJobAborted) || errors.Is(err, repositories.ErrCompetitionNotFound) {
+				fail()
+				job.Status = common.JobStatusFailed
+				job.Error = err.Error()
+				r.save(job)
+				fmt.Printf("Job %d (%s) of competition %d failed: %v\n", job.ID, job.Type, job.CompetitionID, err)
+				return
+			}
+
+			job.Error = err.Error()
+			r.save(job)
+			fmt.Printf("Error running job %d (%s) of competition %d, retrying in %v: %v\n", job.ID, job.Type, job.CompetitionID, r.retryInterval, err)
+			time.Sleep(r.retryInterval)
+		}
+	}()
+}
+
+// Wait blocks until every job has finished
+func (r *jobRunner) Wait() {
+	r.running.Wait()
+}
+
+// progress returns a Replayer callback storing the number of events a job has replayed every jobProgressInterval
+func (r *jobRunner) progress(job *common.Job) func(events int) {
+	return func(events int) {
+		if events%jobProgressInterval == 0 {
+			job.ProcessedEvents = events
+			r.save(job)
+		}
+	}
+}
+
+// save stores the status and progress of a job. Failures are only logged, the job carries on.
+func (r *jobRunner) save(job *common.Job) {
+	job.UpdatedAt = r.currentTime()
+	if err := r.jobsRepo.Update(job); err != nil {
+		fmt.Printf("Error storing %s job %d: %v\n", job.Type, job.ID, err)
+	}
+}
+
+// currentTime returns the current time, formatted for jobs
+func (r *jobRunner) currentTime() string {
+	if r.now == nil {
+		return time.Now().UTC().Format(time.RFC3339)
+	}
+	return r.now().UTC().Format(time.RFC3339)
+}
@@ -3,118 +3,623 @@ package internal
 import (
 	"common"
 	"fmt"
+	"sync"
+	"time"
+
+	"leaderboard/repositories"
 )
 
-type rulesToCompetitionID map[string]uint        // map[rule]competition
-type usersIDToUser map[uint]*common.User         // map[userID]User
-type scoresPerCompetition map[uint]usersIDToUser // map[competitionID]map[userID]User
+type rulesToCompetitionIDs map[string][]uint                                     // map[rule][]competitionID
+type competitionsByID map[uint]*registeredCompetition                            // map[competitionID]competition
+type usersIDToUser map[uint]*common.User                                         // map[userID]User
+type scoresPerCompetition map[uint]usersIDToUser                                 // map[competitionID]map[userID]User
+type statesPerCompetition map[uint]map[uint]*common.AggregationState             // map[competitionID]map[userID]state
+type activitiesPerCompetition map[uint]map[uint]*common.UserActivity             // map[competitionID]map[userID]activity
+type rankingKeysPerCompetition map[uint]map[uint]*repositories.LeaderboardCursor // map[competitionID]map[userID]key
+
+// registeredCompetition holds the scoring attributes of a competition registered in the leaderboard
+type registeredCompetition struct {
+	rule        string
+	window      timeWindow
+	aggregation common.Aggregation
+	bestN       int
+	skipLosses  bool
+	rankingMode common.RankingMode
+	tieBreaker  common.TieBreaker
+}
 
 type UpdatedData struct {
 	CompetitionID uint
 	UserID        uint
 	Score         float64
+	ReachedAt     string // Time of the event, formatted with ReachedAtLayout, the user reached Score then if it changed
+	State         common.AggregationState
+	Activity      *common.UserActivity // History of the user, set for competitions whose rule uses the rule context
+	ActivityOnly  bool                 // The event did not change the score, only the history of the user
+	RuleDisabled  bool                 // The event disabled the competition, its rule failed too many times in a row
+}
+
+// Evaluation is the outcome of a bet event returned by Evaluate, which Apply stores in the leaderboard
+type Evaluation struct {
+	Updates       []*UpdatedData
+	OutsideWindow []uint        // Competitions whose rule matched the event that were not running at the time of the event
+	ruleOutcomes  []ruleOutcome // Evaluations of the rules, recorded against their competitions when applied
+}
+
+// ruleOutcome is the evaluation of a competition's rule for an event, err is nil if the rule succeeded
+type ruleOutcome struct {
+	competitionID uint
+	rule          string
+	eventID       uint
+	err           error
 }
 
+// ReachedAtLayout formats the times at which scores are reached. The times are in UTC and have a fixed width,
+// so they are ordered the same way as strings, which lets the earliest tie-breaker compare them in SQL.
+const ReachedAtLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
 type LeaderboardInterface interface {
 	// Update processes a bet event and returns updated scores for users in competitions
 	Update(event common.BetEvent) ([]*UpdatedData, error)
+	// Evaluate returns the scores a bet event updates without changing the leaderboard
+	Evaluate(event common.BetEvent) (*Evaluation, error)
+	// Apply stores the evaluation returned by Evaluate in the leaderboard
+	Apply(evaluation *Evaluation)
 	// RegisterCompetition registers a competition with its score rule
 	RegisterCompetition(comp *common.Competition)
+	// UnregisterCompetition removes a competition and its scores
+	UnregisterCompetition(competitionID uint)
+	// GetRuleErrors returns the evaluation errors of the competitions whose rule has failed
+	GetRuleErrors() []*RuleErrorStatus
+	// EnableCompetition clears the rule errors of a competition and scores it again if it was disabled
+	EnableCompetition(competitionID uint)
 }
 
 // RuleEvaluator abstracts rule evaluation for Leaderboard
 type RuleEvaluator interface {
 	AddRule(rule string)
+	RemoveRule(rule string)
 	EvaluateRules(event common.BetEvent) ([]Match, error)
+	EvaluateRule(rule string, event common.BetEvent, context RuleContext) Match
 }
 
+// Leaderboard is safe for concurrent use: competitions can be registered from HTTP handlers
+// while events are being scored
 type Leaderboard struct {
-	ruleEvaluator       RuleEvaluator
-	rulesToCompetition  rulesToCompetitionID
-	competitionsResults scoresPerCompetition
+	mutex                sync.Mutex
+	ruleEvaluator        RuleEvaluator
+	competitions         competitionsByID
+	rulesToCompetitions  rulesToCompetitionIDs
+	competitionsResults  scoresPerCompetition
+	aggregationStates    statesPerCompetition
+	activities           activitiesPerCompetition
+	rankingKeys          rankingKeysPerCompetition // Time each score was reached and number of events that changed it, for the tie-breakers
+	finishedCompetitions map[uint]bool
+	backfilling          map[uint]bool // Competitions not scored live until their backfill hands them over
+	ruleErrors           *ruleErrorTracker
 }
 
 // NewLeaderboard creates and returns a new Leaderboard instance
 func NewLeaderboard(evaluator RuleEvaluator) *Leaderboard {
 	return &Leaderboard{
-		ruleEvaluator:       evaluator,
-		rulesToCompetition:  rulesToCompetitionID{},
-		competitionsResults: scoresPerCompetition{},
+		ruleEvaluator:        evaluator,
+		competitions:         competitionsByID{},
+		rulesToCompetitions:  rulesToCompetitionIDs{},
+		competitionsResults:  scoresPerCompetition{},
+		aggregationStates:    statesPerCompetition{},
+		activities:           activitiesPerCompetition{},
+		rankingKeys:          rankingKeysPerCompetition{},
+		ruleErrors:           newRuleErrorTracker(MaxConsecutiveRuleErrors),
+		finishedCompetitions: map[uint]bool{},
+		backfilling:          map[uint]bool{},
 	}
 }
 
-// RegisterCompetition adds a new competition to the leaderboard
-// If the competition's score rule is empty or already registered, it skips registration.
+// RegisterCompetition adds a new competition to the leaderboard.
+// Competitions sharing the same score rule are all scored from a single evaluation of the rule.
+// If the competition's score rule is empty, the competition is already registered or being backfilled,
+// or its start/end times cannot be parsed, it skips registration.
 func (lb *Leaderboard) RegisterCompetition(comp *common.Competition) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	if comp != nil && lb.backfilling[comp.ID] {
+		fmt.Printf("Competition with ID %d is being backfilled\n", comp.ID)
+		return
+	}
+	lb.registerCompetition(comp)
+}
+
+// registerCompetition registers a competition, see RegisterCompetition
+func (lb *Leaderboard) registerCompetition(comp *common.Competition) {
 	if comp == nil || comp.ScoreRule == "" {
 		fmt.Printf("Skipping registration of competition due to empty ScoreRule\n")
 		return
 	}
-	if _, exists := lb.rulesToCompetition[comp.ScoreRule]; exists {
-		fmt.Printf("Competition with ScoreRule '%s' already registered for competition ID %d\n", comp.ScoreRule, comp.ID)
+	if _, exists := lb.competitions[comp.ID]; exists {
+		fmt.Printf("Competition with ID %d already registered\n", comp.ID)
+		return
+	}
+	window, err := parseTimeWindow(comp.StartTime, comp.EndTime)
+	if err != nil {
+		fmt.Printf("Skipping registration of competition %d: %v\n", comp.ID, err)
+		return
+	}
+
+	// Only new rules are added to the evaluator, so each rule is evaluated once per event
+	if _, exists := lb.rulesToCompetitions[comp.ScoreRule]; !exists {
+		lb.ruleEvaluator.AddRule(comp.ScoreRule)
+	}
+	lb.rulesToCompetitions[comp.ScoreRule] = append(lb.rulesToCompetitions[comp.ScoreRule], comp.ID)
+	aggregation := comp.Aggregation
+	if aggregation == "" {
+		aggregation = common.DefaultAggregation
+	}
+	lb.competitions[comp.ID] = &registeredCompetition{
+		rule:        comp.ScoreRule,
+		window:      window,
+		aggregation: aggregation,
+		bestN:       comp.BestN,
+		skipLosses:  comp.SkipLosses,
+		rankingMode: comp.RankingMode,
+		tieBreaker:  comp.TieBreaker,
+	}
+	if comp.RuleDisabled {
+		lb.ruleErrors.disable(comp.ID, comp.ScoreRule)
+	}
+}
+
+// UnregisterCompetition removes a competition and its in-memory scores from the leaderboard.
+// The competition's score rule is removed from the evaluator once no other competition uses it.
+func (lb *Leaderboard) UnregisterCompetition(competitionID uint) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	delete(lb.backfilling, competitionID)
+	lb.unregisterCompetition(competitionID)
+}
+
+// unregisterCompetition unregisters a competition, see UnregisterCompetition
+func (lb *Leaderboard) unregisterCompetition(competitionID uint) {
+	comp, exists := lb.competitions[competitionID]
+	if !exists {
+		return
+	}
+	delete(lb.competitions, competitionID)
+	delete(lb.competitionsResults, competitionID)
+	delete(lb.aggregationStates, competitionID)
+	delete(lb.activities, competitionID)
+	delete(lb.rankingKeys, competitionID)
+	lb.ruleErrors.remove(competitionID)
+
+	remaining := []uint{}
+	for _, id := range lb.rulesToCompetitions[comp.rule] {
+		if id != competitionID {
+			remaining = append(remaining, id)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(lb.rulesToCompetitions, comp.rule)
+		lb.ruleEvaluator.RemoveRule(comp.rule)
 		return
 	}
-	lb.ruleEvaluator.AddRule(comp.ScoreRule)
-	lb.rulesToCompetition[comp.ScoreRule] = comp.ID
+	lb.rulesToCompetitions[comp.rule] = remaining
 }
 
 // Update updates the leaderboard with the results of a bet event
 func (lb *Leaderboard) Update(event common.BetEvent) ([]*UpdatedData, error) {
-	var updates []*UpdatedData
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
 
-	if event.EventType == common.EventTypeLoss {
-		return nil, nil // Skip loss events, only process bets and wins
+	evaluation, err := lb.evaluate(event)
+	if err != nil {
+		return nil, err
+	}
+	lb.apply(evaluation)
+	return evaluation.Updates, nil
+}
+
+// Evaluate returns the scores and user histories a bet event updates, without changing the leaderboard,
+// so they can be persisted before they are applied with Apply. Events must be evaluated and applied one
+// at a time, an event evaluated before the previous one is applied would be scored from stale scores.
+// The rule errors are only recorded when the evaluation is applied, an update reports the competitions
+// they will disable so that they can be persisted along with the scores.
+func (lb *Leaderboard) Evaluate(event common.BetEvent) (*Evaluation, error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.evaluate(event)
+}
+
+// Apply stores the scores, user histories and rule errors returned by Evaluate in the leaderboard.
+// Updates of competitions that have been finished or unregistered since they were evaluated are dropped.
+func (lb *Leaderboard) Apply(evaluation *Evaluation) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	lb.apply(evaluation)
+}
+
+// evaluate returns the evaluation of a bet event, see Evaluate
+func (lb *Leaderboard) evaluate(event common.BetEvent) (*Evaluation, error) {
+	evaluation := &Evaluation{}
+
+	if event.EventType == common.EventTypeLoss && !lb.scoresLosses() {
+		return evaluation, nil // No competition scores loss events, the rules are not evaluated
 	}
 
 	matches, err := lb.ruleEvaluator.EvaluateRules(event)
 	if err != nil {
 		return nil, fmt.Errorf("error evaluating rules: %w", err)
 	}
+	reachedAt := eventTime(event).UTC().Format(ReachedAtLayout)
 
 	for _, match := range matches {
-		amount, err := toFloat64(match.Result)
+		competitionIDs := lb.competitionsScoring(evaluation, match.Rule, event)
+		if len(competitionIDs) == 0 {
+			continue // No competition using the rule scores the event
+		}
+		if match.UsesContext {
+			lb.evaluateWithContext(evaluation, match, competitionIDs, event, reachedAt)
+			continue
+		}
+		amount, err := matchAmount(match)
 		if err != nil {
-			fmt.Printf("Event %d: Error converting output to float64: %v\n", event.EventID, err)
-			continue // Skip this match if conversion fails
+			fmt.Printf("Event %d: Error evaluating rule '%s': %v\n", event.EventID, match.Rule, err)
+			for _, competitionID := range competitionIDs {
+				if evaluation.recordRuleError(lb.ruleErrors, competitionID, match.Rule, event.EventID, err) {
+					evaluation.Updates = append(evaluation.Updates, &UpdatedData{CompetitionID: competitionID, UserID: event.UserID, ActivityOnly: true, RuleDisabled: true})
+				}
+			}
+			continue // Skip this match, other rules keep scoring
+		}
+		for _, competitionID := range competitionIDs {
+			evaluation.recordRuleSuccess(competitionID, match.Rule)
 		}
 
-		const epsilon = 1e-9
-		if amount < epsilon && amount > -epsilon {
+		if isZero(amount) {
 			continue // Skip rules that evaluate to 0
 		}
 
-		competitionID := lb.rulesToCompetition[match.Rule]
-		// Initialize the map for the competition if it doesn't exist
-		if _, exists := lb.competitionsResults[competitionID]; !exists {
-			lb.competitionsResults[competitionID] = usersIDToUser{}
+		amount = toUSD(amount, event.ExchangeRate)
+
+		// The rule result is applied to every competition that uses the rule
+		for _, competitionID := range competitionIDs {
+			evaluation.Updates = append(evaluation.Updates, lb.scoreUpdate(competitionID, event.UserID, amount, reachedAt))
 		}
+	}
 
-		amount = toUSD(amount, event.ExchangeRate)
+	return evaluation, nil
+}
+
+// evaluateWithContext evaluates a rule that uses the rule context for each competition using it,
+// with the history of the user in that competition, and adds the event to the history it returns.
+// The history is returned even when the score does not change, so it can be stored.
+func (lb *Leaderboard) evaluateWithContext(evaluation *Evaluation, contextMatch Match, competitionIDs []uint, event common.BetEvent, reachedAt string) {
+	rule := contextMatch.Rule
+	for _, competitionID := range competitionIDs {
+		activity := common.UserActivity{}
+		if current, exists := lb.activities[competitionID][event.UserID]; exists {
+			activity = *current
+		}
+		match := lb.ruleEvaluator.EvaluateRule(rule, event, lb.ruleContext(competitionID, event.UserID, activity, contextMatch.UsesRank))
+		recordActivity(&activity, event)
+		historyOnly := &UpdatedData{CompetitionID: competitionID, UserID: event.UserID, Activity: &activity, ActivityOnly: true}
+
+		amount, err := matchAmount(match)
+		if err != nil {
+			fmt.Printf("Event %d: Error evaluating rule '%s' for competition %d: %v\n", event.EventID, rule, competitionID, err)
+			historyOnly.RuleDisabled = evaluation.recordRuleError(lb.ruleErrors, competitionID, rule, event.EventID, err)
+			evaluation.Updates = append(evaluation.Updates, historyOnly)
+			continue
+		}
+		evaluation.recordRuleSuccess(competitionID, rule)
+		if isZero(amount) {
+			evaluation.Updates = append(evaluation.Updates, historyOnly)
+			continue
+		}
+
+		update := lb.scoreUpdate(competitionID, event.UserID, toUSD(amount, event.ExchangeRate), reachedAt)
+		update.Activity = historyOnly.Activity
+		evaluation.Updates = append(evaluation.Updates, update)
+	}
+}
+
+// ruleContext returns the context a rule is evaluated in for the user in the competition.
+// The rank is only computed if the rule uses it, as it goes through every user of the competition. Users are
+// ranked with the ranking mode and tie-breaker of the competition, the same way as in its leaderboard.
+func (lb *Leaderboard) ruleContext(competitionID, userID uint, activity common.UserActivity, withRank bool) RuleContext {
+	comp := lb.competitions[competitionID]
+	context := RuleContext{Activity: activity, CompetitionStart: comp.window.start}
+	user, exists := lb.competitionsResults[competitionID][userID]
+	if !exists {
+		return context
+	}
+	context.Score = user.Score
+	if !withRank {
+		return context
+	}
+
+	keys := make([]repositories.LeaderboardCursor, 0, len(lb.competitionsResults[competitionID]))
+	for _, other := range lb.competitionsResults[competitionID] {
+		keys = append(keys, lb.rankingKey(competitionID, other))
+	}
+	context.Rank = repositories.RankAmong(comp.rankingMode, comp.tieBreaker, lb.rankingKey(competitionID, user), keys)
+	return context
+}
+
+// rankingKey returns the values a user of the competition is ranked by
+func (lb *Leaderboard) rankingKey(competitionID uint, user *common.User) repositories.LeaderboardCursor {
+	key := repositories.LeaderboardCursor{Score: user.Score, UserID: user.ID}
+	if stored, exists := lb.rankingKeys[competitionID][user.ID]; exists {
+		key.ReachedAt, key.Events = stored.ReachedAt, stored.Events
+	}
+	return key
+}
+
+// recordActivity adds an event to the history of a user
+func recordActivity(activity *common.UserActivity, event common.BetEvent) {
+	timestamp := eventTime(event).UTC().Format(time.RFC3339)
+	activity.Events++
+	if event.EventType == common.EventTypeBet {
+		activity.Bets++
+		activity.LastBet = timestamp
+	}
+	if activity.FirstSeen == "" {
+		activity.FirstSeen = timestamp
+	}
+	activity.LastSeen = timestamp
+}
+
+// isZero reports whether a rule result is 0, rules that evaluate to 0 do not score
+func isZero(amount float64) bool {
+	const epsilon = 1e-9
+	return amount < epsilon && amount > -epsilon
+}
+
+// scoresLosses reports whether any registered competition scores loss events
+func (lb *Leaderboard) scoresLosses() bool {
+	for _, comp := range lb.competitions {
+		if !comp.skipLosses {
+			return true
+		}
+	}
+	return false
+}
 
-		// Update the user's score in the competition
-		if user, exists := lb.competitionsResults[competitionID][event.UserID]; exists {
-			user.Score += amount
-			lb.competitionsResults[competitionID][event.UserID] = user
-		} else {
-			lb.competitionsResults[competitionID][event.UserID] = &common.User{
-				ID:    event.UserID,
-				Score: amount,
+// competitionsScoring returns the competitions using the rule that score the event: the ones that score its type,
+// are neither finished nor disabled and were running at the time of the event. The competitions that were not
+// running are added to the evaluation's OutsideWindow. Competitions created before rules could see loss events
+// skip them, so their scores keep their meaning. The rule errors and successes are only recorded against these competitions, so an event a
+// competition does not score cannot disable it.
+func (lb *Leaderboard) competitionsScoring(evaluation *Evaluation, rule string, event common.BetEvent) []uint {
+	var competitionIDs []uint
+	for _, competitionID := range lb.rulesToCompetitions[rule] {
+		if event.EventType == common.EventTypeLoss && lb.competitions[competitionID].skipLosses {
+			continue
+		}
+		if lb.isFinished(competitionID) || lb.ruleErrors.isDisabled(competitionID) {
+			continue // Scores of finished competitions are frozen, and disabled rules are not scored
+		}
+		if !lb.isInCompetitionWindow(competitionID, event) {
+			evaluation.OutsideWindow = append(evaluation.OutsideWindow, competitionID)
+			continue
+		}
+		competitionIDs = append(competitionIDs, competitionID)
+	}
+	return competitionIDs
+}
+
+// recordRuleError adds a failed evaluation of a competition's rule to the evaluation, and reports whether
+// it will disable the competition once it is applied
+func (e *Evaluation) recordRuleError(ruleErrors *ruleErrorTracker, competitionID uint, rule string, eventID uint, err error) bool {
+	e.ruleOutcomes = append(e.ruleOutcomes, ruleOutcome{competitionID: competitionID, rule: rule, eventID: eventID, err: err})
+	return ruleErrors.disablesOnError(competitionID)
+}
+
+// recordRuleSuccess adds a successful evaluation of a competition's rule to the evaluation
+func (e *Evaluation) recordRuleSuccess(competitionID uint, rule string) {
+	e.ruleOutcomes = append(e.ruleOutcomes, ruleOutcome{competitionID: competitionID, rule: rule})
+}
+
+// applyRuleOutcomes records the rule evaluations of an applied event against the competitions still registered
+func (lb *Leaderboard) applyRuleOutcomes(outcomes []ruleOutcome) {
+	for _, outcome := range outcomes {
+		if _, registered := lb.competitions[outcome.competitionID]; !registered {
+			continue
+		}
+		if outcome.err == nil {
+			lb.ruleErrors.recordSuccess(outcome.competitionID)
+			continue
+		}
+		if lb.ruleErrors.recordError(outcome.competitionID, outcome.rule, outcome.eventID, outcome.err) {
+			fmt.Printf("Competition %d disabled after %d consecutive errors evaluating its rule: %v\n", outcome.competitionID, MaxConsecutiveRuleErrors, outcome.err)
+		}
+	}
+}
+
+// GetRuleErrors returns the evaluation errors of the competitions whose rule has failed
+func (lb *Leaderboard) GetRuleErrors() []*RuleErrorStatus {
+	return lb.ruleErrors.list()
+}
+
+// EnableCompetition clears the rule errors of a competition, a disabled competition is scored again
+// from the next event
+func (lb *Leaderboard) EnableCompetition(competitionID uint) {
+	lb.ruleErrors.remove(competitionID)
+}
+
+// scoreUpdate returns the user's score in the competition once amount is folded into it with the
+// competition's aggregation, and the aggregation state of the new score
+func (lb *Leaderboard) scoreUpdate(competitionID, userID uint, amount float64, reachedAt string) *UpdatedData {
+	score := 0.0
+	if user, exists := lb.competitionsResults[competitionID][userID]; exists {
+		score = user.Score
+	}
+	state := common.AggregationState{}
+	if current, exists := lb.aggregationStates[competitionID][userID]; exists {
+		state = copyAggregationState(current)
+	}
+
+	return &UpdatedData{
+		CompetitionID: competitionID,
+		UserID:        userID,
+		Score:         aggregate(lb.competitions[competitionID], &state, score, amount),
+		ReachedAt:     reachedAt,
+		State:         state,
+	}
+}
+
+// apply stores an evaluation in the leaderboard, see Apply
+func (lb *Leaderboard) apply(evaluation *Evaluation) {
+	lb.applyRuleOutcomes(evaluation.ruleOutcomes)
+	for _, update := range evaluation.Updates {
+		competitionID, userID := update.CompetitionID, update.UserID
+		if _, registered := lb.competitions[competitionID]; !registered || lb.isFinished(competitionID) {
+			continue
+		}
+		if update.Activity != nil {
+			if _, exists := lb.activities[competitionID]; !exists {
+				lb.activities[competitionID] = map[uint]*common.UserActivity{}
 			}
+			activity := *update.Activity
+			lb.activities[competitionID][userID] = &activity
+		}
+		if update.ActivityOnly {
+			continue
+		}
+
+		// Initialize the maps for the competition if they don't exist
+		if _, exists := lb.competitionsResults[competitionID]; !exists {
+			lb.competitionsResults[competitionID] = usersIDToUser{}
+		}
+		if _, exists := lb.aggregationStates[competitionID]; !exists {
+			lb.aggregationStates[competitionID] = map[uint]*common.AggregationState{}
+		}
+		if _, exists := lb.rankingKeys[competitionID]; !exists {
+			lb.rankingKeys[competitionID] = map[uint]*repositories.LeaderboardCursor{}
 		}
 
-		updatedUser := lb.competitionsResults[competitionID][event.UserID]
-		updates = append(updates, &UpdatedData{
-			CompetitionID: competitionID,
-			UserID:        updatedUser.ID,
-			Score:         updatedUser.Score,
-		})
+		// The tie-breakers are kept like the stored scores: only a changed score is reached again
+		key := repositories.LeaderboardCursor{UserID: userID}
+		if current, exists := lb.rankingKeys[competitionID][userID]; exists {
+			key = *current
+		}
+		if previous, exists := lb.competitionsResults[competitionID][userID]; !exists || previous.Score != update.Score {
+			key.ReachedAt = update.ReachedAt
+			key.Events++
+		}
+		key.Score = update.Score
+		lb.rankingKeys[competitionID][userID] = &key
+		lb.competitionsResults[competitionID][userID] = &common.User{ID: userID, Score: update.Score}
+		state := copyAggregationState(&update.State)
+		lb.aggregationStates[competitionID][userID] = &state
 	}
+}
+
+// copyAggregationState returns a copy of an aggregation state that does not share its results
+func copyAggregationState(state *common.AggregationState) common.AggregationState {
+	return common.AggregationState{Count: state.Count, Sum: state.Sum, Best: append([]float64(nil), state.Best...)}
+}
+
+// FinishCompetition freezes the scores of a competition, further events are not scored for it
+func (lb *Leaderboard) FinishCompetition(competitionID uint) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	lb.finishedCompetitions[competitionID] = true
+}
+
+// isFinished reports whether the competition has been finished
+func (lb *Leaderboard) isFinished(competitionID uint) bool {
+	return lb.finishedCompetitions[competitionID]
+}
 
-	return updates, nil
+// StartBackfill keeps a competition from being registered and scored live while its scores are backfilled
+// from the stored bet events
+func (lb *Leaderboard) StartBackfill(competitionID uint) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	lb.backfilling[competitionID] = true
+}
+
+// IsBackfilling reports whether the scores of a competition are being backfilled
+func (lb *Leaderboard) IsBackfilling(competitionID uint) bool {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.backfilling[competitionID]
+}
+
+// CompleteBackfill registers a backfilled competition with the scores of its replay, so further events
+// are scored live. It returns false if the competition is no longer being backfilled, as it has been
+// unregistered in the meantime, or if it cannot be registered.
+func (lb *Leaderboard) CompleteBackfill(comp *common.Competition, replay *Replay) bool {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	if !lb.backfilling[comp.ID] {
+		return false
+	}
+	delete(lb.backfilling, comp.ID)
+	lb.registerCompetition(comp)
+	if _, registered := lb.competitions[comp.ID]; !registered {
+		return false
+	}
+	lb.installReplay(replay)
+	return true
+}
+
+// ReplaceCompetition re-registers a competition with its new score rule and replaces its scores with the
+// scores of its replay. It returns false if the competition is not registered, as it has been unregistered
+// in the meantime, or if it cannot be registered with its new rule.
+func (lb *Leaderboard) ReplaceCompetition(comp *common.Competition, replay *Replay) bool {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	if _, registered := lb.competitions[comp.ID]; !registered {
+		return false
+	}
+	lb.unregisterCompetition(comp.ID)
+	lb.registerCompetition(comp)
+	if _, registered := lb.competitions[comp.ID]; !registered {
+		return false
+	}
+	lb.installReplay(replay)
+	return true
+}
+
+// installReplay replaces the scores, aggregation states and user histories of a competition with copies of
+// those of its replay
+func (lb *Leaderboard) installReplay(replay *Replay) {
+	users := usersIDToUser{}
+	states := map[uint]*common.AggregationState{}
+	keys := map[uint]*repositories.LeaderboardCursor{}
+	for _, score := range replay.Scores {
+		users[score.UserID] = &common.User{ID: score.UserID, Score: score.Score}
+		keys[score.UserID] = &repositories.LeaderboardCursor{Score: score.Score, ReachedAt: score.ReachedAt, Events: score.Events, UserID: score.UserID}
+		state := copyAggregationState(&score.State)
+		states[score.UserID] = &state
+	}
+	activities := map[uint]*common.UserActivity{}
+	for userID, activity := range replay.Activities {
+		copied := *activity
+		activities[userID] = &copied
+	}
+	lb.competitionsResults[replay.CompetitionID] = users
+	lb.aggregationStates[replay.CompetitionID] = states
+	lb.activities[replay.CompetitionID] = activities
+	lb.rankingKeys[replay.CompetitionID] = keys
+}
+
+// CancelBackfill stops backfilling a competition without registering it
+func (lb *Leaderboard) CancelBackfill(competitionID uint) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	delete(lb.backfilling, competitionID)
 }
 
 // Load populates the Leaderboard data with the provided leaderboards
 func (lb *Leaderboard) Load(leaderboards map[uint][]common.User) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
 	if lb.competitionsResults == nil {
 		lb.competitionsResults = scoresPerCompetition{}
 	}
@@ -128,13 +633,110 @@ func (lb *Leaderboard) Load(leaderboards map[uint][]common.User) {
 	}
 }
 
-// toFloat64 safely converts an interface{} to float64, handling int, int64, and float64
+// LoadAggregationStates populates the aggregation states the scores loaded with Load are aggregated from
+func (lb *Leaderboard) LoadAggregationStates(states map[uint]map[uint]*common.AggregationState) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	for compID, users := range states {
+		if _, ok := lb.aggregationStates[compID]; !ok {
+			lb.aggregationStates[compID] = map[uint]*common.AggregationState{}
+		}
+		for userID, state := range users {
+			lb.aggregationStates[compID][userID] = state
+		}
+	}
+}
+
+// LoadRankingKeys populates the times the scores loaded with Load were reached and their numbers of events,
+// which the tie-breakers rank users by
+func (lb *Leaderboard) LoadRankingKeys(keys map[uint]map[uint]*repositories.LeaderboardCursor) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	for compID, users := range keys {
+		if _, ok := lb.rankingKeys[compID]; !ok {
+			lb.rankingKeys[compID] = map[uint]*repositories.LeaderboardCursor{}
+		}
+		for userID, key := range users {
+			lb.rankingKeys[compID][userID] = key
+		}
+	}
+}
+
+// LoadActivities populates the histories of the users read by the rules that use the rule context
+func (lb *Leaderboard) LoadActivities(activities map[uint]map[uint]*common.UserActivity) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	for compID, users := range activities {
+		if _, ok := lb.activities[compID]; !ok {
+			lb.activities[compID] = map[uint]*common.UserActivity{}
+		}
+		for userID, activity := range users {
+			lb.activities[compID][userID] = activity
+		}
+	}
+}
+
+// isInCompetitionWindow reports whether the event happened while the competition was running.
+// Events with a missing or malformed timestamp only count for competitions without a window.
+func (lb *Leaderboard) isInCompetitionWindow(competitionID uint, event common.BetEvent) bool {
+	comp, exists := lb.competitions[competitionID]
+	if !exists || !comp.window.isBounded() {
+		return true
+	}
+	timestamp, err := time.Parse(time.RFC3339, event.Timestamp)
+	if err != nil {
+		return false
+	}
+	return comp.window.contains(timestamp)
+}
+
+// eventTime returns the time of the event, or the current time if its timestamp is missing or malformed
+func eventTime(event common.BetEvent) time.Time {
+	timestamp, err := time.Parse(time.RFC3339, event.Timestamp)
+	if err != nil {
+		return time.Now()
+	}
+	return timestamp
+}
+
+// matchAmount returns the numeric result of a rule, or the error that made its evaluation fail
+func matchAmount(match Match) (float64, error) {
+	if match.Err != nil {
+		return 0, match.Err
+	}
+	return toFloat64(match.Result)
+}
+
+// toFloat64 safely converts an interface{} to float64, handling every integer and float type
 func toFloat64(val any) (float64, error) {
 	switch v := val.(type) {
 	case float64:
 		return v, nil
+	case float32:
+		return float64(v), nil
 	case int:
 		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
 	default:
 		return 0, fmt.Errorf("unsupported type: %T", v)
 	}
@@ -8,6 +8,9 @@ type MockLeaderboard struct {
 	UpdateData   []common.BetEvent
 	ReturnData   []*UpdatedData
 	ReturnErr    error
+	RuleErrors   []*RuleErrorStatus
+	Applied      []*UpdatedData
+	Enabled      []uint
 }
 
 // Update simulates the Update method of LeaderboardInterface
@@ -17,7 +20,36 @@ func (m *MockLeaderboard) Update(event common.BetEvent) ([]*UpdatedData, error)
 	return m.ReturnData, m.ReturnErr
 }
 
+// Evaluate simulates the Evaluate method of LeaderboardInterface, it is recorded as an Update
+func (m *MockLeaderboard) Evaluate(event common.BetEvent) (*Evaluation, error) {
+	updates, err := m.Update(event)
+	if err != nil {
+		return nil, err
+	}
+	return &Evaluation{Updates: updates}, nil
+}
+
+// Apply records the applied updates
+func (m *MockLeaderboard) Apply(evaluation *Evaluation) {
+	m.Applied = append(m.Applied, evaluation.Updates...)
+}
+
 // RegisterCompetition simulates the RegisterCompetition method of LeaderboardInterface
 func (m *MockLeaderboard) RegisterCompetition(comp *common.Competition) {
 	// No-op for mock
 }
+
+// UnregisterCompetition simulates the UnregisterCompetition method of LeaderboardInterface
+func (m *MockLeaderboard) UnregisterCompetition(competitionID uint) {
+	// No-op for mock
+}
+
+// GetRuleErrors returns the configured rule errors
+func (m *MockLeaderboard) GetRuleErrors() []*RuleErrorStatus {
+	return m.RuleErrors
+}
+
+// EnableCompetition records the enabled competition
+func (m *MockLeaderboard) EnableCompetition(competitionID uint) {
+	m.Enabled = append(m.Enabled, competitionID)
+}
@@ -2,7 +2,14 @@ package internal
 
 import (
 	"common"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
 	"testing"
+
+	"leaderboard/repositories"
 )
 
 func TestLeaderboard_RegisterCompetition(t *testing.T) {
@@ -20,7 +27,7 @@ func TestLeaderboard_RegisterCompetition(t *testing.T) {
 
 	// Register a valid competition
 	lb.RegisterCompetition(comp)
-	if _, exists := lb.rulesToCompetition[comp.ScoreRule]; !exists {
+	if _, exists := lb.competitions[comp.ID]; !exists {
 		t.Errorf("expected competition to be registered")
 	}
 
@@ -31,21 +38,27 @@ func TestLeaderboard_RegisterCompetition(t *testing.T) {
 	// Register competition with empty ScoreRule
 	compEmpty := &common.Competition{ID: 2, Name: "No Rule"}
 	lb.RegisterCompetition(compEmpty)
-	if _, exists := lb.rulesToCompetition[compEmpty.ScoreRule]; exists {
+	if _, exists := lb.competitions[compEmpty.ID]; exists {
 		t.Errorf("should not register competition with empty ScoreRule")
 	}
 
-	// Register duplicate ScoreRule
+	// Register the same competition twice
 	lb.RegisterCompetition(comp)
-	// Should not add duplicate
-	count := 0
-	for _, id := range lb.rulesToCompetition {
-		if id == comp.ID {
-			count++
-		}
+	if len(lb.rulesToCompetitions[comp.ScoreRule]) != 1 {
+		t.Errorf("expected only one registration for the same competition, got %d", len(lb.rulesToCompetitions[comp.ScoreRule]))
+	}
+
+	// Register another competition with the same ScoreRule
+	compSameRule := &common.Competition{ID: 3, Name: "Same Rule", ScoreRule: comp.ScoreRule}
+	lb.RegisterCompetition(compSameRule)
+	if _, exists := lb.competitions[compSameRule.ID]; !exists {
+		t.Errorf("expected competition sharing a ScoreRule to be registered")
+	}
+	if len(lb.rulesToCompetitions[comp.ScoreRule]) != 2 {
+		t.Errorf("expected 2 competitions for the ScoreRule, got %d", len(lb.rulesToCompetitions[comp.ScoreRule]))
 	}
-	if count != 1 {
-		t.Errorf("expected only one registration for the same ScoreRule, got %d", count)
+	if len(mockEval.AddedRules) != 1 {
+		t.Errorf("expected the shared ScoreRule to be added to the evaluator once, got %d", len(mockEval.AddedRules))
 	}
 }
 
@@ -91,6 +104,9 @@ func TestLeaderboard_Update(t *testing.T) {
 	if updates[0].Score != 100.0 {
 		t.Errorf("expected Score 100.0, got %f", updates[0].Score)
 	}
+	if updates[0].ReachedAt != "2023-10-01T12:00:00.000000000Z" {
+		t.Errorf("expected the score to be reached at the event time, got %q", updates[0].ReachedAt)
+	}
 
 	// Case: evaluationResult == 0
 	mockEvalZero := &MockRuleEvaluator{
@@ -125,9 +141,9 @@ func TestLeaderboard_Update(t *testing.T) {
 		t.Errorf("expected Score 100 for EventTypeBet, got %f", updates[0].Score)
 	}
 
-	// Case: event.EventType == common.EventTypeLoss
+	// Case: event.EventType == common.EventTypeLoss is passed to the rule
 	mockEvalLoss := &MockRuleEvaluator{
-		Matches: []Match{{Rule: comp.ScoreRule, Result: 150.0}},
+		Matches: []Match{{Rule: comp.ScoreRule, Result: -150.0}},
 	}
 	lbLoss := NewLeaderboard(mockEvalLoss)
 	lbLoss.RegisterCompetition(comp)
@@ -137,8 +153,21 @@ func TestLeaderboard_Update(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if len(updates) != 1 || updates[0].Score != -150.0 {
+		t.Errorf("expected the loss to score -150 for EventTypeLoss, got %+v", updates)
+	}
+
+	// Case: event.EventType == common.EventTypeLoss for a competition that skips losses
+	skipping := *comp
+	skipping.SkipLosses = true
+	lbSkip := NewLeaderboard(mockEvalLoss)
+	lbSkip.RegisterCompetition(&skipping)
+	updates, err = lbSkip.Update(eventLoss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	if len(updates) != 0 {
-		t.Errorf("expected 0 updates for EventTypeLoss, got %d", len(updates))
+		t.Errorf("expected 0 updates for EventTypeLoss when losses are skipped, got %d", len(updates))
 	}
 
 	// Case: conversion to float64 fails
@@ -176,3 +205,490 @@ func TestLeaderboard_Load(t *testing.T) {
 		t.Errorf("expected user 3 with score 30.0 in competition 2")
 	}
 }
+
+func TestLeaderboard_FinishCompetition(t *testing.T) {
+	comp := &common.Competition{ID: 1, ScoreRule: "amount"}
+	lb := NewLeaderboard(&MockRuleEvaluator{Matches: []Match{{Rule: comp.ScoreRule, Result: 10.0}}})
+	lb.RegisterCompetition(comp)
+	lb.FinishCompetition(comp.ID)
+
+	updates, err := lb.Update(common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 1, ExchangeRate: 1.0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updates) != 0 {
+		t.Errorf("expected finished competition not to be scored, got %d updates", len(updates))
+	}
+}
+
+func TestLeaderboard_Update_SharedScoreRule(t *testing.T) {
+	rule := "event_type=='bet' ? amount : 0"
+	week1 := &common.Competition{ID: 1, Name: "Weekly Sprint week 1", ScoreRule: rule, StartTime: "2023-07-03T00:00:00Z", EndTime: "2023-07-09T23:59:59Z"}
+	week2 := &common.Competition{ID: 2, Name: "Weekly Sprint week 2", ScoreRule: rule, StartTime: "2023-07-10T00:00:00Z", EndTime: "2023-07-16T23:59:59Z"}
+	monthly := &common.Competition{ID: 3, Name: "July Challenge", ScoreRule: rule, StartTime: "2023-07-01T00:00:00Z", EndTime: "2023-07-31T23:59:59Z"}
+
+	lb := NewLeaderboard(&MockRuleEvaluator{Matches: []Match{{Rule: rule, Result: 10.0}}})
+	lb.RegisterCompetition(week1)
+	lb.RegisterCompetition(week2)
+	lb.RegisterCompetition(monthly)
+
+	event := common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 42, ExchangeRate: 1.0, Timestamp: "2023-07-11T12:00:00Z"}
+	updates, err := lb.Update(event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("expected 2 updates, got %d", len(updates))
+	}
+	scored := map[uint]bool{}
+	for _, update := range updates {
+		scored[update.CompetitionID] = true
+		if update.UserID != 42 || update.Score != 10.0 {
+			t.Errorf("unexpected update: %+v", update)
+		}
+	}
+	if !scored[week2.ID] || !scored[monthly.ID] || scored[week1.ID] {
+		t.Errorf("expected only week 2 and the monthly competition to be scored, got %v", scored)
+	}
+}
+
+func TestLeaderboard_Update_CompetitionWindow(t *testing.T) {
+	rule := "event_type=='bet' ? amount : 0"
+	bounded := &common.Competition{ID: 1, Name: "July Challenge", ScoreRule: rule, StartTime: "2023-07-01T00:00:00Z", EndTime: "2023-07-31T23:59:59Z"}
+	startOnly := &common.Competition{ID: 2, Name: "Open Ended", ScoreRule: rule, StartTime: "2023-07-01T00:00:00Z"}
+	unbounded := &common.Competition{ID: 3, Name: "All Time", ScoreRule: rule}
+
+	tests := []struct {
+		name      string
+		timestamp string
+		scored    []uint // Competitions expected to score the event
+		outside   []uint // Competitions expected to skip the event as outside their window
+	}{
+		{"inside the window", "2023-07-15T12:00:00Z", []uint{1, 2, 3}, nil},
+		{"at the start", "2023-07-01T00:00:00Z", []uint{1, 2, 3}, nil},
+		{"at the end", "2023-07-31T23:59:59Z", []uint{1, 2, 3}, nil},
+		{"before the start", "2023-06-30T23:59:59Z", []uint{3}, []uint{1, 2}},
+		{"after the end", "2023-08-01T00:00:00Z", []uint{2, 3}, []uint{1}},
+		{"missing timestamp", "", []uint{3}, []uint{1, 2}},
+		{"malformed timestamp", "15/07/2023", []uint{3}, []uint{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := NewLeaderboard(&MockRuleEvaluator{Matches: []Match{{Rule: rule, Result: 10.0}}})
+			lb.RegisterCompetition(bounded)
+			lb.RegisterCompetition(startOnly)
+			lb.RegisterCompetition(unbounded)
+
+			evaluation, err := lb.Evaluate(common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 42, ExchangeRate: 1.0, Timestamp: tt.timestamp})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			lb.Apply(evaluation)
+			scored := map[uint]bool{}
+			for _, update := range evaluation.Updates {
+				scored[update.CompetitionID] = true
+			}
+			if len(scored) != len(tt.scored) {
+				t.Fatalf("expected competitions %v to score the event, got %v", tt.scored, scored)
+			}
+			for _, competitionID := range tt.scored {
+				if !scored[competitionID] {
+					t.Errorf("expected competitions %v to score the event, got %v", tt.scored, scored)
+				}
+			}
+			outside := append([]uint(nil), evaluation.OutsideWindow...)
+			sort.Slice(outside, func(i, j int) bool { return outside[i] < outside[j] })
+			if !reflect.DeepEqual(outside, tt.outside) {
+				t.Errorf("expected competitions %v to skip the event outside their window, got %v", tt.outside, outside)
+			}
+		})
+	}
+}
+
+func TestLeaderboard_Update_LossEvents(t *testing.T) {
+	rule := "event_type=='win' ? amount : event_type=='loss' ? -amount : 0"
+	net := &common.Competition{ID: 1, Name: "Net Result", ScoreRule: rule}
+	legacy := &common.Competition{ID: 2, Name: "Legacy", ScoreRule: rule, SkipLosses: true}
+
+	lb := NewLeaderboard(&BetRuleEvaluator{})
+	lb.RegisterCompetition(net)
+	lb.RegisterCompetition(legacy)
+
+	events := []common.BetEvent{
+		{EventID: 1, EventType: common.EventTypeWin, UserID: 7, Amount: 50, ExchangeRate: 1.0},
+		{EventID: 2, EventType: common.EventTypeLoss, UserID: 7, Amount: 20, ExchangeRate: 1.0},
+	}
+	scores := map[uint]float64{}
+	for _, event := range events {
+		updates, err := lb.Update(event)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, update := range updates {
+			scores[update.CompetitionID] = update.Score
+		}
+	}
+	if scores[net.ID] != 30 {
+		t.Errorf("expected the net result competition to score wins minus losses, got %v", scores[net.ID])
+	}
+	if scores[legacy.ID] != 50 {
+		t.Errorf("expected the competition skipping losses to only score wins, got %v", scores[legacy.ID])
+	}
+}
+
+func TestLeaderboard_Update_RuleContext(t *testing.T) {
+	// Double points on the first bet of the day, for users who have placed at least 2 bets before
+	rule := "event_type=='bet' && user_bets >= 2 ? (date(timestamp) != date(user_last_bet) ? amount * 2 : amount) : 0"
+	comp := &common.Competition{ID: 1, Name: "Daily Doubles", ScoreRule: rule}
+	lb := NewLeaderboard(&BetRuleEvaluator{})
+	lb.RegisterCompetition(comp)
+
+	events := []struct {
+		eventType     common.EventType
+		timestamp     string
+		expectedScore float64
+		activityOnly  bool
+	}{
+		{common.EventTypeBet, "2024-06-01T10:00:00Z", 0, true},   // First bet, does not qualify yet
+		{common.EventTypeWin, "2024-06-01T10:01:00Z", 0, true},   // Wins are counted as events, not as bets
+		{common.EventTypeBet, "2024-06-01T11:00:00Z", 0, true},   // Second bet, does not qualify yet
+		{common.EventTypeBet, "2024-06-01T12:00:00Z", 10, false}, // Qualifies, not the first bet of the day
+		{common.EventTypeBet, "2024-06-02T09:00:00Z", 30, false}, // First bet of the day is doubled
+	}
+	for i, e := range events {
+		updates, err := lb.Update(common.BetEvent{EventID: uint(i + 1), EventType: e.eventType, UserID: 7, Amount: 10, ExchangeRate: 1.0, Timestamp: e.timestamp})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(updates) != 1 {
+			t.Fatalf("event %d: expected 1 update, got %d", i+1, len(updates))
+		}
+		update := updates[0]
+		if update.ActivityOnly != e.activityOnly || (!e.activityOnly && update.Score != e.expectedScore) {
+			t.Errorf("event %d: expected score %v (activity only %v), got %+v", i+1, e.expectedScore, e.activityOnly, update)
+		}
+		if update.Activity == nil || update.Activity.Events != i+1 || update.Activity.FirstSeen != events[0].timestamp || update.Activity.LastSeen != e.timestamp {
+			t.Errorf("event %d: unexpected activity %+v", i+1, update.Activity)
+		}
+	}
+	if activity := lb.activities[comp.ID][7]; activity.Bets != 4 || activity.LastBet != "2024-06-02T09:00:00Z" {
+		t.Errorf("unexpected activity after the events: %+v", activity)
+	}
+}
+
+func TestLeaderboard_LoadActivities(t *testing.T) {
+	rule := "user_events >= 3 ? user_rank : 0"
+	lb := NewLeaderboard(&BetRuleEvaluator{})
+	lb.RegisterCompetition(&common.Competition{ID: 1, Name: "Loaded", ScoreRule: rule})
+	lb.Load(map[uint][]common.User{1: {{ID: 7, Score: 5}, {ID: 8, Score: 9}}})
+	lb.LoadActivities(map[uint]map[uint]*common.UserActivity{1: {7: {Events: 3, Bets: 3}}})
+
+	updates, err := lb.Update(common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 7, Amount: 10, ExchangeRate: 1.0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The user is ranked 2nd, so their loaded history makes the rule score their rank
+	if len(updates) != 1 || updates[0].Score != 7 || updates[0].Activity.Events != 4 {
+		t.Errorf("expected the loaded history to be used, got %+v", updates)
+	}
+}
+
+func TestLeaderboard_RuleContextRanking(t *testing.T) {
+	// Users 9 and 10 lead with 20. User 7 reached 10 before user 8, but with more scored events.
+	scores := map[uint][]common.User{1: {{ID: 7, Score: 10}, {ID: 8, Score: 10}, {ID: 9, Score: 20}, {ID: 10, Score: 20}}}
+	keys := map[uint]map[uint]*repositories.LeaderboardCursor{1: {
+		7:  {Score: 10, ReachedAt: "2024-06-02T00:00:00.000000000Z", Events: 2, UserID: 7},
+		8:  {Score: 10, ReachedAt: "2024-06-03T00:00:00.000000000Z", Events: 1, UserID: 8},
+		9:  {Score: 20, ReachedAt: "2024-06-01T00:00:00.000000000Z", Events: 1, UserID: 9},
+		10: {Score: 20, ReachedAt: "2024-06-01T00:00:00.000000000Z", Events: 1, UserID: 10},
+	}}
+	cases := []struct {
+		mode         common.RankingMode
+		tieBreaker   common.TieBreaker
+		expectedRank int
+	}{
+		{"", "", 3}, // Ordinal by user ID by default
+		{common.RankingStandard, common.TieBreakerNone, 3},
+		{common.RankingDense, common.TieBreakerNone, 2},
+		{common.RankingStandard, common.TieBreakerEarliest, 3},
+		{common.RankingStandard, common.TieBreakerFewestEvents, 4},
+		{common.RankingDense, common.TieBreakerFewestEvents, 3},
+		{common.RankingOrdinal, common.TieBreakerFewestEvents, 4},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s/%s", c.mode, c.tieBreaker), func(t *testing.T) {
+			lb := NewLeaderboard(&BetRuleEvaluator{})
+			lb.RegisterCompetition(&common.Competition{ID: 1, Name: "Ranked", ScoreRule: "user_rank", RankingMode: c.mode, TieBreaker: c.tieBreaker})
+			lb.Load(scores)
+			lb.LoadRankingKeys(keys)
+
+			updates, err := lb.Update(common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 7, ExchangeRate: 1.0})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(updates) != 1 || updates[0].Score != 10+float64(c.expectedRank) {
+				t.Errorf("expected user 7 to be ranked %d, got %+v", c.expectedRank, updates)
+			}
+			// The changed score is reached at the time of the event with one more scored event
+			if key := lb.rankingKeys[1][7]; key.Events != 3 || key.ReachedAt == keys[1][7].ReachedAt {
+				t.Errorf("expected the tie-breakers of user 7 to be updated, got %+v", key)
+			}
+		})
+	}
+}
+
+func TestLeaderboard_EvaluateAndApply(t *testing.T) {
+	rule := "amount"
+	lb := NewLeaderboard(&MockRuleEvaluator{Matches: []Match{{Rule: rule, Result: 10.0}}})
+	lb.RegisterCompetition(&common.Competition{ID: 1, Name: "Open", ScoreRule: rule})
+	lb.RegisterCompetition(&common.Competition{ID: 2, Name: "Finishing", ScoreRule: rule})
+	event := common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 7, ExchangeRate: 1.0}
+
+	evaluation, err := lb.Evaluate(event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updates := evaluation.Updates
+	if len(updates) != 2 || len(lb.competitionsResults) != 0 || len(lb.aggregationStates) != 0 {
+		t.Fatalf("expected Evaluate to return 2 updates without changing the leaderboard, got %d updates", len(updates))
+	}
+
+	// Evaluating again gives the same scores, as nothing has been applied
+	again, _ := lb.Evaluate(event)
+	if again.Updates[0].Score != updates[0].Score {
+		t.Errorf("expected the same score before the updates are applied, got %v and %v", again.Updates[0].Score, updates[0].Score)
+	}
+
+	lb.FinishCompetition(2)
+	lb.Apply(evaluation)
+	if user := lb.competitionsResults[1][7]; user == nil || user.Score != 10 || lb.aggregationStates[1][7].Count != 1 {
+		t.Errorf("expected the update to be applied, got %+v", user)
+	}
+	if _, exists := lb.competitionsResults[2]; exists {
+		t.Error("expected the update of a competition finished since it was evaluated to be dropped")
+	}
+}
+
+func TestLeaderboard_UnregisterCompetition(t *testing.T) {
+	rule := "event_type=='bet' ? amount : 0"
+	mockEval := &MockRuleEvaluator{Matches: []Match{{Rule: rule, Result: 10.0}}}
+	lb := NewLeaderboard(mockEval)
+	lb.RegisterCompetition(&common.Competition{ID: 1, ScoreRule: rule})
+	lb.RegisterCompetition(&common.Competition{ID: 2, ScoreRule: rule})
+
+	event := common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 42, ExchangeRate: 1.0}
+	if _, err := lb.Update(event); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lb.UnregisterCompetition(1)
+	if _, exists := lb.competitions[1]; exists {
+		t.Errorf("expected competition 1 to be unregistered")
+	}
+	if _, exists := lb.competitionsResults[1]; exists {
+		t.Errorf("expected scores of competition 1 to be removed")
+	}
+	if len(mockEval.RemovedRules) != 0 {
+		t.Errorf("rule still used by competition 2 should not be removed from the evaluator")
+	}
+
+	updates, _ := lb.Update(event)
+	if len(updates) != 1 || updates[0].CompetitionID != 2 {
+		t.Errorf("expected only competition 2 to be scored, got %+v", updates)
+	}
+
+	lb.UnregisterCompetition(2)
+	if len(mockEval.RemovedRules) != 1 || mockEval.RemovedRules[0] != rule {
+		t.Errorf("expected rule to be removed from the evaluator once unused, got %v", mockEval.RemovedRules)
+	}
+	if _, exists := lb.rulesToCompetitions[rule]; exists {
+		t.Errorf("expected rule to be removed from the leaderboard")
+	}
+
+	// Unregistering an unknown competition is a no-op
+	lb.UnregisterCompetition(42)
+}
+
+func TestLeaderboard_Update_RuleErrorIsolation(t *testing.T) {
+	failingRule := "amount / unknown"
+	validRule := "amount"
+	mockEval := &MockRuleEvaluator{Matches: []Match{
+		{Rule: failingRule, Err: errors.New("runtime error")},
+		{Rule: validRule, Result: 10.0},
+	}}
+	lb := NewLeaderboard(mockEval)
+	lb.RegisterCompetition(&common.Competition{ID: 1, ScoreRule: failingRule})
+	lb.RegisterCompetition(&common.Competition{ID: 2, ScoreRule: validRule})
+
+	event := common.BetEvent{EventID: 7, EventType: common.EventTypeBet, UserID: 42, ExchangeRate: 1.0}
+	updates, err := lb.Update(event)
+	if err != nil {
+		t.Fatalf("a failing rule should not fail the update: %v", err)
+	}
+	if len(updates) != 1 || updates[0].CompetitionID != 2 {
+		t.Errorf("expected competition 2 to keep scoring, got %+v", updates)
+	}
+
+	ruleErrors := lb.GetRuleErrors()
+	if len(ruleErrors) != 1 {
+		t.Fatalf("expected 1 competition with rule errors, got %d", len(ruleErrors))
+	}
+	status := ruleErrors[0]
+	if status.CompetitionID != 1 || status.Rule != failingRule || status.ErrorCount != 1 || status.LastError != "runtime error" || status.LastEventID != 7 || status.Disabled {
+		t.Errorf("unexpected rule error status: %+v", status)
+	}
+}
+
+func TestLeaderboard_Update_DisablesFailingRule(t *testing.T) {
+	rule := "amount"
+	mockEval := &MockRuleEvaluator{Matches: []Match{{Rule: rule, Result: "not a number"}}}
+	lb := NewLeaderboard(mockEval)
+	lb.RegisterCompetition(&common.Competition{ID: 1, ScoreRule: rule})
+	event := common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 42, ExchangeRate: 1.0}
+
+	// A successful evaluation resets the consecutive errors
+	for i := 0; i < MaxConsecutiveRuleErrors-1; i++ {
+		lb.Update(event)
+	}
+	mockEval.Matches = []Match{{Rule: rule, Result: 10.0}}
+	if updates, _ := lb.Update(event); len(updates) != 1 {
+		t.Fatalf("expected competition to score before reaching the error threshold")
+	}
+	if status := lb.GetRuleErrors()[0]; status.ConsecutiveErrors != 0 || status.ErrorCount != MaxConsecutiveRuleErrors-1 {
+		t.Errorf("expected consecutive errors to be reset, got %+v", status)
+	}
+
+	mockEval.Matches = []Match{{Rule: rule, Result: "not a number"}}
+	var disabled []*UpdatedData
+	for i := 0; i < MaxConsecutiveRuleErrors; i++ {
+		updates, _ := lb.Update(event)
+		disabled = append(disabled, updates...)
+	}
+	if status := lb.GetRuleErrors()[0]; !status.Disabled {
+		t.Fatalf("expected competition to be disabled after %d consecutive errors, got %+v", MaxConsecutiveRuleErrors, status)
+	}
+	if len(disabled) != 1 || !disabled[0].RuleDisabled || !disabled[0].ActivityOnly || disabled[0].CompetitionID != 1 {
+		t.Errorf("expected a single update reporting the competition as disabled, got %+v", disabled)
+	}
+
+	// A disabled competition is no longer scored even if its rule evaluates again
+	mockEval.Matches = []Match{{Rule: rule, Result: 10.0}}
+	if updates, _ := lb.Update(event); len(updates) != 0 {
+		t.Errorf("expected disabled competition not to be scored, got %d updates", len(updates))
+	}
+
+	// An enabled competition is scored again
+	lb.EnableCompetition(1)
+	if updates, _ := lb.Update(event); len(updates) != 1 || len(lb.GetRuleErrors()) != 0 {
+		t.Errorf("expected enabled competition to be scored without rule errors, got %+v, %+v", updates, lb.GetRuleErrors())
+	}
+
+	lb.UnregisterCompetition(1)
+	if len(lb.GetRuleErrors()) != 0 {
+		t.Errorf("expected rule errors to be removed with the competition")
+	}
+
+	// A competition registered as disabled, as after a restart, is not scored until it is enabled
+	lb.RegisterCompetition(&common.Competition{ID: 1, ScoreRule: rule, RuleDisabled: true})
+	if updates, _ := lb.Update(event); len(updates) != 0 {
+		t.Errorf("expected competition registered as disabled not to be scored, got %+v", updates)
+	}
+	if status := lb.GetRuleErrors(); len(status) != 1 || !status[0].Disabled {
+		t.Errorf("expected the disabled competition to be listed, got %+v", status)
+	}
+}
+
+func TestLeaderboard_RuleErrorsRecordedWhenApplied(t *testing.T) {
+	rule := "amount"
+	lb := NewLeaderboard(&MockRuleEvaluator{Matches: []Match{{Rule: rule, Result: "not a number"}}})
+	lb.RegisterCompetition(&common.Competition{ID: 1, ScoreRule: rule})
+	for i := 0; i < MaxConsecutiveRuleErrors-1; i++ {
+		lb.Update(common.BetEvent{EventID: uint(i), EventType: common.EventTypeBet, UserID: 42, ExchangeRate: 1.0})
+	}
+
+	// The event reaching the threshold reports the competition it disables, but nothing is recorded until it
+	// is applied, so an event evaluated again after a failed commit is only counted once
+	event := common.BetEvent{EventID: 99, EventType: common.EventTypeBet, UserID: 42, ExchangeRate: 1.0}
+	lb.Evaluate(event)
+	evaluation, _ := lb.Evaluate(event)
+	if len(evaluation.Updates) != 1 || !evaluation.Updates[0].RuleDisabled {
+		t.Fatalf("expected the evaluation to disable the competition, got %+v", evaluation.Updates)
+	}
+	if status := lb.GetRuleErrors()[0]; status.Disabled || status.ErrorCount != MaxConsecutiveRuleErrors-1 {
+		t.Fatalf("expected the rule errors to be left untouched by Evaluate, got %+v", status)
+	}
+
+	lb.Apply(evaluation)
+	if status := lb.GetRuleErrors()[0]; !status.Disabled || status.ErrorCount != MaxConsecutiveRuleErrors || status.LastEventID != 99 {
+		t.Errorf("expected the applied error to disable the competition, got %+v", status)
+	}
+}
+
+func TestLeaderboard_Update_RuleErrorsOnlyCountScoredEvents(t *testing.T) {
+	rule := "amount"
+	mockEval := &MockRuleEvaluator{Matches: []Match{{Rule: rule, Result: "not a number"}}}
+	lb := NewLeaderboard(mockEval)
+	lb.RegisterCompetition(&common.Competition{ID: 1, ScoreRule: rule, StartTime: "2023-08-01T00:00:00Z"})
+	lb.RegisterCompetition(&common.Competition{ID: 2, ScoreRule: rule, EndTime: "2023-06-30T23:59:59Z"})
+	lb.RegisterCompetition(&common.Competition{ID: 3, ScoreRule: rule})
+	lb.FinishCompetition(3)
+
+	// Failing events outside the window of a competition, or after it finished, do not count against it
+	for i := 0; i < MaxConsecutiveRuleErrors; i++ {
+		lb.Update(common.BetEvent{EventID: uint(i), EventType: common.EventTypeBet, UserID: 42, ExchangeRate: 1.0, Timestamp: "2023-07-15T12:00:00Z"})
+	}
+	if ruleErrors := lb.GetRuleErrors(); len(ruleErrors) != 0 {
+		t.Errorf("expected no rule errors for competitions that do not score the events, got %+v", ruleErrors)
+	}
+
+	mockEval.Matches = []Match{{Rule: rule, Result: 10.0}}
+	updates, _ := lb.Update(common.BetEvent{EventID: 99, EventType: common.EventTypeBet, UserID: 42, ExchangeRate: 1.0, Timestamp: "2023-08-02T00:00:00Z"})
+	if len(updates) != 1 || updates[0].CompetitionID != 1 {
+		t.Errorf("expected the upcoming competition to score once it has started, got %+v", updates)
+	}
+}
+
+// TestLeaderboard_ConcurrentUpdateAndRegister is meant to be run with the race detector (go test -race)
+func TestLeaderboard_ConcurrentUpdateAndRegister(t *testing.T) {
+	lb := NewLeaderboard(&BetRuleEvaluator{})
+	lb.RegisterCompetition(&common.Competition{ID: 1, ScoreRule: "amount"})
+
+	const workers = 8
+	const iterations = 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				event := common.BetEvent{EventID: uint(w*iterations + i), EventType: common.EventTypeBet, UserID: uint(w), Amount: 1, ExchangeRate: 1.0}
+				if _, err := lb.Update(event); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := uint(100 + w*iterations + i)
+				lb.RegisterCompetition(&common.Competition{ID: id, ScoreRule: fmt.Sprintf("amount * %d", i%5+1)})
+				lb.GetRuleErrors()
+				if i%2 == 0 {
+					lb.UnregisterCompetition(id)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Competition 1 is registered before any event, so every event is scored for it
+	for w := 0; w < workers; w++ {
+		if score := lb.competitionsResults[1][uint(w)].Score; score != iterations {
+			t.Errorf("expected user %d to score %d, got %v", w, iterations, score)
+		}
+	}
+	if registered := len(lb.competitions); registered != 1+workers*iterations/2 {
+		t.Errorf("expected %d registered competitions, got %d", 1+workers*iterations/2, registered)
+	}
+}
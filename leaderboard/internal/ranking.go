@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"common"
+	"fmt"
+)
+
+// SetRankingDefaults sets the default ranking mode and tie-breaker of a competition that does not set them
+func SetRankingDefaults(comp *common.Competition) {
+	if comp.RankingMode == "" {
+		comp.RankingMode = common.DefaultRankingMode
+	}
+	if comp.TieBreaker == "" {
+		comp.TieBreaker = common.DefaultTieBreaker
+	}
+}
+
+// ValidateRanking checks that the ranking mode and tie-breaker of a competition are supported
+func ValidateRanking(mode common.RankingMode, tieBreaker common.TieBreaker) error {
+	switch mode {
+	case common.RankingStandard, common.RankingDense, common.RankingOrdinal:
+	default:
+		return fmt.Errorf("unknown ranking mode %q, must be one of standard, dense or ordinal", mode)
+	}
+	switch tieBreaker {
+	case common.TieBreakerNone, common.TieBreakerEarliest, common.TieBreakerFewestEvents, common.TieBreakerUserID:
+	default:
+		return fmt.Errorf("unknown tie-breaker %q, must be one of none, earliest, fewest_events or user_id", tieBreaker)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package internal
+
+import (
+	"common"
+	"errors"
+	"fmt"
+	"time"
+
+	"leaderboard/repositories"
+)
+
+var (
+	// ErrCompetitionBackfilling is returned when a competition is recalculated before its backfill has completed
+	ErrCompetitionBackfilling = errors.New("competition is being backfilled")
+	// ErrCompetitionEnded is returned when a competition is recalculated after it has ended, its scores are frozen
+	ErrCompetitionEnded = errors.New("competition has ended")
+	// ErrRecalculationNotReady is returned when a recalculation is applied before its job has completed
+	ErrRecalculationNotReady = errors.New("recalculation has not completed")
+)
+
+// Recalculations rebuilds the scores of competitions and swaps them in once they have been reviewed
+type Recalculations interface {
+	// Schedule starts recalculating the scores of a competition with a score rule in the background and
+	// returns the job reporting its progress. An empty rule recalculates with the competition's rule.
+	Schedule(comp *common.Competition, scoreRule string) (*common.Job, error)
+	// Preview compares the live ranking of a competition with the ranking of its recalculation
+	Preview(competitionID uint) (*RecalculationPreview, error)
+	// Apply replaces the live scores and rule of a competition with those of its recalculation
+	Apply(competitionID uint) error
+	// Discard drops the recalculation of a competition, leaving its live scores untouched
+	Discard(competitionID uint) error
+}
+
+// RecalculationTarget abstracts the live leaderboard recalculated competitions are swapped into
+type RecalculationTarget interface {
+	IsBackfilling(competitionID uint) bool
+	ReplaceCompetition(comp *common.Competition, replay *Replay) bool
+}
+
+// RecalculationPreview is a recalculation with the users whose rank or score it changes.
+// The changes are only compared once the job has completed.
+type RecalculationPreview struct {
+	Recalculation     *repositories.Recalculation
+	Job               *common.Job
+	LiveUsers         int // Number of users ranked by the live scores
+	RecalculatedUsers int // Number of users ranked by the recalculated scores
+	Changes           []*common.RankingChange
+}
+
+// Recalculator rebuilds the scores of existing competitions from the stored bet events, for instance after
+// their rule has been fixed.
+//
+// The scores are rebuilt by a background job into shadow tables, while the live scores keep being updated.
+// The shadow scores include the events received until the job started. Once they are applied, live processing
+// of events is paused to replay the events received since on top of them, swap them with the live scores
+// and re-register the competition with the recalculated rule.
+type Recalculator struct {
+	jobRunner
+	competitionsRepo   repositories.CompetitionsRepository
+	leaderboardsRepo   repositories.LeaderboardsRepository
+	recalculationsRepo repositories.RecalculationsRepository
+	leaderboard        RecalculationTarget
+	events             EventProcessor
+}
+
+// NewRecalculator creates and returns a new Recalculator instance
+func NewRecalculator(
+	jobsRepo repositories.JobsRepository,
+	competitionsRepo repositories.CompetitionsRepository,
+	leaderboardsRepo repositories.LeaderboardsRepository,
+	recalculationsRepo repositories.RecalculationsRepository,
+	leaderboard RecalculationTarget,
+	events EventProcessor,
+) *Recalculator {
+	return &Recalculator{
+		jobRunner:          jobRunner{jobsRepo: jobsRepo, now: time.Now, retryInterval: jobRetryInterval},
+		competitionsRepo:   competitionsRepo,
+		leaderboardsRepo:   leaderboardsRepo,
+		recalculationsRepo: recalculationsRepo,
+		leaderboard:        leaderboard,
+		events:             events,
+	}
+}
+
+// Schedule creates a recalculation of a competition along with the job rebuilding its scores, and runs the job
+// in the background. A competition has at most one recalculation, until it is applied or discarded.
+func (r *Recalculator) Schedule(comp *common.Competition, scoreRule string) (*common.Job, error) {
+	if scoreRule == "" {
+		scoreRule = comp.ScoreRule
+	}
+	if r.leaderboard.IsBackfilling(comp.ID) {
+		return nil, ErrCompetitionBackfilling
+	}
+	if hasEnded(comp, r.now().UTC()) {
+		return nil, ErrCompetitionEnded
+	}
+	if _, err := r.recalculationsRepo.Get(comp.ID); err == nil {
+		return nil, repositories.ErrRecalculationExists
+	} else if !errors.Is(err, repositories.ErrRecalculationNotFound) {
+		return nil, fmt.Errorf("error retrieving recalculation: %w", err)
+	}
+
+	job, err := r.create(common.JobTypeRecalculate, comp.ID)
+	if err != nil {
+		return nil, err
+	}
+	recalculation := &repositories.Recalculation{CompetitionID: comp.ID, JobID: job.ID, ScoreRule: scoreRule}
+	if err := r.recalculationsRepo.Create(recalculation); err != nil {
+		job.Status = common.JobStatusFailed
+		job.Error = err.Error()
+		r.save(job)
+		if errors.Is(err, repositories.ErrRecalculationExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error creating recalculation: %w", err)
+	}
+
+	scheduled := *job
+	r.run(job)
+	return &scheduled, nil
+}
+
+// Resume restarts the recalculation jobs left unfinished when the service stopped. The jobs start over.
+func (r *Recalculator) Resume(jobs []*common.Job) {
+	for _, job := range jobs {
+		fmt.Printf("Resuming recalculation job %d of competition %d\n", job.ID, job.CompetitionID)
+		r.run(job)
+	}
+}
+
+// run recalculates the competition of a job in the background. The recalculation of a job that fails is
+// discarded, so the competition can be recalculated again.
+func (r *Recalculator) run(job *common.Job) {
+	r.start(job, r.recalculate, func() {
+		recalculation, err := r.recalculationsRepo.Get(job.CompetitionID)
+		if err != nil || recalculation.JobID != job.ID {
+			return
+		}
+		if err := r.recalculationsRepo.Discard(job.CompetitionID); err != nil {
+			fmt.Printf("Error discarding recalculation of competition %d: %v\n", job.CompetitionID, err)
+		}
+	})
+}
+
+// recalculate replays the stored bet events of the competition of a job with the rule of its recalculation,
+// and stores the scores in the shadow tables
+func (r *Recalculator) recalculate(job *common.Job) error {
+	recalculation, err := r.recalculationsRepo.Get(job.CompetitionID)
+	if errors.Is(err, repositories.ErrRecalculationNotFound) || (err == nil && recalculation.JobID != job.ID) {
+		return fmt.Errorf("%w: the recalculation has been discarded", errJobAborted)
+	}
+	if err != nil {
+		return fmt.Errorf("error retrieving recalculation: %w", err)
+	}
+	comp, err := r.competitionsRepo.GetByID(job.CompetitionID)
+	if err != nil {
+		return fmt.Errorf("error retrieving competition: %w", err)
+	}
+	recalculated := *comp
+	recalculated.ScoreRule = recalculation.ScoreRule
+	replayer, err := NewReplayer(&recalculated)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errJobAborted, err)
+	}
+
+	// Events received after the cutoff are replayed when the recalculation is applied
+	cutoff, err := r.leaderboardsRepo.LastReceivedAt()
+	if err != nil {
+		return fmt.Errorf("error retrieving the last received bet event: %w", err)
+	}
+	beforeCutoff := repositories.BetEventFilter{ReceivedUntil: cutoff}
+	job.Status = common.JobStatusRunning
+	job.ProcessedEvents = 0
+	job.TotalEvents = 0
+	if !cutoff.IsZero() {
+		if job.TotalEvents, err = r.leaderboardsRepo.CountBetEvents(replayer.Filter(beforeCutoff)); err != nil {
+			return fmt.Errorf("error counting bet events: %w", err)
+		}
+	}
+	r.save(job)
+
+	if !cutoff.IsZero() {
+		if err := replayer.Replay(r.leaderboardsRepo, beforeCutoff, r.progress(job)); err != nil {
+			return fmt.Errorf("error replaying bet events: %w", err)
+		}
+	}
+	replay := replayer.Result()
+	recalculation.ReceivedUntil = cutoff
+	err = r.recalculationsRepo.SaveShadowScores(recalculation, replay.Scores, replay.Activities)
+	if errors.Is(err, repositories.ErrRecalculationNotFound) {
+		return fmt.Errorf("%w: the recalculation has been discarded", errJobAborted)
+	}
+	if err != nil {
+		return fmt.Errorf("error storing recalculated scores: %w", err)
+	}
+	job.ProcessedEvents = replay.Events
+	if job.TotalEvents < replay.Events {
+		job.TotalEvents = replay.Events
+	}
+	return nil
+}
+
+// Preview returns the recalculation of a competition and its job. Once the job has completed, it also compares
+// the live ranking with the recalculated ranking. The recalculated ranking does not include the events received
+// since the job started, they are only replayed when the recalculation is applied.
+func (r *Recalculator) Preview(competitionID uint) (*RecalculationPreview, error) {
+	recalculation, err := r.recalculationsRepo.Get(competitionID)
+	if err != nil {
+		return nil, err
+	}
+	job, err := r.jobsRepo.GetByID(recalculation.JobID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving recalculation job: %w", err)
+	}
+	preview := &RecalculationPreview{Recalculation: recalculation, Job: job}
+	if job.Status != common.JobStatusCompleted {
+		return preview, nil
+	}
+
+	live, recalculated, err := r.recalculationsRepo.GetRankings(competitionID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving rankings: %w", err)
+	}
+	preview.LiveUsers = len(live)
+	preview.RecalculatedUsers = len(recalculated)
+	preview.Changes = DiffRankings(live, recalculated)
+	return preview, nil
+}
+
+// Apply swaps the recalculated scores of a competition in. While the live processing of events is paused,
+// the events received since the job started are replayed on top of the shadow scores, the result replaces the
+// live scores and rule of the competition in a single transaction, and the competition is re-registered in the
+// live leaderboard with its new rule and scores.
+func (r *Recalculator) Apply(competitionID uint) error {
+	err := r.events.Exclusive(func() error {
+		recalculation, err := r.recalculationsRepo.Get(competitionID)
+		if err != nil {
+			return err
+		}
+		job, err := r.jobsRepo.GetByID(recalculation.JobID)
+		if err != nil {
+			return fmt.Errorf("error retrieving recalculation job: %w", err)
+		}
+		if job.Status != common.JobStatusCompleted {
+			return ErrRecalculationNotReady
+		}
+		comp, err := r.competitionsRepo.GetByID(competitionID)
+		if err != nil {
+			return err
+		}
+		recalculated := *comp
+		recalculated.ScoreRule = recalculation.ScoreRule
+		recalculated.RuleDisabled = false // The new rule is scored afresh
+		replayer, err := NewReplayer(&recalculated)
+		if err != nil {
+			return err
+		}
+
+		scores, activities, err := r.recalculationsRepo.GetShadowScores(competitionID)
+		if err != nil {
+			return fmt.Errorf("error retrieving recalculated scores: %w", err)
+		}
+		replayer.Resume(&Replay{CompetitionID: competitionID, Scores: scores, Activities: activities})
+		if err := replayer.Replay(r.leaderboardsRepo, repositories.BetEventFilter{ReceivedAfter: recalculation.ReceivedUntil}, nil); err != nil {
+			return fmt.Errorf("error replaying bet events: %w", err)
+		}
+		replay := replayer.Result()
+		if err := r.recalculationsRepo.Apply(competitionID, replay.Scores, replay.Activities); err != nil {
+			return err
+		}
+		if !r.leaderboard.ReplaceCompetition(&recalculated, replay) {
+			fmt.Printf("Competition %d is not scored live, its recalculated scores are only stored\n", competitionID)
+		}
+		fmt.Printf("Recalculation of competition %d applied with %d users\n", competitionID, len(replay.Scores))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.events.PublishCompetition(competitionID)
+	return nil
+}
+
+// Discard drops the recalculation of a competition and its shadow scores. A job still running for it fails.
+func (r *Recalculator) Discard(competitionID uint) error {
+	return r.recalculationsRepo.Discard(competitionID)
+}
+
+// DiffRankings returns the users whose rank or score differs between two rankings of a competition, ordered
+// by their recalculated rank, followed by the users who are no longer ranked, ordered by their live rank
+func DiffRankings(live, recalculated []*common.RankedUser) []*common.RankingChange {
+	before := make(map[uint]*common.RankedUser, len(live))
+	for _, user := range live {
+		before[user.ID] = user
+	}
+	changes := []*common.RankingChange{}
+	for _, user := range recalculated {
+		old, ranked := before[user.ID]
+		delete(before, user.ID)
+		if ranked && old.Rank == user.Rank && old.Score == user.Score {
+			continue
+		}
+		change := &common.RankingChange{UserID: user.ID, NewRank: user.Rank, NewScore: user.Score}
+		if ranked {
+			change.OldRank = old.Rank
+			change.OldScore = old.Score
+		}
+		changes = append(changes, change)
+	}
+	for _, user := range live {
+		if _, removed := before[user.ID]; removed {
+			changes = append(changes, &common.RankingChange{UserID: user.ID, OldRank: user.Rank, OldScore: user.Score})
+		}
+	}
+	return changes
+}
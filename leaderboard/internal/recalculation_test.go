@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"common"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"leaderboard/repositories"
+)
+
+func newTestRecalculator(processor EventProcessor) (*Recalculator, *repositories.MockCompetitions, *repositories.MockLeaderboardsRepo, *repositories.MockRecalculations, *Leaderboard) {
+	competitionsRepo, leaderboardsRepo, lb := newTestBackfill()
+	lb.RegisterCompetition(competitionsRepo.Competitions[1])
+	recalculationsRepo := &repositories.MockRecalculations{}
+	recalculator := NewRecalculator(&repositories.MockJobs{}, competitionsRepo, leaderboardsRepo, recalculationsRepo, lb, processor)
+	recalculator.now = func() time.Time { return time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC) }
+	return recalculator, competitionsRepo, leaderboardsRepo, recalculationsRepo, lb
+}
+
+func TestRecalculator_ScheduleAndApply(t *testing.T) {
+	liveEvent := common.BetEvent{EventID: 4, EventType: common.EventTypeBet, UserID: 8, Amount: 20, ExchangeRate: 1.0, Timestamp: "2024-06-11T00:00:00Z"}
+	processor := &mockEventProcessor{}
+	recalculator, competitionsRepo, leaderboardsRepo, recalculationsRepo, lb := newTestRecalculator(processor)
+	processor.beforeExclusive = func() {
+		// An event received after the job started is scored live with the old rule, then replayed when applying
+		if _, err := lb.Update(liveEvent); err != nil {
+			t.Errorf("unexpected error scoring a live event: %v", err)
+		}
+		leaderboardsRepo.StoredBetEvents = append(leaderboardsRepo.StoredBetEvents, liveEvent)
+		leaderboardsRepo.ReceivedAt[liveEvent.EventID] = time.Date(2024, 6, 11, 0, 0, 0, 0, time.UTC)
+	}
+
+	job, err := recalculator.Schedule(competitionsRepo.Competitions[1], "event_type=='bet' ? amount * 2 : 0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if job.Type != common.JobTypeRecalculate || job.CompetitionID != 1 || job.Status != common.JobStatusPending {
+		t.Errorf("unexpected scheduled job: %+v", job)
+	}
+	recalculator.Wait()
+
+	preview, err := recalculator.Preview(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if preview.Job.Status != common.JobStatusCompleted || preview.Job.ProcessedEvents != 2 {
+		t.Errorf("expected the job to complete with the 2 events of the window, got %+v", preview.Job)
+	}
+	shadow := recalculationsRepo.ShadowScores[1]
+	if len(shadow) != 2 || shadow[0].Score != 20 || shadow[1].Score != 10 {
+		t.Errorf("expected the scores to be rebuilt with the new rule, got %+v", shadow)
+	}
+	if !preview.Recalculation.ReceivedUntil.Equal(time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the shadow scores to include the events received until the job started, got %v", preview.Recalculation.ReceivedUntil)
+	}
+
+	if err := recalculator.Apply(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	applied := recalculationsRepo.AppliedScores[1]
+	if len(applied) != 2 || applied[0].Score != 20 || applied[1].UserID != 8 || applied[1].Score != 50 || applied[1].Events != 2 {
+		t.Fatalf("expected the live event to be replayed on top of the shadow scores, got %+v", applied)
+	}
+	if _, err := recalculationsRepo.Get(1); !errors.Is(err, repositories.ErrRecalculationNotFound) {
+		t.Errorf("expected the recalculation to be removed once applied, got %v", err)
+	}
+	if !reflect.DeepEqual(processor.published, []uint{1}) {
+		t.Errorf("expected the swapped scores to be published, got %v", processor.published)
+	}
+
+	// The competition is scored live with the new rule, from the recalculated scores
+	updates, err := lb.Update(common.BetEvent{EventID: 5, EventType: common.EventTypeBet, UserID: 8, Amount: 1, ExchangeRate: 1.0, Timestamp: "2024-06-12T00:00:00Z"})
+	if err != nil || len(updates) != 1 || updates[0].Score != 52 {
+		t.Errorf("expected the live event to add to the recalculated score with the new rule, got %+v, %v", updates, err)
+	}
+}
+
+func TestRecalculator_Schedule_Errors(t *testing.T) {
+	recalculator, competitionsRepo, _, recalculationsRepo, lb := newTestRecalculator(&mockEventProcessor{})
+	comp := competitionsRepo.Competitions[1]
+
+	recalculationsRepo.Recalculations = map[uint]*repositories.Recalculation{1: {CompetitionID: 1, JobID: 9}}
+	if _, err := recalculator.Schedule(comp, ""); !errors.Is(err, repositories.ErrRecalculationExists) {
+		t.Errorf("expected ErrRecalculationExists, got %v", err)
+	}
+	recalculationsRepo.Recalculations = nil
+
+	lb.StartBackfill(1)
+	if _, err := recalculator.Schedule(comp, ""); !errors.Is(err, ErrCompetitionBackfilling) {
+		t.Errorf("expected ErrCompetitionBackfilling, got %v", err)
+	}
+	lb.CancelBackfill(1)
+
+	recalculator.now = func() time.Time { return time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC) }
+	if _, err := recalculator.Schedule(comp, ""); !errors.Is(err, ErrCompetitionEnded) {
+		t.Errorf("expected ErrCompetitionEnded, got %v", err)
+	}
+}
+
+func TestRecalculator_Schedule_Deleted(t *testing.T) {
+	recalculator, competitionsRepo, _, recalculationsRepo, _ := newTestRecalculator(&mockEventProcessor{})
+	comp := competitionsRepo.Competitions[1]
+	delete(competitionsRepo.Competitions, 1)
+
+	job, err := recalculator.Schedule(comp, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recalculator.Wait()
+
+	stored, _ := recalculator.jobsRepo.GetByID(job.ID)
+	if stored.Status != common.JobStatusFailed || stored.Error == "" {
+		t.Errorf("expected the job of a deleted competition to fail, got %+v", stored)
+	}
+	if _, err := recalculationsRepo.Get(1); !errors.Is(err, repositories.ErrRecalculationNotFound) {
+		t.Errorf("expected the recalculation of a failed job to be discarded, got %v", err)
+	}
+}
+
+func TestRecalculator_Apply_NotReady(t *testing.T) {
+	processor := &mockEventProcessor{}
+	recalculator, _, _, recalculationsRepo, _ := newTestRecalculator(processor)
+	jobID, _ := recalculator.jobsRepo.Create(&common.Job{Type: common.JobTypeRecalculate, CompetitionID: 1, Status: common.JobStatusRunning})
+	recalculationsRepo.Recalculations = map[uint]*repositories.Recalculation{1: {CompetitionID: 1, JobID: jobID, ScoreRule: "amount"}}
+
+	if err := recalculator.Apply(1); !errors.Is(err, ErrRecalculationNotReady) {
+		t.Errorf("expected ErrRecalculationNotReady, got %v", err)
+	}
+	if err := recalculator.Apply(2); !errors.Is(err, repositories.ErrRecalculationNotFound) {
+		t.Errorf("expected ErrRecalculationNotFound, got %v", err)
+	}
+	if len(recalculationsRepo.AppliedScores) != 0 || len(processor.published) != 0 {
+		t.Errorf("expected no scores to be applied nor published, got %+v", recalculationsRepo.AppliedScores)
+	}
+}
+
+func TestDiffRankings(t *testing.T) {
+	live := []*common.RankedUser{{Rank: 1, ID: 7, Score: 100}, {Rank: 2, ID: 8, Score: 50}, {Rank: 3, ID: 9, Score: 10}}
+	recalculated := []*common.RankedUser{{Rank: 1, ID: 8, Score: 200}, {Rank: 2, ID: 7, Score: 100}, {Rank: 3, ID: 10, Score: 5}}
+
+	expected := []*common.RankingChange{
+		{UserID: 8, OldRank: 2, OldScore: 50, NewRank: 1, NewScore: 200},
+		{UserID: 7, OldRank: 1, OldScore: 100, NewRank: 2, NewScore: 100},
+		{UserID: 10, NewRank: 3, NewScore: 5},
+		{UserID: 9, OldRank: 3, OldScore: 10},
+	}
+	if changes := DiffRankings(live, recalculated); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes: %+v", changes)
+	}
+	if changes := DiffRankings(live, live); len(changes) != 0 {
+		t.Errorf("expected no changes between identical rankings, got %+v", changes)
+	}
+}
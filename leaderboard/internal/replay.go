@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"common"
+	"fmt"
+	"sort"
+
+	"leaderboard/repositories"
+)
+
+// BetEventScanner abstracts the store of the bet events a competition is replayed from
+type BetEventScanner interface {
+	// ScanBetEvents calls visit with the stored bet events selected by filter, in the order they happened
+	ScanBetEvents(filter repositories.BetEventFilter, visit func(event *common.BetEvent) error) error
+}
+
+// Replay is a competition rebuilt from the stored bet events
+type Replay struct {
+	CompetitionID uint
+	Events        int                              // Number of replayed events
+	Scores        []*repositories.CompetitionScore // Ordered by user ID
+	Activities    map[uint]*common.UserActivity    // map[userID]activity, for competitions whose rule uses the rule context
+}
+
+// Replayer re-evaluates the rule of a competition over stored bet events. The events are scored the same way
+// as live events, in a leaderboard of their own, so the live leaderboard and the stored scores are left untouched.
+// Events can be replayed in several passes, each pass continues from the scores of the previous ones.
+type Replayer struct {
+	competition *common.Competition
+	window      timeWindow
+	leaderboard *Leaderboard
+	scores      map[uint]*repositories.CompetitionScore
+	events      int
+}
+
+// NewReplayer returns a Replayer for a competition, or an error if the competition cannot be scored
+func NewReplayer(comp *common.Competition) (*Replayer, error) {
+	lb := NewLeaderboard(&BetRuleEvaluator{})
+	replayed := *comp
+	replayed.RuleDisabled = false // The events are replayed whatever happened to the live rule
+	lb.RegisterCompetition(&replayed)
+	registered, exists := lb.competitions[comp.ID]
+	if !exists {
+		return nil, fmt.Errorf("competition %d cannot be replayed, its rule is empty or its window is invalid", comp.ID)
+	}
+	return &Replayer{
+		competition: &replayed,
+		window:      registered.window,
+		leaderboard: lb,
+		scores:      map[uint]*repositories.CompetitionScore{},
+	}, nil
+}
+
+// Resume continues from the scores of a previous replay of the competition, the events replayed next are
+// scored on top of them
+func (r *Replayer) Resume(previous *Replay) {
+	r.leaderboard.mutex.Lock()
+	defer r.leaderboard.mutex.Unlock()
+
+	resumed := *previous
+	resumed.CompetitionID = r.competition.ID
+	r.leaderboard.installReplay(&resumed)
+	r.scores = map[uint]*repositories.CompetitionScore{}
+	for _, score := range previous.Scores {
+		copied := *score
+		copied.State = copyAggregationState(&score.State)
+		r.scores[score.UserID] = &copied
+	}
+}
+
+// Replay scores the stored bet events of the competition window selected by filter, in the order they happened.
+// The From and To bounds of filter are replaced by the competition window. visited is called after each event
+// with the number of events replayed so far, it can be nil.
+func (r *Replayer) Replay(scanner BetEventScanner, filter repositories.BetEventFilter, visited func(events int)) error {
+	return scanner.ScanBetEvents(r.Filter(filter), func(event *common.BetEvent) error {
+		updates, err := r.leaderboard.Update(*event)
+		if err != nil {
+			return fmt.Errorf("error replaying bet event %d: %w", event.EventID, err)
+		}
+		r.events++
+		for _, update := range updates {
+			if update.ActivityOnly {
+				continue
+			}
+			score, exists := r.scores[update.UserID]
+			if !exists {
+				score = &repositories.CompetitionScore{UserID: update.UserID}
+				r.scores[update.UserID] = score
+			}
+			// Events that leave the score unchanged do not count for the tie-breakers, as when stored live
+			if !exists || score.Score != update.Score {
+				score.ReachedAt = update.ReachedAt
+				score.Events++
+			}
+			score.Score = update.Score
+			score.State = update.State
+		}
+		if visited != nil {
+			visited(r.events)
+		}
+		return nil
+	})
+}
+
+// Filter returns filter restricted to the events that happened in the competition window
+func (r *Replayer) Filter(filter repositories.BetEventFilter) repositories.BetEventFilter {
+	filter.From, filter.To = r.window.start, r.window.end
+	return filter
+}
+
+// Result returns the scores rebuilt by the events replayed so far
+func (r *Replayer) Result() *Replay {
+	replay := &Replay{CompetitionID: r.competition.ID, Events: r.events, Activities: map[uint]*common.UserActivity{}}
+	for _, score := range r.scores {
+		copied := *score
+		copied.State = copyAggregationState(&score.State)
+		replay.Scores = append(replay.Scores, &copied)
+	}
+	sort.Slice(replay.Scores, func(i, j int) bool {
+		return replay.Scores[i].UserID < replay.Scores[j].UserID
+	})
+	for userID, activity := range r.leaderboard.activities[r.competition.ID] {
+		copied := *activity
+		replay.Activities[userID] = &copied
+	}
+	return replay
+}
+
+// ReplayCompetition re-evaluates the rule of a competition over the stored bet events of its window, in the order
+// they happened, and returns the scores it rebuilds
+func ReplayCompetition(comp *common.Competition, scanner BetEventScanner) (*Replay, error) {
+	replayer, err := NewReplayer(comp)
+	if err != nil {
+		return nil, err
+	}
+	if err := replayer.Replay(scanner, repositories.BetEventFilter{}, nil); err != nil {
+		return nil, err
+	}
+	return replayer.Result(), nil
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"common"
+	"errors"
+	"testing"
+
+	"leaderboard/repositories"
+)
+
+func TestReplayCompetition(t *testing.T) {
+	comp := &common.Competition{
+		ID:          1,
+		Name:        "Best Wins",
+		ScoreRule:   "event_type=='win' ? amount : 0",
+		StartTime:   "2024-06-01T00:00:00Z",
+		EndTime:     "2024-06-30T23:59:59Z",
+		Aggregation: common.AggregationMax,
+	}
+	// Stored in the order they were received, which is not the order they happened
+	repo := &repositories.MockLeaderboardsRepo{StoredBetEvents: []common.BetEvent{
+		{EventID: 3, EventType: common.EventTypeWin, UserID: 7, Amount: 20, ExchangeRate: 1.0, Timestamp: "2024-06-03T00:00:00Z"},
+		{EventID: 1, EventType: common.EventTypeWin, UserID: 7, Amount: 20, ExchangeRate: 1.0, Timestamp: "2024-06-01T00:00:00Z"},
+		{EventID: 2, EventType: common.EventTypeWin, UserID: 8, Amount: 50, ExchangeRate: 2.0, Timestamp: "2024-06-02T00:00:00Z"},
+		{EventID: 4, EventType: common.EventTypeBet, UserID: 9, Amount: 10, ExchangeRate: 1.0, Timestamp: "2024-06-04T00:00:00Z"},
+		{EventID: 5, EventType: common.EventTypeWin, UserID: 9, Amount: 90, ExchangeRate: 1.0, Timestamp: "2024-07-01T00:00:00Z"}, // After the competition
+	}}
+
+	replay, err := ReplayCompetition(comp, repo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replay.CompetitionID != comp.ID || replay.Events != 4 {
+		t.Errorf("expected the 4 events of the window to be replayed, got %d", replay.Events)
+	}
+	if len(replay.Scores) != 2 {
+		t.Fatalf("expected 2 scores, got %+v", replay.Scores)
+	}
+	// Both wins of user 7 are aggregated in the order they happened, the second one leaves the score unchanged
+	first, second := replay.Scores[0], replay.Scores[1]
+	if first.UserID != 7 || first.Score != 20 || first.Events != 1 || first.ReachedAt != "2024-06-01T00:00:00.000000000Z" || first.State.Count != 2 {
+		t.Errorf("unexpected score of user 7: %+v", first)
+	}
+	if second.UserID != 8 || second.Score != 100 || second.Events != 1 {
+		t.Errorf("unexpected score of user 8: %+v", second)
+	}
+}
+
+func TestReplayCompetition_Errors(t *testing.T) {
+	if _, err := ReplayCompetition(&common.Competition{ID: 1, Name: "No Rule"}, &repositories.MockLeaderboardsRepo{}); err == nil {
+		t.Error("expected an error for a competition without a rule")
+	}
+	repo := &repositories.MockLeaderboardsRepo{ReturnErr: errors.New("scan error")}
+	if _, err := ReplayCompetition(&common.Competition{ID: 1, Name: "Scan", ScoreRule: "amount"}, repo); err == nil {
+		t.Error("expected the scan error to be returned")
+	}
+}
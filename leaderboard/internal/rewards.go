@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"common"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rewardTier is the reward paid to every rank between from and to (inclusive).
+// A tier with to == 0 is open-ended and covers every rank from `from` onwards.
+type rewardTier struct {
+	key    string
+	from   int
+	to     int
+	amount int
+}
+
+// RewardTable holds the parsed rank ranges of a competition's Rewards map, sorted by rank
+type RewardTable struct {
+	tiers []rewardTier
+}
+
+// Payout is the reward a user receives for their final rank in a competition
+type Payout struct {
+	Rank   int     `json:"rank"`
+	UserID uint    `json:"user_id"`
+	Score  float64 `json:"score"`
+	Reward int     `json:"reward"`
+}
+
+// ParseRewards parses a Rewards map with keys in the forms "N" (single rank),
+// "N-M" (closed range) and "N+" (open-ended range).
+// The ranges must start at rank 1 and must not overlap or leave gaps between them.
+// An empty map is valid and pays nothing.
+func ParseRewards(rewards map[string]int) (*RewardTable, error) {
+	table := &RewardTable{}
+	for key, amount := range rewards {
+		tier, err := parseRewardTier(key)
+		if err != nil {
+			return nil, err
+		}
+		if amount < 0 {
+			return nil, fmt.Errorf("reward for ranks %q must not be negative, got %d", key, amount)
+		}
+		tier.amount = amount
+		table.tiers = append(table.tiers, tier)
+	}
+
+	sort.Slice(table.tiers, func(i, j int) bool {
+		return table.tiers[i].from < table.tiers[j].from
+	})
+
+	nextRank := 1
+	for i, tier := range table.tiers {
+		if tier.from < nextRank {
+			return nil, fmt.Errorf("reward ranks %q overlap with %q", tier.key, table.tiers[i-1].key)
+		}
+		if tier.from > nextRank {
+			return nil, fmt.Errorf("reward ranks have a gap: rank %d is not covered", nextRank)
+		}
+		if tier.to == 0 && i != len(table.tiers)-1 {
+			return nil, fmt.Errorf("reward ranks %q overlap with %q", table.tiers[i+1].key, tier.key)
+		}
+		nextRank = tier.to + 1
+	}
+	return table, nil
+}
+
+// parseRewardTier parses a single rank-range key of a Rewards map
+func parseRewardTier(key string) (rewardTier, error) {
+	key = strings.TrimSpace(key)
+	tier := rewardTier{key: key}
+
+	var err error
+	switch {
+	case strings.HasSuffix(key, "+"):
+		tier.from, err = parseRank(strings.TrimSuffix(key, "+"))
+	case strings.Contains(key, "-"):
+		bounds := strings.SplitN(key, "-", 2)
+		if tier.from, err = parseRank(bounds[0]); err == nil {
+			tier.to, err = parseRank(bounds[1])
+		}
+		if err == nil && tier.to < tier.from {
+			err = fmt.Errorf("range end is lower than its start")
+		}
+	default:
+		tier.from, err = parseRank(key)
+		tier.to = tier.from
+	}
+	if err != nil {
+		return rewardTier{}, fmt.Errorf("invalid reward ranks %q: %v", key, err)
+	}
+	return tier, nil
+}
+
+// parseRank parses a 1-based rank
+func parseRank(s string) (int, error) {
+	rank, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if rank < 1 {
+		return 0, fmt.Errorf("rank must be at least 1, got %d", rank)
+	}
+	return rank, nil
+}
+
+// RewardFor returns the reward paid for the given rank, 0 if the rank is not rewarded
+func (rt *RewardTable) RewardFor(rank int) int {
+	for _, tier := range rt.tiers {
+		if rank >= tier.from && (tier.to == 0 || rank <= tier.to) {
+			return tier.amount
+		}
+	}
+	return 0
+}
+
+// MaxRank returns the lowest rank that is rewarded, or -1 if the rewards are open-ended
+func (rt *RewardTable) MaxRank() int {
+	if len(rt.tiers) == 0 {
+		return 0
+	}
+	last := rt.tiers[len(rt.tiers)-1]
+	if last.to == 0 {
+		return -1
+	}
+	return last.to
+}
+
+// Payouts returns the reward for each ranked user. Tied users share a rank and get the reward of that rank.
+// Users whose rank is not rewarded are omitted.
+func (rt *RewardTable) Payouts(rankedUsers []*common.RankedUser) []Payout {
+	payouts := []Payout{}
+	for _, user := range rankedUsers {
+		reward := rt.RewardFor(user.Rank)
+		if reward == 0 {
+			continue
+		}
+		payouts = append(payouts, Payout{
+			Rank:   user.Rank,
+			UserID: user.ID,
+			Score:  user.Score,
+			Reward: reward,
+		})
+	}
+	return payouts
+}
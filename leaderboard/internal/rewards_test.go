@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"common"
+	"testing"
+)
+
+func TestParseRewards_Valid(t *testing.T) {
+	table, err := ParseRewards(map[string]int{"1": 100, "2-5": 50, "6+": 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[int]int{1: 100, 2: 50, 5: 50, 6: 20, 1000: 20}
+	for rank, reward := range expected {
+		if got := table.RewardFor(rank); got != reward {
+			t.Errorf("rank %d: expected reward %d, got %d", rank, reward, got)
+		}
+	}
+	if table.MaxRank() != -1 {
+		t.Errorf("expected open-ended rewards to have MaxRank -1, got %d", table.MaxRank())
+	}
+
+	bounded, err := ParseRewards(map[string]int{"1-2": 100, "3-5": 50})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bounded.MaxRank() != 5 {
+		t.Errorf("expected MaxRank 5, got %d", bounded.MaxRank())
+	}
+	if bounded.RewardFor(6) != 0 {
+		t.Errorf("expected no reward past the last range, got %d", bounded.RewardFor(6))
+	}
+
+	empty, err := ParseRewards(nil)
+	if err != nil {
+		t.Fatalf("unexpected error for empty rewards: %v", err)
+	}
+	if empty.MaxRank() != 0 {
+		t.Errorf("expected empty rewards to have MaxRank 0, got %d", empty.MaxRank())
+	}
+}
+
+func TestParseRewards_Invalid(t *testing.T) {
+	cases := map[string]map[string]int{
+		"not a number":        {"first": 100},
+		"rank zero":           {"0": 100},
+		"reversed range":      {"1": 100, "5-2": 50},
+		"negative reward":     {"1": -100},
+		"gap at start":        {"2-5": 50},
+		"gap between ranges":  {"1": 100, "3-5": 50},
+		"overlapping ranges":  {"1-3": 100, "3-5": 50},
+		"duplicate rank":      {"1": 100, "1-2": 50},
+		"range after open":    {"1": 100, "2+": 50, "5-6": 20},
+		"two open-ended":      {"1": 100, "2+": 50, "3+": 20},
+		"missing range start": {"-3": 100},
+	}
+	for name, rewards := range cases {
+		if _, err := ParseRewards(rewards); err == nil {
+			t.Errorf("%s: expected error for rewards %v", name, rewards)
+		}
+	}
+}
+
+func TestRewardTable_Payouts(t *testing.T) {
+	table, err := ParseRewards(map[string]int{"1": 100, "2-3": 50})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Users 3 and 9 are tied and both get the reward of rank 2
+	users := []*common.RankedUser{
+		{Rank: 1, ID: 7, Score: 300},
+		{Rank: 2, ID: 3, Score: 200},
+		{Rank: 2, ID: 9, Score: 200},
+		{Rank: 4, ID: 1, Score: 50},
+	}
+
+	payouts := table.Payouts(users)
+	if len(payouts) != 3 {
+		t.Fatalf("expected 3 payouts, got %d", len(payouts))
+	}
+	expected := []Payout{
+		{Rank: 1, UserID: 7, Score: 300, Reward: 100},
+		{Rank: 2, UserID: 3, Score: 200, Reward: 50},
+		{Rank: 2, UserID: 9, Score: 200, Reward: 50},
+	}
+	for i, payout := range payouts {
+		if payout != expected[i] {
+			t.Errorf("payout %d: expected %+v, got %+v", i, expected[i], payout)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxConsecutiveRuleErrors is the number of consecutive failed evaluations after which
+// a competition's score rule is disabled and the competition stops scoring
+const MaxConsecutiveRuleErrors = 10
+
+// RuleErrorStatus reports the evaluation errors of a competition's score rule
+type RuleErrorStatus struct {
+	CompetitionID     uint   `json:"competition_id"`
+	Rule              string `json:"rule"`
+	ErrorCount        int    `json:"error_count"`
+	ConsecutiveErrors int    `json:"consecutive_errors"`
+	LastError         string `json:"last_error"`
+	LastEventID       uint   `json:"last_event_id"`
+	LastErrorAt       string `json:"last_error_at"`
+	Disabled          bool   `json:"disabled"`
+}
+
+// ruleErrorTracker records the rule evaluation errors of each competition
+// and disables competitions whose rule keeps failing
+type ruleErrorTracker struct {
+	mutex     sync.RWMutex
+	statuses  map[uint]*RuleErrorStatus // map[competitionID]status
+	threshold int
+}
+
+func newRuleErrorTracker(threshold int) *ruleErrorTracker {
+	return &ruleErrorTracker{
+		statuses:  map[uint]*RuleErrorStatus{},
+		threshold: threshold,
+	}
+}
+
+// recordError records a failed evaluation of the competition's rule.
+// It returns true if the competition has just been disabled because of it.
+func (t *ruleErrorTracker) recordError(competitionID uint, rule string, eventID uint, err error) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	status, exists := t.statuses[competitionID]
+	if !exists {
+		status = &RuleErrorStatus{CompetitionID: competitionID, Rule: rule}
+		t.statuses[competitionID] = status
+	}
+	if status.Disabled {
+		return false
+	}
+	status.ErrorCount++
+	status.ConsecutiveErrors++
+	status.LastError = err.Error()
+	status.LastEventID = eventID
+	status.LastErrorAt = time.Now().UTC().Format(time.RFC3339)
+	if status.ConsecutiveErrors >= t.threshold {
+		status.Disabled = true
+		return true
+	}
+	return false
+}
+
+// disablesOnError reports whether another failed evaluation of the competition's rule would disable it
+func (t *ruleErrorTracker) disablesOnError(competitionID uint) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	status, exists := t.statuses[competitionID]
+	if !exists {
+		return t.threshold <= 1
+	}
+	return !status.Disabled && status.ConsecutiveErrors+1 >= t.threshold
+}
+
+// recordSuccess resets the consecutive errors of the competition's rule
+func (t *ruleErrorTracker) recordSuccess(competitionID uint) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if status, exists := t.statuses[competitionID]; exists && !status.Disabled {
+		status.ConsecutiveErrors = 0
+	}
+}
+
+// isDisabled reports whether the competition has been disabled because its rule keeps failing
+func (t *ruleErrorTracker) isDisabled(competitionID uint) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	status, exists := t.statuses[competitionID]
+	return exists && status.Disabled
+}
+
+// disable marks a competition as disabled without recording an error, for competitions that were disabled
+// before the leaderboard was loaded
+func (t *ruleErrorTracker) disable(competitionID uint, rule string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.statuses[competitionID] = &RuleErrorStatus{CompetitionID: competitionID, Rule: rule, Disabled: true}
+}
+
+// remove forgets the errors of a competition
+func (t *ruleErrorTracker) remove(competitionID uint) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.statuses, competitionID)
+}
+
+// list returns a copy of the error status of every competition whose rule has failed, ordered by competition ID
+func (t *ruleErrorTracker) list() []*RuleErrorStatus {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	statuses := make([]*RuleErrorStatus, 0, len(t.statuses))
+	for _, status := range t.statuses {
+		statusCopy := *status
+		statuses = append(statuses, &statusCopy)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].CompetitionID < statuses[j].CompetitionID
+	})
+	return statuses
+}
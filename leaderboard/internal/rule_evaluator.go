@@ -1,57 +1,352 @@
 package internal
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/vm"
 
 	"common"
 )
 
+// BetRuleEvaluator is safe for concurrent use, rules can be added and removed while events are evaluated
 type BetRuleEvaluator struct {
-	rules []string
+	mutex sync.RWMutex
+	rules []*compiledRule
+	index *ruleIndex
+}
+
+// compiledRule is a rule compiled once when it is added to the evaluator.
+// If the rule does not compile, err is reported every time the rule is evaluated.
+type compiledRule struct {
+	rule        string
+	program     *vm.Program
+	err         error
+	filter      *ruleFilter // nil if the rule cannot be indexed
+	usesContext bool        // the rule uses the rule context, so it is evaluated for each competition
+	usesRank    bool        // the rule uses the rank of the user, which is only computed for such rules
+	position    int         // position of the rule in the evaluator, to keep matches in the order rules were added
 }
 
+// Match is the result of evaluating a rule against an event.
+// Err is set if the rule failed to compile or run, in which case Result is nil.
+// UsesContext is set if the rule uses the rule context: it has not been evaluated and must be evaluated
+// with EvaluateRule for each competition that uses it. UsesRank is set if the context must include the rank.
 type Match struct {
-	Rule   string
-	Result any
+	Rule        string
+	Result      any
+	Err         error
+	UsesContext bool
+	UsesRank    bool
+}
+
+// RuleContext is the history of the user of an event in a competition, before the event
+type RuleContext struct {
+	Activity         common.UserActivity
+	Score            float64
+	Rank             int       // Rank of the user in the leaderboard, 0 if they have no score yet or the rule does not use it
+	CompetitionStart time.Time // Zero if the competition has no start time
+}
+
+// contextIdentifiers are the identifiers of the environment that depend on the rule context
+var contextIdentifiers = map[string]bool{
+	"user_events":       true,
+	"user_bets":         true,
+	"user_score":        true,
+	"user_rank":         true,
+	"user_first_seen":   true,
+	"user_last_seen":    true,
+	"user_last_bet":     true,
+	"hours_since_start": true,
+}
+
+// BetEventEnv is the environment score rules are evaluated in, the expr tags are the names rules use
+type BetEventEnv struct {
+	EventID      uint    `expr:"event_id"`
+	EventType    string  `expr:"event_type"`
+	UserID       uint    `expr:"user_id"`
+	Amount       float64 `expr:"amount"`
+	Currency     string  `expr:"currency"`
+	ExchangeRate float64 `expr:"exchange_rate"`
+	Game         string  `expr:"game"`
+	Distributor  string  `expr:"distributor"`
+	Studio       string  `expr:"studio"`
+	Timestamp    string  `expr:"timestamp"`
+
+	// History of the user in the competition before the event, from the rule context
+	UserEvents    int     `expr:"user_events"`     // Number of events of the user
+	UserBets      int     `expr:"user_bets"`       // Number of bet events of the user
+	UserScore     float64 `expr:"user_score"`      // Score of the user
+	UserRank      int     `expr:"user_rank"`       // Rank of the user in the leaderboard, 0 if they have no score yet
+	UserFirstSeen string  `expr:"user_first_seen"` // Timestamp of the first event of the user, "" if there is none
+	UserLastSeen  string  `expr:"user_last_seen"`  // Timestamp of the last event of the user, "" if there is none
+	UserLastBet   string  `expr:"user_last_bet"`   // Timestamp of the last bet event of the user, "" if there is none
+
+	// Helpers on RFC3339 timestamps, in UTC. They return -1 ("" for date) for timestamps that cannot be parsed.
+	DayOfWeek       func(timestamp string) int     `expr:"day_of_week"`       // 0 for Sunday to 6 for Saturday
+	Hour            func(timestamp string) int     `expr:"hour"`              // 0 to 23
+	Date            func(timestamp string) string  `expr:"date"`              // YYYY-MM-DD
+	HoursSinceStart func(timestamp string) float64 `expr:"hours_since_start"` // Hours since the competition started, 0 if it has no start time
 }
 
-// AddRule appends a rule string to the RuleEvaluator
+// AddRule compiles a rule and appends it to the RuleEvaluator
 func (re *BetRuleEvaluator) AddRule(rule string) {
-	re.rules = append(re.rules, rule)
+	compiled := &compiledRule{rule: rule}
+	compiled.program, compiled.err = expr.Compile(rule, expr.Env(BetEventEnv{}))
+	if compiled.err == nil {
+		compiled.usesContext = usesRuleContext(compiled.program.Node())
+		compiled.usesRank = usesIdentifier(compiled.program.Node(), "user_rank")
+		// Rules using the context see every event, so the user history they are given is complete
+		if !compiled.usesContext {
+			compiled.filter = extractRuleFilter(compiled.program.Node())
+		}
+	}
+
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+	re.rules = append(re.rules, compiled)
+	re.index = newRuleIndex(re.rules)
+}
+
+// RemoveRule removes a rule string from the RuleEvaluator
+func (re *BetRuleEvaluator) RemoveRule(rule string) {
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+
+	for i, r := range re.rules {
+		if r.rule == rule {
+			re.rules = append(re.rules[:i], re.rules[i+1:]...)
+			re.index = newRuleIndex(re.rules)
+			return
+		}
+	}
 }
 
-// EvaluateRules evaluates an event against a list of rules and returns matches
+// EvaluateRules evaluates an event against a list of rules and returns matches.
+// Rules guarded by an equality on a field the event does not match are not run, as they would evaluate to 0.
+// A rule that fails is reported in its match, so it does not prevent the other rules from being evaluated.
 func (evaluator *BetRuleEvaluator) EvaluateRules(event common.BetEvent) ([]Match, error) {
-	betEventEnv := map[string]any{
-		"event_id":      event.EventID,
-		"event_type":    event.EventType.String(),
-		"user_id":       event.UserID,
-		"amount":        event.Amount,
-		"currency":      event.Currency,
-		"exchange_rate": event.ExchangeRate,
-		"game":          event.Game,
-		"distributor":   event.Distributor,
-		"studio":        event.Studio,
-		"timestamp":     event.Timestamp,
-	}
-
-	// TODO: cache the compiled programs for each rule to avoid recompilation
-
-	var matches []Match
-	for _, rule := range evaluator.rules {
-		program, err := expr.Compile(rule, expr.Env(betEventEnv))
-		if err != nil {
-			return nil, err
+	evaluator.mutex.RLock()
+	defer evaluator.mutex.RUnlock()
+
+	if evaluator.index == nil {
+		return nil, nil
+	}
+	betEventEnv := newBetEventEnv(event, RuleContext{})
+
+	candidates := evaluator.index.candidates(betEventEnv)
+	matches := make([]Match, 0, len(candidates))
+	for _, rule := range candidates {
+		if rule.err != nil {
+			matches = append(matches, Match{Rule: rule.rule, Err: rule.err})
+			continue
 		}
-		output, err := expr.Run(program, betEventEnv)
+		if rule.usesContext {
+			matches = append(matches, Match{Rule: rule.rule, UsesContext: true, UsesRank: rule.usesRank})
+			continue
+		}
+		output, err := expr.Run(rule.program, betEventEnv)
 		if err != nil {
-			return nil, err
+			matches = append(matches, Match{Rule: rule.rule, Err: err})
+			continue
 		}
 
 		matches = append(matches, Match{
-			Rule:   rule,
+			Rule:   rule.rule,
 			Result: output,
 		})
 	}
 	return matches, nil
 }
+
+// EvaluateRule evaluates a rule that uses the rule context against an event, with the context of
+// the user in one of the competitions using the rule
+func (evaluator *BetRuleEvaluator) EvaluateRule(rule string, event common.BetEvent, context RuleContext) Match {
+	evaluator.mutex.RLock()
+	defer evaluator.mutex.RUnlock()
+
+	for _, compiled := range evaluator.rules {
+		if compiled.rule != rule {
+			continue
+		}
+		if compiled.err != nil {
+			return Match{Rule: rule, Err: compiled.err}
+		}
+		output, err := expr.Run(compiled.program, newBetEventEnv(event, context))
+		if err != nil {
+			return Match{Rule: rule, Err: err}
+		}
+		return Match{Rule: rule, Result: output}
+	}
+	return Match{Rule: rule, Err: fmt.Errorf("rule %q is not registered", rule)}
+}
+
+// RuleTestResult is the outcome of evaluating a rule against a single event
+type RuleTestResult struct {
+	EventID uint    `json:"event_id"`
+	Result  any     `json:"result"`
+	Score   float64 `json:"score"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// ValidateRule compiles a rule against the bet event environment and checks that it evaluates to a number.
+// Unknown identifiers, syntax errors and non-numeric results are reported as errors.
+func ValidateRule(rule string) error {
+	_, err := compileRule(rule)
+	return err
+}
+
+// DryRunRule evaluates a rule against the given events without registering it,
+// returning the raw result of the rule and the score it awards (in USD) for each event.
+// Each event is evaluated as if it was the first event of its user.
+func DryRunRule(rule string, events []common.BetEvent) ([]RuleTestResult, error) {
+	program, err := compileRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]RuleTestResult, 0, len(events))
+	for _, event := range events {
+		result := RuleTestResult{EventID: event.EventID}
+		output, err := expr.Run(program, newBetEventEnv(event, RuleContext{}))
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Result = output
+		amount, err := toFloat64(output)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Score = toUSD(amount, event.ExchangeRate)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// compileRule compiles a rule against the bet event environment and checks that it evaluates to a number
+func compileRule(rule string) (*vm.Program, error) {
+	if strings.TrimSpace(rule) == "" {
+		return nil, fmt.Errorf("rule is empty")
+	}
+	program, err := expr.Compile(rule, expr.Env(BetEventEnv{}))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNumericResult(program.Node()); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+// checkNumericResult checks that every value a rule can evaluate to is a number.
+// Both branches of conditionals are checked, values whose type is only known at runtime are accepted.
+func checkNumericResult(node ast.Node) error {
+	if conditional, ok := node.(*ast.ConditionalNode); ok {
+		if err := checkNumericResult(conditional.Exp1); err != nil {
+			return err
+		}
+		return checkNumericResult(conditional.Exp2)
+	}
+
+	resultType := node.Type()
+	if resultType == nil {
+		return nil
+	}
+	switch resultType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Interface:
+		return nil
+	}
+	return fmt.Errorf("rule must evaluate to a number, but %q evaluates to %s", node.String(), resultType)
+}
+
+// usesRuleContext reports whether a rule uses any identifier that depends on the rule context
+func usesRuleContext(node ast.Node) bool {
+	finder := &identifierFinder{identifiers: contextIdentifiers}
+	ast.Walk(&node, finder)
+	return finder.found
+}
+
+// usesIdentifier reports whether a compiled rule uses an identifier of the environment
+func usesIdentifier(node ast.Node, identifier string) bool {
+	finder := &identifierFinder{identifiers: map[string]bool{identifier: true}}
+	ast.Walk(&node, finder)
+	return finder.found
+}
+
+// identifierFinder is an ast.Visitor looking for identifiers of the environment
+type identifierFinder struct {
+	identifiers map[string]bool
+	found       bool
+}
+
+func (f *identifierFinder) Visit(node *ast.Node) {
+	if identifier, ok := (*node).(*ast.IdentifierNode); ok && f.identifiers[identifier.Value] {
+		f.found = true
+	}
+}
+
+// newBetEventEnv returns the variables that rules can use to evaluate a bet event in a rule context
+func newBetEventEnv(event common.BetEvent, context RuleContext) BetEventEnv {
+	return BetEventEnv{
+		EventID:      event.EventID,
+		EventType:    event.EventType.String(),
+		UserID:       event.UserID,
+		Amount:       event.Amount,
+		Currency:     event.Currency,
+		ExchangeRate: event.ExchangeRate,
+		Game:         event.Game,
+		Distributor:  event.Distributor,
+		Studio:       event.Studio,
+		Timestamp:    event.Timestamp,
+
+		UserEvents:    context.Activity.Events,
+		UserBets:      context.Activity.Bets,
+		UserScore:     context.Score,
+		UserRank:      context.Rank,
+		UserFirstSeen: context.Activity.FirstSeen,
+		UserLastSeen:  context.Activity.LastSeen,
+		UserLastBet:   context.Activity.LastBet,
+
+		DayOfWeek: func(timestamp string) int {
+			t, err := time.Parse(time.RFC3339, timestamp)
+			if err != nil {
+				return -1
+			}
+			return int(t.UTC().Weekday())
+		},
+		Hour: func(timestamp string) int {
+			t, err := time.Parse(time.RFC3339, timestamp)
+			if err != nil {
+				return -1
+			}
+			return t.UTC().Hour()
+		},
+		Date: func(timestamp string) string {
+			t, err := time.Parse(time.RFC3339, timestamp)
+			if err != nil {
+				return ""
+			}
+			return t.UTC().Format(time.DateOnly)
+		},
+		HoursSinceStart: func(timestamp string) float64 {
+			t, err := time.Parse(time.RFC3339, timestamp)
+			if err != nil {
+				return -1
+			}
+			if context.CompetitionStart.IsZero() {
+				return 0
+			}
+			return t.Sub(context.CompetitionStart).Hours()
+		},
+	}
+}
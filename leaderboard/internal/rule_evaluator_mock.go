@@ -6,13 +6,31 @@ import "common"
 type MockRuleEvaluator struct {
 	Matches       []Match
 	EvaluateError error
+	AddedRules    []string
+	RemovedRules  []string
+	// EvaluateRuleFunc evaluates the rules that use the rule context, they score 0 if it is nil
+	EvaluateRuleFunc func(rule string, event common.BetEvent, context RuleContext) Match
 }
 
-// AddRule is a no-op for the mock implementation
+// AddRule records the added rule
 func (m *MockRuleEvaluator) AddRule(rule string) {
+	m.AddedRules = append(m.AddedRules, rule)
+}
+
+// RemoveRule records the removed rule
+func (m *MockRuleEvaluator) RemoveRule(rule string) {
+	m.RemovedRules = append(m.RemovedRules, rule)
 }
 
 // EvaluateRules simulates rule evaluation by returning predefined matches
 func (m *MockRuleEvaluator) EvaluateRules(event common.BetEvent) ([]Match, error) {
 	return m.Matches, m.EvaluateError
 }
+
+// EvaluateRule evaluates a rule that uses the rule context with EvaluateRuleFunc
+func (m *MockRuleEvaluator) EvaluateRule(rule string, event common.BetEvent, context RuleContext) Match {
+	if m.EvaluateRuleFunc == nil {
+		return Match{Rule: rule, Result: 0}
+	}
+	return m.EvaluateRuleFunc(rule, event, context)
+}
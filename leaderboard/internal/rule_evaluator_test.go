@@ -2,7 +2,9 @@ package internal
 
 import (
 	"common"
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestBetRuleEvaluator_AddRuleAndEvaluateRules(t *testing.T) {
@@ -49,9 +51,183 @@ func TestBetRuleEvaluator_AddRuleAndEvaluateRules(t *testing.T) {
 func TestBetRuleEvaluator_EvaluateRules_Error(t *testing.T) {
 	eval := &BetRuleEvaluator{}
 	eval.AddRule("not a valid expr")
-	event := common.BetEvent{}
-	_, err := eval.EvaluateRules(event)
-	if err == nil {
+	eval.AddRule("amount")
+	event := common.BetEvent{Amount: 5}
+	matches, err := eval.EvaluateRules(event)
+	if err != nil {
+		t.Fatalf("a failing rule should not fail the whole evaluation: %v", err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(matches))
+	}
+	if matches[0].Err == nil {
 		t.Error("expected error for invalid rule expression")
 	}
+	if matches[1].Err != nil || matches[1].Result != 5.0 {
+		t.Errorf("expected the valid rule to be evaluated, got %+v", matches[1])
+	}
+}
+
+func TestBetRuleEvaluator_RemoveRule(t *testing.T) {
+	eval := &BetRuleEvaluator{}
+	eval.AddRule("amount")
+	eval.AddRule("amount * 2")
+	eval.RemoveRule("amount")
+	eval.RemoveRule("unknown rule")
+
+	matches, err := eval.EvaluateRules(common.BetEvent{Amount: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Rule != "amount * 2" {
+		t.Errorf("expected only the remaining rule to be evaluated, got %+v", matches)
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	valid := []string{
+		"event_type == 'bet' ? amount : 0",
+		"amount * exchange_rate",
+		"event_type == 'bet' && game == 'Blackjack' ? 10 : event_type == 'win' ? amount : 0",
+		"user_id",
+	}
+	for _, rule := range valid {
+		if err := ValidateRule(rule); err != nil {
+			t.Errorf("expected rule %q to be valid, got %v", rule, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"   ",
+		"not a valid expr",
+		"bet_amount * 2",
+		"game",
+		"event_type == 'bet'",
+		"event_type == 'bet' ? amount : 'nothing'",
+	}
+	for _, rule := range invalid {
+		if err := ValidateRule(rule); err == nil {
+			t.Errorf("expected rule %q to be invalid", rule)
+		}
+	}
+}
+
+func TestDryRunRule(t *testing.T) {
+	events := []common.BetEvent{
+		{EventID: 1, EventType: common.EventTypeBet, Amount: 10, ExchangeRate: 2.0},
+		{EventID: 2, EventType: common.EventTypeWin, Amount: 10, ExchangeRate: 1.0},
+	}
+	results, err := DryRunRule("event_type == 'bet' ? amount : 0", events)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].EventID != 1 || results[0].Result != 10.0 || results[0].Score != 20.0 {
+		t.Errorf("unexpected result for bet event: %+v", results[0])
+	}
+	if results[1].EventID != 2 || results[1].Score != 0 || results[1].Error != "" {
+		t.Errorf("unexpected result for win event: %+v", results[1])
+	}
+
+	if _, err := DryRunRule("unknown * 2", events); err == nil {
+		t.Errorf("expected error for invalid rule")
+	}
+}
+
+func BenchmarkBetRuleEvaluator_EvaluateRules(b *testing.B) {
+	event := common.BetEvent{
+		EventID:      1,
+		EventType:    common.EventTypeBet,
+		UserID:       42,
+		Amount:       123.45,
+		Currency:     "USD",
+		ExchangeRate: 1.0,
+		Game:         "Game7",
+		Distributor:  "evo",
+		Studio:       "StudioX",
+		Timestamp:    "2023-10-01T12:00:00Z",
+	}
+
+	// Indexed rules are guarded by the game, so only one of them is run per event.
+	// Unindexed rules have no equality guard and are all run for every event.
+	templates := map[string]string{
+		"indexed":   "event_type == 'bet' && game == 'Game%d' ? amount * %d : 0",
+		"unindexed": "amount > %d ? amount * %d : 0",
+	}
+	for _, kind := range []string{"indexed", "unindexed"} {
+		for _, numRules := range []int{10, 100, 1000} {
+			b.Run(fmt.Sprintf("%s_%d_rules", kind, numRules), func(b *testing.B) {
+				eval := &BetRuleEvaluator{}
+				for i := 0; i < numRules; i++ {
+					eval.AddRule(fmt.Sprintf(templates[kind], i, i%5+1))
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := eval.EvaluateRules(event); err != nil {
+						b.Fatalf("unexpected error: %v", err)
+					}
+				}
+				b.ReportMetric(float64(b.N*numRules)/b.Elapsed().Seconds(), "rules/s")
+			})
+		}
+	}
+}
+
+func TestBetRuleEvaluator_RuleContext(t *testing.T) {
+	eval := &BetRuleEvaluator{}
+	contextRule := "event_type == 'bet' && user_bets >= 2 ? amount : 0"
+	eval.AddRule(contextRule)
+	eval.AddRule("amount")
+	eval.AddRule("user_rank <= 3 ? amount : 0")
+
+	event := common.BetEvent{EventID: 1, EventType: common.EventTypeBet, Amount: 5, Timestamp: "2024-06-01T18:30:00Z"}
+	matches, err := eval.EvaluateRules(event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(matches) != 3 || !matches[0].UsesContext || matches[0].Result != nil || matches[1].UsesContext || !matches[2].UsesContext {
+		t.Fatalf("expected only the context rules to be left for EvaluateRule, got %+v", matches)
+	}
+	if matches[0].UsesRank || !matches[2].UsesRank {
+		t.Errorf("expected only the rule using user_rank to need the rank, got %+v", matches)
+	}
+
+	if match := eval.EvaluateRule(contextRule, event, RuleContext{Activity: common.UserActivity{Bets: 1}}); match.Result != 0 {
+		t.Errorf("expected 0 before the user has 2 bets, got %+v", match)
+	}
+	if match := eval.EvaluateRule(contextRule, event, RuleContext{Activity: common.UserActivity{Bets: 2}}); match.Result != 5.0 {
+		t.Errorf("expected the amount once the user has 2 bets, got %+v", match)
+	}
+	if match := eval.EvaluateRule("user_score", event, RuleContext{}); match.Err == nil {
+		t.Errorf("expected an error for a rule that is not registered")
+	}
+}
+
+func TestBetRuleEvaluator_Helpers(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2024-06-01T00:00:00Z")
+	context := RuleContext{CompetitionStart: start}
+	event := common.BetEvent{Timestamp: "2024-06-01T18:30:00Z"} // A Saturday
+	cases := []struct {
+		rule     string
+		expected any
+	}{
+		{"day_of_week(timestamp)", 6},
+		{"hour(timestamp)", 18},
+		{"date(timestamp) == '2024-06-01' ? 1 : 0", 1},
+		{"hours_since_start(timestamp)", 18.5},
+		{"day_of_week('not a time')", -1},
+		{"date(user_last_bet) == '' ? 2 : 1", 2},
+	}
+	for _, c := range cases {
+		eval := &BetRuleEvaluator{}
+		eval.AddRule(c.rule)
+		if match := eval.EvaluateRule(c.rule, event, context); match.Err != nil || match.Result != c.expected {
+			t.Errorf("%s: expected %v, got %+v", c.rule, c.expected, match)
+		}
+	}
 }
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"sort"
+
+	"github.com/expr-lang/expr/ast"
+)
+
+// indexedFields are the event fields rules can be indexed by, from the most to the least selective.
+// When a rule has equality guards on several of them, it is indexed by the most selective one.
+var indexedFields = []string{"game", "studio", "distributor", "currency", "event_type"}
+
+// ruleFilter is a top-level equality guard of a rule, the rule can only score events whose field has this value
+type ruleFilter struct {
+	field string
+	value string
+}
+
+// ruleIndex maps the values of the indexed fields to the rules guarded by them, so only the rules that can
+// score an event are run. Rules without an indexable guard are always run.
+type ruleIndex struct {
+	byField   map[string]map[string][]*compiledRule // map[field]map[value]rules
+	unindexed []*compiledRule
+}
+
+// newRuleIndex builds the index of the given rules
+func newRuleIndex(rules []*compiledRule) *ruleIndex {
+	index := &ruleIndex{byField: map[string]map[string][]*compiledRule{}}
+	for position, rule := range rules {
+		rule.position = position
+		if rule.filter == nil {
+			index.unindexed = append(index.unindexed, rule)
+			continue
+		}
+		if _, exists := index.byField[rule.filter.field]; !exists {
+			index.byField[rule.filter.field] = map[string][]*compiledRule{}
+		}
+		index.byField[rule.filter.field][rule.filter.value] = append(index.byField[rule.filter.field][rule.filter.value], rule)
+	}
+	return index
+}
+
+// candidates returns the rules that can score the event, in the order they were added
+func (index *ruleIndex) candidates(env BetEventEnv) []*compiledRule {
+	candidates := append([]*compiledRule{}, index.unindexed...)
+	for field, rulesByValue := range index.byField {
+		candidates = append(candidates, rulesByValue[eventFieldValue(env, field)]...)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].position < candidates[j].position
+	})
+	return candidates
+}
+
+// eventFieldValue returns the value of one of the indexed fields of an event
+func eventFieldValue(env BetEventEnv, field string) string {
+	switch field {
+	case "event_type":
+		return env.EventType
+	case "game":
+		return env.Game
+	case "distributor":
+		return env.Distributor
+	case "studio":
+		return env.Studio
+	case "currency":
+		return env.Currency
+	}
+	return ""
+}
+
+// extractRuleFilter returns the most selective equality guard of a rule, or nil if the rule cannot be indexed.
+// A rule can only be indexed if it has the form `guard && ... ? score : 0`, so that skipping it
+// for events that do not satisfy the guard gives the same result as evaluating it.
+func extractRuleFilter(node ast.Node) *ruleFilter {
+	conditional, ok := node.(*ast.ConditionalNode)
+	if !ok || !isZeroLiteral(conditional.Exp2) {
+		return nil
+	}
+
+	guards := map[string]string{}
+	for _, conjunct := range conjuncts(conditional.Cond) {
+		if field, value, ok := equalityGuard(conjunct); ok {
+			if existing, exists := guards[field]; exists && existing != value {
+				continue // Contradictory guards, keep the first one
+			}
+			guards[field] = value
+		}
+	}
+	for _, field := range indexedFields {
+		if value, exists := guards[field]; exists {
+			return &ruleFilter{field: field, value: value}
+		}
+	}
+	return nil
+}
+
+// conjuncts flattens a chain of && operators into its operands
+func conjuncts(node ast.Node) []ast.Node {
+	binary, ok := node.(*ast.BinaryNode)
+	if !ok || (binary.Operator != "&&" && binary.Operator != "and") {
+		return []ast.Node{node}
+	}
+	return append(conjuncts(binary.Left), conjuncts(binary.Right)...)
+}
+
+// equalityGuard matches `field == "value"` or `"value" == field` on one of the indexed fields
+func equalityGuard(node ast.Node) (string, string, bool) {
+	binary, ok := node.(*ast.BinaryNode)
+	if !ok || binary.Operator != "==" {
+		return "", "", false
+	}
+	identifier, isIdentifier := binary.Left.(*ast.IdentifierNode)
+	str, isString := binary.Right.(*ast.StringNode)
+	if !isIdentifier || !isString {
+		identifier, isIdentifier = binary.Right.(*ast.IdentifierNode)
+		str, isString = binary.Left.(*ast.StringNode)
+	}
+	if !isIdentifier || !isString || !isIndexedField(identifier.Value) {
+		return "", "", false
+	}
+	return identifier.Value, str.Value, true
+}
+
+func isIndexedField(field string) bool {
+	for _, indexed := range indexedFields {
+		if field == indexed {
+			return true
+		}
+	}
+	return false
+}
+
+func isZeroLiteral(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.IntegerNode:
+		return n.Value == 0
+	case *ast.FloatNode:
+		return n.Value == 0
+	}
+	return false
+}
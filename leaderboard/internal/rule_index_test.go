@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"common"
+	"testing"
+
+	"github.com/expr-lang/expr"
+)
+
+func TestExtractRuleFilter(t *testing.T) {
+	cases := []struct {
+		rule     string
+		expected *ruleFilter
+	}{
+		{`event_type=="bet" && game=="Blackjack" ? amount : 0`, &ruleFilter{"game", "Blackjack"}},
+		{`event_type=='bet' ? amount : 0`, &ruleFilter{"event_type", "bet"}},
+		{`'evo' == distributor and event_type == 'bet' ? amount : 0.0`, &ruleFilter{"distributor", "evo"}},
+		{`currency == "EUR" && amount > 10 ? amount * 2 : 0`, &ruleFilter{"currency", "EUR"}},
+		{`(event_type == "win" && (studio == "S1" && amount > 5)) ? 10 : 0`, &ruleFilter{"studio", "S1"}},
+		// Rules that cannot be indexed
+		{`amount`, nil},
+		{`event_type == "bet" ? amount : 1`, nil},
+		{`event_type == "bet" || game == "Poker" ? amount : 0`, nil},
+		{`event_type != "bet" ? amount : 0`, nil},
+		{`user_id == 42 ? amount : 0`, nil},
+		{`amount > 100 ? amount : 0`, nil},
+	}
+	for _, c := range cases {
+		program, err := expr.Compile(c.rule, expr.Env(BetEventEnv{}))
+		if err != nil {
+			t.Fatalf("failed to compile %q: %v", c.rule, err)
+		}
+		got := extractRuleFilter(program.Node())
+		if (got == nil) != (c.expected == nil) || (got != nil && *got != *c.expected) {
+			t.Errorf("%q: expected filter %+v, got %+v", c.rule, c.expected, got)
+		}
+	}
+}
+
+func TestBetRuleEvaluator_EvaluateRules_OnlyRunsCandidates(t *testing.T) {
+	eval := &BetRuleEvaluator{}
+	eval.AddRule(`event_type == "bet" && game == "Blackjack" ? amount : 0`)
+	eval.AddRule(`event_type == "bet" && game == "Poker" ? amount : 0`)
+	eval.AddRule(`amount > 10 ? 1 : 0`)
+	eval.AddRule(`event_type == "win" ? amount : 0`)
+	eval.AddRule(`event_type == "bet" ? 5 : 0`)
+
+	event := common.BetEvent{EventType: common.EventTypeBet, Game: "Blackjack", Amount: 20}
+	matches, err := eval.EvaluateRules(event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		`event_type == "bet" && game == "Blackjack" ? amount : 0`,
+		`amount > 10 ? 1 : 0`,
+		`event_type == "bet" ? 5 : 0`,
+	}
+	if len(matches) != len(expected) {
+		t.Fatalf("expected %d matches, got %d: %+v", len(expected), len(matches), matches)
+	}
+	for i, match := range matches {
+		if match.Rule != expected[i] {
+			t.Errorf("match %d: expected rule %q, got %q", i, expected[i], match.Rule)
+		}
+	}
+
+	// Removed rules are no longer candidates
+	eval.RemoveRule(`event_type == "bet" && game == "Blackjack" ? amount : 0`)
+	matches, _ = eval.EvaluateRules(event)
+	if len(matches) != 2 {
+		t.Errorf("expected 2 matches after removing a rule, got %d", len(matches))
+	}
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"common"
+	"fmt"
+	"time"
+)
+
+// Competition statuses, relative to the competition's start and end times
+const (
+	CompetitionStatusUpcoming = "upcoming"
+	CompetitionStatusActive   = "active"
+	CompetitionStatusFinished = "finished"
+)
+
+// timeWindow is the period in which a competition accepts events.
+// A zero start or end means the window is open on that side.
+type timeWindow struct {
+	start time.Time
+	end   time.Time
+}
+
+// parseTimeWindow parses the RFC3339 start and end times of a competition.
+// Empty strings leave the corresponding side of the window open.
+func parseTimeWindow(startTime, endTime string) (timeWindow, error) {
+	var window timeWindow
+	var err error
+
+	if startTime != "" {
+		if window.start, err = time.Parse(time.RFC3339, startTime); err != nil {
+			return timeWindow{}, fmt.Errorf("invalid start time %q: %w", startTime, err)
+		}
+	}
+	if endTime != "" {
+		if window.end, err = time.Parse(time.RFC3339, endTime); err != nil {
+			return timeWindow{}, fmt.Errorf("invalid end time %q: %w", endTime, err)
+		}
+	}
+	if !window.start.IsZero() && !window.end.IsZero() && window.end.Before(window.start) {
+		return timeWindow{}, fmt.Errorf("end time %q is before start time %q", endTime, startTime)
+	}
+	return window, nil
+}
+
+// ValidateTimeWindow checks that the start and end times of a competition are valid RFC3339 times
+// and that the competition does not end before it starts
+func ValidateTimeWindow(startTime, endTime string) error {
+	_, err := parseTimeWindow(startTime, endTime)
+	return err
+}
+
+// CompetitionStatus returns whether the competition is upcoming, active or finished at the given time.
+// A competition without a start time has always started, and one without an end time never finishes.
+func CompetitionStatus(comp *common.Competition, now time.Time) (string, error) {
+	window, err := parseTimeWindow(comp.StartTime, comp.EndTime)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !window.start.IsZero() && now.Before(window.start):
+		return CompetitionStatusUpcoming, nil
+	case !window.end.IsZero() && now.After(window.end):
+		return CompetitionStatusFinished, nil
+	default:
+		return CompetitionStatusActive, nil
+	}
+}
+
+// isBounded reports whether the window restricts events in any way
+func (w timeWindow) isBounded() bool {
+	return !w.start.IsZero() || !w.end.IsZero()
+}
+
+// contains reports whether t falls within the window, both ends inclusive
+func (w timeWindow) contains(t time.Time) bool {
+	if !w.start.IsZero() && t.Before(w.start) {
+		return false
+	}
+	if !w.end.IsZero() && t.After(w.end) {
+		return false
+	}
+	return true
+}
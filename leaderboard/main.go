@@ -16,31 +16,72 @@ import (
 
 func main() {
 	// Initialize SQLiteScoreRepository
-	leaderboardsRepo, competitionsRepo, err := initialiseRepositories()
+	leaderboardsRepo, competitionsRepo, resultsRepo, jobsRepo, recalculationsRepo, err := initialiseRepositories()
 	if err != nil {
 		fmt.Printf("Error initializing repositories: %v\n", err)
 		return
 	}
 	defer leaderboardsRepo.Close()
 	defer competitionsRepo.Close()
+	defer resultsRepo.Close()
+	defer jobsRepo.Close()
+	defer recalculationsRepo.Close()
 
 	defaultRuleEvaluator := &internal.BetRuleEvaluator{}
 	leaderboard := internal.NewLeaderboard(defaultRuleEvaluator)
 
 	// Load existing data from DB
-	if err := loadLeaderBoardDataFromDB(leaderboard, leaderboardsRepo, competitionsRepo); err != nil {
+	backfillJobs, err := jobsRepo.GetUnfinished(common.JobTypeBackfill)
+	if err != nil {
+		fmt.Printf("Error retrieving unfinished backfill jobs: %v\n", err)
+		return
+	}
+	recalculationJobs, err := jobsRepo.GetUnfinished(common.JobTypeRecalculate)
+	if err != nil {
+		fmt.Printf("Error retrieving unfinished recalculation jobs: %v\n", err)
+		return
+	}
+	if err := loadLeaderBoardDataFromDB(leaderboard, leaderboardsRepo, competitionsRepo, resultsRepo, backfillJobs); err != nil {
 		fmt.Printf("Error loading leaderboard data from DB: %v\n", err)
 		return
 	}
 
+	leaderboardUpdates := handlers.NewLeaderboardUpdates(leaderboardsRepo)
+	websocketHandler := handlers.NewWebsocketHandler(leaderboardUpdates, competitionsRepo)
+	streamHandler := handlers.NewStreamHandler(leaderboardUpdates, competitionsRepo)
+	publishers := handlers.Publishers{websocketHandler, streamHandler}
+	eventHandler := handlers.NewBetEventHandler(leaderboardsRepo, leaderboard, leaderboardUpdates, publishers)
+
+	backfiller := internal.NewBackfiller(jobsRepo, competitionsRepo, leaderboardsRepo, leaderboard, eventHandler)
+	backfiller.Resume(backfillJobs)
+	recalculator := internal.NewRecalculator(jobsRepo, competitionsRepo, leaderboardsRepo, recalculationsRepo, leaderboard, eventHandler)
+	recalculator.Resume(recalculationJobs)
+
 	///////// HTTP server setup /////////
 	leaderboardsHandler := handlers.NewLeaderboardsHandler(leaderboardsRepo)
-	competitionsHandler := handlers.NewCompetitionsHandler(competitionsRepo, leaderboard)
-	websocketHandler := handlers.NewWebsocketHandler()
+	competitionsHandler := handlers.NewCompetitionsHandler(competitionsRepo, leaderboardsRepo, leaderboard, backfiller)
+	rulesHandler := handlers.NewRulesHandler(leaderboardsRepo, leaderboard)
+	jobsHandler := handlers.NewJobsHandler(jobsRepo)
+	recalculationsHandler := handlers.NewRecalculationsHandler(competitionsRepo, recalculator)
 
 	r := mux.NewRouter()
 	r.Handle("/leaderboards/{id}", http.HandlerFunc(leaderboardsHandler.GetLeaderboardByID)).Methods("GET")
+	r.Handle("/leaderboards/{id}/users/{userId}", http.HandlerFunc(leaderboardsHandler.GetUserStanding)).Methods("GET")
+	r.Handle("/leaderboards/{id}/stream", http.HandlerFunc(streamHandler.StreamLeaderboard)).Methods("GET")
 	r.Handle("/competitions", authMiddleware(http.HandlerFunc(competitionsHandler.CreateCompetition))).Methods("POST")
+	r.Handle("/competitions", http.HandlerFunc(competitionsHandler.GetCompetitions)).Methods("GET")
+	r.Handle("/competitions/{id}", http.HandlerFunc(competitionsHandler.GetCompetitionByID)).Methods("GET")
+	r.Handle("/competitions/{id}", authMiddleware(http.HandlerFunc(competitionsHandler.UpdateCompetition))).Methods("PATCH")
+	r.Handle("/competitions/{id}", authMiddleware(http.HandlerFunc(competitionsHandler.DeleteCompetition))).Methods("DELETE")
+	r.Handle("/competitions/{id}/enable", authMiddleware(http.HandlerFunc(competitionsHandler.EnableCompetition))).Methods("POST")
+	r.Handle("/competitions/{id}/rewards", http.HandlerFunc(competitionsHandler.GetCompetitionRewards)).Methods("GET")
+	r.Handle("/competitions/{id}/recalculate", authMiddleware(http.HandlerFunc(recalculationsHandler.RecalculateCompetition))).Methods("POST")
+	r.Handle("/competitions/{id}/recalculation", http.HandlerFunc(recalculationsHandler.GetRecalculation)).Methods("GET")
+	r.Handle("/competitions/{id}/recalculation", authMiddleware(http.HandlerFunc(recalculationsHandler.DiscardRecalculation))).Methods("DELETE")
+	r.Handle("/competitions/{id}/recalculation/confirm", authMiddleware(http.HandlerFunc(recalculationsHandler.ConfirmRecalculation))).Methods("POST")
+	r.Handle("/rules/test", authMiddleware(http.HandlerFunc(rulesHandler.TestRule))).Methods("POST")
+	r.Handle("/rules/errors", http.HandlerFunc(rulesHandler.GetRuleErrors)).Methods("GET")
+	r.Handle("/jobs/{id}", http.HandlerFunc(jobsHandler.GetJobByID)).Methods("GET")
 	r.HandleFunc("/ws", http.HandlerFunc(websocketHandler.WebsocketHandler))
 
 	go func() {
@@ -50,13 +91,11 @@ func main() {
 	}()
 
 	///////// RabbitMQ setup /////////
-	eventHandler := handlers.NewBetEventHandler(leaderboardsRepo, leaderboard, websocketHandler)
+	rabbitPort := os.Getenv("RABBITMQ_PORT")
+	rabbitHost := os.Getenv("RABBITMQ_HOST")
+	rabbitURL := fmt.Sprintf("amqp://guest:guest@%s:%s/", rabbitHost, rabbitPort)
 
 	go func() {
-		rabbitPort := os.Getenv("RABBITMQ_PORT")
-		rabbitHost := os.Getenv("RABBITMQ_HOST")
-
-		rabbitURL := fmt.Sprintf("amqp://guest:guest@%s:%s/", rabbitHost, rabbitPort)
 		betQueue := "bet_events"
 
 		var betReceiver *internal.RabbitMQReceiver
@@ -84,6 +123,13 @@ func main() {
 		}
 	}()
 
+	///////// Competition finalization /////////
+	// Competitions are finalized while RabbitMQ is down, their results are published once it is reachable
+	resultsSender := internal.NewReconnectingRabbitMQSender(rabbitURL, "competition_results")
+	defer resultsSender.Close()
+	finalizer := internal.NewCompetitionFinalizer(competitionsRepo, leaderboardsRepo, resultsRepo, leaderboard, eventHandler, publishers, resultsSender)
+	go finalizer.Run(time.Minute, nil)
+
 	// Block forever so main does not exit while goroutines are running
 	select {}
 }
@@ -100,34 +146,80 @@ func authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func initialiseRepositories() (*repositories.SQLiteLeaderboards, *repositories.SQLiteCompetitions, error) {
+func initialiseRepositories() (*repositories.SQLiteLeaderboards, *repositories.SQLiteCompetitions, *repositories.SQLiteResults, *repositories.SQLiteJobs, *repositories.SQLiteRecalculations, error) {
 	dbPath := "db/leaderboard.db"
+	if err := repositories.MigrateDatabase(dbPath); err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("error migrating database: %v", err)
+	}
+
 	leaderboardsRepo, err := repositories.NewSQLiteLeaderboardsRepository(dbPath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error initializing SQLiteLeaderboardsRepository: %v", err)
+		return nil, nil, nil, nil, nil, fmt.Errorf("error initializing SQLiteLeaderboardsRepository: %v", err)
 	}
 
 	competitionsRepo, err := repositories.NewSQLiteCompetitionsRepository(dbPath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error initializing SQLiteCompetitionsRepository: %v", err)
+		return nil, nil, nil, nil, nil, fmt.Errorf("error initializing SQLiteCompetitionsRepository: %v", err)
+	}
+
+	resultsRepo, err := repositories.NewSQLiteResultsRepository(dbPath)
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("error initializing SQLiteResultsRepository: %v", err)
+	}
+
+	jobsRepo, err := repositories.NewSQLiteJobsRepository(dbPath)
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("error initializing SQLiteJobsRepository: %v", err)
+	}
+
+	recalculationsRepo, err := repositories.NewSQLiteRecalculationsRepository(dbPath)
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("error initializing SQLiteRecalculationsRepository: %v", err)
 	}
-	return leaderboardsRepo, competitionsRepo, nil
+	return leaderboardsRepo, competitionsRepo, resultsRepo, jobsRepo, recalculationsRepo, nil
 }
 
-func loadLeaderBoardDataFromDB(lb *internal.Leaderboard, leaderboardsRepo *repositories.SQLiteLeaderboards, competitionsRepo *repositories.SQLiteCompetitions) error {
+func loadLeaderBoardDataFromDB(lb *internal.Leaderboard, leaderboardsRepo *repositories.SQLiteLeaderboards, competitionsRepo *repositories.SQLiteCompetitions, resultsRepo *repositories.SQLiteResults, backfillJobs []*common.Job) error {
 	lbFromDB, err := leaderboardsRepo.GetAll()
 	if err != nil {
 		return fmt.Errorf("error retrieving leaderboards: %v", err)
 	}
 	lb.Load(lbFromDB)
+	states, err := leaderboardsRepo.GetAggregationStates()
+	if err != nil {
+		return fmt.Errorf("error retrieving aggregation states: %v", err)
+	}
+	lb.LoadAggregationStates(states)
+	activities, err := leaderboardsRepo.GetActivities()
+	if err != nil {
+		return fmt.Errorf("error retrieving user activities: %v", err)
+	}
+	lb.LoadActivities(activities)
+	rankingKeys, err := leaderboardsRepo.GetRankingKeys()
+	if err != nil {
+		return fmt.Errorf("error retrieving ranking keys: %v", err)
+	}
+	lb.LoadRankingKeys(rankingKeys)
 
 	competitions, err := loadCompetitions(competitionsRepo)
 	if err != nil {
 		return fmt.Errorf("error retrieving competitions: %v", err)
 	}
+	// Competitions whose backfill has not completed are registered when it completes
+	for _, job := range backfillJobs {
+		lb.StartBackfill(job.CompetitionID)
+	}
 	for _, comp := range competitions {
 		lb.RegisterCompetition(comp)
 	}
+
+	finished, err := resultsRepo.GetFinished()
+	if err != nil {
+		return fmt.Errorf("error retrieving finished competitions: %v", err)
+	}
+	for _, f := range finished {
+		lb.FinishCompetition(f.CompetitionID)
+	}
 	return nil
 }
 
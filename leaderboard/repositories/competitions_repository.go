@@ -3,6 +3,7 @@ package repositories
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 
 	_ "github.com/mattn/go-sqlite3"
 
@@ -14,9 +15,16 @@ import (
 type CompetitionsRepository interface {
 	Create(competition *common.Competition) (uint, error)
 	GetAll() ([]*common.Competition, error)
+	GetByID(id uint) (*common.Competition, error)
+	Update(competition *common.Competition) error
+	EnableRule(id uint) error
+	Delete(id uint) error
 	Close()
 }
 
+// ErrCompetitionNotFound is returned when a competition with the requested ID does not exist
+var ErrCompetitionNotFound = errors.New("competition not found")
+
 // SQLiteCompetitions implements CompetitionsRepository using SQLite
 type SQLiteCompetitions struct {
 	db *sql.DB
@@ -38,8 +46,9 @@ func (r *SQLiteCompetitions) Create(competition *common.Competition) (uint, erro
 	if err != nil {
 		return 0, err
 	}
-	res, err := r.db.Exec(`INSERT INTO Competitions (name, scorerule, starttime, endtime, rewards) VALUES (?, ?, ?, ?, ?)`,
-		competition.Name, competition.ScoreRule, competition.StartTime, competition.EndTime, string(rewardsJSON))
+	res, err := r.db.Exec(`INSERT INTO Competitions (name, scorerule, starttime, endtime, rewards, rankingmode, tiebreaker, aggregation, bestn, skiplosses) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		competition.Name, competition.ScoreRule, competition.StartTime, competition.EndTime, string(rewardsJSON), competition.RankingMode, competition.TieBreaker,
+		competition.Aggregation, competition.BestN, competition.SkipLosses)
 	if err != nil {
 		return 0, err
 	}
@@ -52,7 +61,7 @@ func (r *SQLiteCompetitions) Create(competition *common.Competition) (uint, erro
 
 // GetAll retrieves all competitions, including all fields and deserializes Rewards
 func (r *SQLiteCompetitions) GetAll() ([]*common.Competition, error) {
-	rows, err := r.db.Query(`SELECT id, name, scorerule, starttime, endtime, rewards FROM Competitions`)
+	rows, err := r.db.Query(`SELECT id, name, scorerule, starttime, endtime, rewards, rankingmode, tiebreaker, aggregation, bestn, skiplosses, rule_disabled FROM Competitions`)
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +70,7 @@ func (r *SQLiteCompetitions) GetAll() ([]*common.Competition, error) {
 	for rows.Next() {
 		var c common.Competition
 		var rewardsJSON string
-		if err := rows.Scan(&c.ID, &c.Name, &c.ScoreRule, &c.StartTime, &c.EndTime, &rewardsJSON); err != nil {
+		if err := rows.Scan(&c.ID, &c.Name, &c.ScoreRule, &c.StartTime, &c.EndTime, &rewardsJSON, &c.RankingMode, &c.TieBreaker, &c.Aggregation, &c.BestN, &c.SkipLosses, &c.RuleDisabled); err != nil {
 			return nil, err
 		}
 		if rewardsJSON != "" {
@@ -77,6 +86,98 @@ func (r *SQLiteCompetitions) GetAll() ([]*common.Competition, error) {
 	return competitions, nil
 }
 
+// GetByID retrieves a single competition, returning ErrCompetitionNotFound if it does not exist
+func (r *SQLiteCompetitions) GetByID(id uint) (*common.Competition, error) {
+	var c common.Competition
+	var rewardsJSON string
+	err := r.db.QueryRow(`SELECT id, name, scorerule, starttime, endtime, rewards, rankingmode, tiebreaker, aggregation, bestn, skiplosses, rule_disabled FROM Competitions WHERE id = ?`, id).
+		Scan(&c.ID, &c.Name, &c.ScoreRule, &c.StartTime, &c.EndTime, &rewardsJSON, &c.RankingMode, &c.TieBreaker, &c.Aggregation, &c.BestN, &c.SkipLosses, &c.RuleDisabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCompetitionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rewardsJSON != "" {
+		if err := json.Unmarshal([]byte(rewardsJSON), &c.Rewards); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// Update replaces the stored fields of an existing competition,
+// returning ErrCompetitionNotFound if it does not exist
+func (r *SQLiteCompetitions) Update(competition *common.Competition) error {
+	rewardsJSON, err := json.Marshal(competition.Rewards)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.Exec(`UPDATE Competitions SET name = ?, scorerule = ?, starttime = ?, endtime = ?, rewards = ?, rankingmode = ?, tiebreaker = ?, aggregation = ?, bestn = ?, skiplosses = ? WHERE id = ?`,
+		competition.Name, competition.ScoreRule, competition.StartTime, competition.EndTime, string(rewardsJSON), competition.RankingMode, competition.TieBreaker,
+		competition.Aggregation, competition.BestN, competition.SkipLosses, competition.ID)
+	if err != nil {
+		return err
+	}
+	return checkCompetitionAffected(res)
+}
+
+// EnableRule clears the disabled flag set on a competition whose score rule kept failing,
+// returning ErrCompetitionNotFound if it does not exist
+func (r *SQLiteCompetitions) EnableRule(id uint) error {
+	res, err := r.db.Exec(`UPDATE Competitions SET rule_disabled = 0 WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return checkCompetitionAffected(res)
+}
+
+// Delete removes a competition along with its leaderboard scores, user histories, recalculation and final results,
+// returning ErrCompetitionNotFound if it does not exist
+func (r *SQLiteCompetitions) Delete(id uint) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM Competitions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if err := checkCompetitionAffected(res); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM Leaderboards WHERE competition_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM UserActivity WHERE competition_id = ?`, id); err != nil {
+		return err
+	}
+	if err := deleteRecalculation(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM FinishedCompetitions WHERE competition_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM CompetitionResults WHERE competition_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkCompetitionAffected returns ErrCompetitionNotFound if the statement did not affect any competition
+func checkCompetitionAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrCompetitionNotFound
+	}
+	return nil
+}
+
 // Close closes the SQLite database connection
 func (r *SQLiteCompetitions) Close() {
 	if r.db != nil {
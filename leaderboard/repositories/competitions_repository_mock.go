@@ -7,6 +7,13 @@ type MockCompetitions struct {
 	LastCreated *common.Competition
 	LastID      uint
 	CreateErr   error
+
+	Competitions map[uint]*common.Competition
+	GetByIDErr   error
+	UpdateErr    error
+	DeleteErr    error
+	LastUpdated  *common.Competition
+	LastDeleted  uint
 }
 
 // Create inserts a new competition and returns the ID
@@ -16,8 +23,64 @@ func (m *MockCompetitions) Create(c *common.Competition) (uint, error) {
 	return m.LastID, m.CreateErr
 }
 
-// GetAll retrieves all competitions, returning an empty slice and nil error
-func (m *MockCompetitions) GetAll() ([]*common.Competition, error) { return nil, nil }
+// GetAll retrieves all competitions stored in the Competitions map
+func (m *MockCompetitions) GetAll() ([]*common.Competition, error) {
+	var competitions []*common.Competition
+	for _, c := range m.Competitions {
+		competitions = append(competitions, c)
+	}
+	return competitions, nil
+}
+
+// GetByID returns the competition stored in the Competitions map, or ErrCompetitionNotFound
+func (m *MockCompetitions) GetByID(id uint) (*common.Competition, error) {
+	if m.GetByIDErr != nil {
+		return nil, m.GetByIDErr
+	}
+	if c, ok := m.Competitions[id]; ok {
+		return c, nil
+	}
+	return nil, ErrCompetitionNotFound
+}
+
+// Update stores the competition in the Competitions map, or returns ErrCompetitionNotFound
+func (m *MockCompetitions) Update(c *common.Competition) error {
+	if m.UpdateErr != nil {
+		return m.UpdateErr
+	}
+	if _, ok := m.Competitions[c.ID]; !ok {
+		return ErrCompetitionNotFound
+	}
+	m.LastUpdated = c
+	m.Competitions[c.ID] = c
+	return nil
+}
+
+// EnableRule clears the RuleDisabled flag of the competition in the Competitions map, or returns ErrCompetitionNotFound
+func (m *MockCompetitions) EnableRule(id uint) error {
+	if m.UpdateErr != nil {
+		return m.UpdateErr
+	}
+	c, ok := m.Competitions[id]
+	if !ok {
+		return ErrCompetitionNotFound
+	}
+	c.RuleDisabled = false
+	return nil
+}
+
+// Delete removes the competition from the Competitions map, or returns ErrCompetitionNotFound
+func (m *MockCompetitions) Delete(id uint) error {
+	if m.DeleteErr != nil {
+		return m.DeleteErr
+	}
+	if _, ok := m.Competitions[id]; !ok {
+		return ErrCompetitionNotFound
+	}
+	m.LastDeleted = id
+	delete(m.Competitions, id)
+	return nil
+}
 
 // Close is a no-op for the mock implementation
 func (m *MockCompetitions) Close() {}
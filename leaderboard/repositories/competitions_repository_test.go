@@ -2,31 +2,19 @@ package repositories
 
 import (
 	"common"
-	"fmt"
+	"database/sql"
 	"os"
-	"os/exec"
 	"testing"
 )
 
-// runInitDBScript runs the leaderboard/init_db.sh script for a given db path
-func runInitDBScript(dbPath string) error {
-	script := "../init_db.sh"
-	cmd := exec.Command("bash", script, dbPath, "noTestData")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("init_db.sh failed: %v, output: %s", err, string(output))
-	}
-	return nil
-}
-
 func TestSQLiteCompetitionsRepository(t *testing.T) {
 	dbPath := "test_competitions.db"
 	os.Remove(dbPath)
 
-	// Call the init_db.sh script to create the schema for the test DB
-	err := runInitDBScript(dbPath)
+	// Migrate the test DB to create its schema
+	err := MigrateDatabase(dbPath)
 	if err != nil {
-		t.Fatalf("failed to run init_db.sh: %v", err)
+		t.Fatalf("failed to migrate test DB: %v", err)
 	}
 
 	repo, err := NewSQLiteCompetitionsRepository(dbPath)
@@ -40,11 +28,13 @@ func TestSQLiteCompetitionsRepository(t *testing.T) {
 
 	rewards := map[string]int{"1": 100, "2": 50}
 	comp := &common.Competition{
-		Name:      "SQLite Competition",
-		ScoreRule: "event_type=='bet' ? amount : 0",
-		StartTime: "2025-07-10T00:00:00Z",
-		EndTime:   "2025-07-11T00:00:00Z",
-		Rewards:   rewards,
+		Name:        "SQLite Competition",
+		ScoreRule:   "event_type=='bet' ? amount : 0",
+		StartTime:   "2025-07-10T00:00:00Z",
+		EndTime:     "2025-07-11T00:00:00Z",
+		Rewards:     rewards,
+		RankingMode: common.RankingDense,
+		TieBreaker:  common.TieBreakerEarliest,
 	}
 
 	// Create a competition
@@ -76,4 +66,126 @@ func TestSQLiteCompetitionsRepository(t *testing.T) {
 			t.Errorf("rewards value mismatch for key %s: got %d, want %d", k, got.Rewards[k], v)
 		}
 	}
+
+	// Get the competition by ID
+	byID, err := repo.GetByID(id)
+	if err != nil {
+		t.Fatalf("failed to get competition by id: %v", err)
+	}
+	if byID.ID != id || byID.Name != comp.Name || byID.Rewards["1"] != 100 || byID.RankingMode != common.RankingDense || byID.TieBreaker != common.TieBreakerEarliest {
+		t.Errorf("competition by id mismatch: got %+v", byID)
+	}
+
+	// Get a competition that does not exist
+	if _, err := repo.GetByID(id + 100); err != ErrCompetitionNotFound {
+		t.Errorf("expected ErrCompetitionNotFound, got %v", err)
+	}
+
+	// Update the competition
+	byID.Name = "Renamed Competition"
+	byID.EndTime = "2025-07-12T00:00:00Z"
+	if err := repo.Update(byID); err != nil {
+		t.Fatalf("failed to update competition: %v", err)
+	}
+	updated, err := repo.GetByID(id)
+	if err != nil {
+		t.Fatalf("failed to get updated competition: %v", err)
+	}
+	if updated.Name != "Renamed Competition" || updated.EndTime != "2025-07-12T00:00:00Z" {
+		t.Errorf("competition was not updated: %+v", updated)
+	}
+	if err := repo.Update(&common.Competition{ID: id + 100, Name: "Missing"}); err != ErrCompetitionNotFound {
+		t.Errorf("expected ErrCompetitionNotFound when updating a missing competition, got %v", err)
+	}
+
+	// Enable a competition whose rule has been disabled
+	if _, err := repo.db.Exec(`UPDATE Competitions SET rule_disabled = 1 WHERE id = ?`, id); err != nil {
+		t.Fatalf("failed to disable competition: %v", err)
+	}
+	if disabled, err := repo.GetByID(id); err != nil || !disabled.RuleDisabled {
+		t.Errorf("expected the competition to be disabled, got %+v, %v", disabled, err)
+	}
+	if err := repo.EnableRule(id); err != nil {
+		t.Fatalf("failed to enable competition: %v", err)
+	}
+	if enabled, err := repo.GetByID(id); err != nil || enabled.RuleDisabled {
+		t.Errorf("expected the competition to be enabled, got %+v, %v", enabled, err)
+	}
+	if err := repo.EnableRule(id + 100); err != ErrCompetitionNotFound {
+		t.Errorf("expected ErrCompetitionNotFound when enabling a missing competition, got %v", err)
+	}
+
+	// Delete the competition along with its final results
+	resultsRepo, err := NewSQLiteResultsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create SQLiteResultsRepository: %v", err)
+	}
+	defer resultsRepo.Close()
+	if err := resultsRepo.SaveResults(id, "2024-06-30T00:00:00Z", []*common.CompetitionResult{{CompetitionID: id, UserID: 7, Rank: 1}}); err != nil {
+		t.Fatalf("failed to save results: %v", err)
+	}
+	if err := repo.Delete(id); err != nil {
+		t.Fatalf("failed to delete competition: %v", err)
+	}
+	if finished, _ := resultsRepo.GetFinished(); len(finished) != 0 {
+		t.Errorf("expected the finished competition to be deleted, got %+v", finished)
+	}
+	if results, _ := resultsRepo.GetResults(id); len(results) != 0 {
+		t.Errorf("expected the results to be deleted, got %+v", results)
+	}
+	if _, err := repo.GetByID(id); err != ErrCompetitionNotFound {
+		t.Errorf("expected deleted competition to be gone, got %v", err)
+	}
+	if err := repo.Delete(id); err != ErrCompetitionNotFound {
+		t.Errorf("expected ErrCompetitionNotFound when deleting twice, got %v", err)
+	}
+}
+
+func TestSQLiteCompetitionsRepository_LossEventsMigration(t *testing.T) {
+	dbPath := "test_competitions_migration.db"
+	os.Remove(dbPath)
+
+	// A competition stored before loss events were passed to the rules
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE Competitions (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE, scorerule TEXT, starttime TEXT, endtime TEXT, rewards TEXT);
+		INSERT INTO Competitions (name, scorerule, starttime, endtime, rewards) VALUES ('Legacy', 'amount', '', '', '{}');`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to create the legacy schema: %v", err)
+	}
+
+	if err := MigrateDatabase(dbPath); err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+	repo, err := NewSQLiteCompetitionsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create repo: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+
+	legacy, err := repo.GetByID(1)
+	if err != nil {
+		t.Fatalf("failed to get the legacy competition: %v", err)
+	}
+	if !legacy.SkipLosses {
+		t.Errorf("expected the legacy competition to keep skipping loss events")
+	}
+
+	id, err := repo.Create(&common.Competition{Name: "Net Result", ScoreRule: "amount"})
+	if err != nil {
+		t.Fatalf("failed to create competition: %v", err)
+	}
+	created, err := repo.GetByID(id)
+	if err != nil {
+		t.Fatalf("failed to get the new competition: %v", err)
+	}
+	if created.SkipLosses {
+		t.Errorf("expected a new competition to pass loss events to its rule")
+	}
 }
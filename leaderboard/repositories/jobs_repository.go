@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"common"
+)
+
+// JobsRepository defines the interface for storing the background jobs run on competitions
+// This allows for different implementations (e.g., in-memory, database, etc.)
+type JobsRepository interface {
+	Create(job *common.Job) (uint, error)
+	Update(job *common.Job) error
+	GetByID(id uint) (*common.Job, error)
+	GetUnfinished(jobType common.JobType) ([]*common.Job, error)
+	Close()
+}
+
+// ErrJobNotFound is returned when a job with the requested ID does not exist
+var ErrJobNotFound = errors.New("job not found")
+
+// SQLiteJobs implements JobsRepository using SQLite
+type SQLiteJobs struct {
+	db *sql.DB
+}
+
+// NewSQLiteJobsRepository opens (or creates) a SQLite DB
+func NewSQLiteJobsRepository(dbPath string) (*SQLiteJobs, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteJobs{db: db}, nil
+}
+
+// Create inserts a new job and returns the ID
+func (r *SQLiteJobs) Create(job *common.Job) (uint, error) {
+	res, err := r.db.Exec(`INSERT INTO Jobs (type, competition_id, status, total_events, processed_events, error, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.Type, job.CompetitionID, job.Status, job.TotalEvents, job.ProcessedEvents, job.Error, job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+// Update stores the status and progress of a job, returning ErrJobNotFound if it does not exist
+func (r *SQLiteJobs) Update(job *common.Job) error {
+	res, err := r.db.Exec(`UPDATE Jobs SET status = ?, total_events = ?, processed_events = ?, error = ?, updated_at = ? WHERE id = ?`,
+		job.Status, job.TotalEvents, job.ProcessedEvents, job.Error, job.UpdatedAt, job.ID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// GetByID retrieves a job, returning ErrJobNotFound if it does not exist
+func (r *SQLiteJobs) GetByID(id uint) (*common.Job, error) {
+	rows, err := r.db.Query(`SELECT id, type, competition_id, status, total_events, processed_events, error, created_at, updated_at FROM Jobs WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, ErrJobNotFound
+	}
+	return jobs[0], nil
+}
+
+// GetUnfinished retrieves the jobs of a type that are pending or running, in the order they were created.
+// They are left unfinished when the service stops while they run.
+func (r *SQLiteJobs) GetUnfinished(jobType common.JobType) ([]*common.Job, error) {
+	rows, err := r.db.Query(`SELECT id, type, competition_id, status, total_events, processed_events, error, created_at, updated_at FROM Jobs WHERE type = ? AND status IN (?, ?) ORDER BY id`,
+		jobType, common.JobStatusPending, common.JobStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+// scanJobs reads and closes rows of jobs
+func scanJobs(rows *sql.Rows) ([]*common.Job, error) {
+	defer rows.Close()
+	var jobs []*common.Job
+	for rows.Next() {
+		job := &common.Job{}
+		if err := rows.Scan(&job.ID, &job.Type, &job.CompetitionID, &job.Status, &job.TotalEvents, &job.ProcessedEvents, &job.Error, &job.CreatedAt, &job.UpdatedAt); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Close closes the SQLite database connection
+func (r *SQLiteJobs) Close() {
+	if r.db != nil {
+		r.db.Close()
+	}
+}
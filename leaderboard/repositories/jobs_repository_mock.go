@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"common"
+	"sync"
+)
+
+// MockJobs is a mock implementation of JobsRepository for testing.
+// It is safe for concurrent use, as jobs are updated while they run in the background.
+type MockJobs struct {
+	mutex  sync.Mutex
+	Jobs   map[uint]*common.Job
+	LastID uint
+
+	CreateErr error
+	UpdateErr error
+}
+
+// Create stores a copy of the job in the Jobs map and returns its ID
+func (m *MockJobs) Create(job *common.Job) (uint, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.CreateErr != nil {
+		return 0, m.CreateErr
+	}
+	if m.Jobs == nil {
+		m.Jobs = make(map[uint]*common.Job)
+	}
+	m.LastID++
+	stored := *job
+	stored.ID = m.LastID
+	m.Jobs[stored.ID] = &stored
+	return stored.ID, nil
+}
+
+// Update stores a copy of the job in the Jobs map, or returns ErrJobNotFound
+func (m *MockJobs) Update(job *common.Job) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.UpdateErr != nil {
+		return m.UpdateErr
+	}
+	if _, ok := m.Jobs[job.ID]; !ok {
+		return ErrJobNotFound
+	}
+	stored := *job
+	m.Jobs[job.ID] = &stored
+	return nil
+}
+
+// GetByID returns a copy of the job stored in the Jobs map, or ErrJobNotFound
+func (m *MockJobs) GetByID(id uint) (*common.Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, ok := m.Jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	stored := *job
+	return &stored, nil
+}
+
+// GetUnfinished returns copies of the pending and running jobs of a type, ordered by ID
+func (m *MockJobs) GetUnfinished(jobType common.JobType) ([]*common.Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var jobs []*common.Job
+	for id := uint(1); id <= m.LastID; id++ {
+		job, ok := m.Jobs[id]
+		if !ok || job.Type != jobType || (job.Status != common.JobStatusPending && job.Status != common.JobStatusRunning) {
+			continue
+		}
+		stored := *job
+		jobs = append(jobs, &stored)
+	}
+	return jobs, nil
+}
+
+// Close is a no-op for the mock implementation
+func (m *MockJobs) Close() {}
@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"common"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSQLiteJobsRepository(t *testing.T) {
+	dbPath := "test_jobs.db"
+	os.Remove(dbPath)
+
+	// Migrate the test DB to create its schema
+	err := MigrateDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+
+	repo, err := NewSQLiteJobsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create repo: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+
+	job := &common.Job{
+		Type:          common.JobTypeBackfill,
+		CompetitionID: 3,
+		Status:        common.JobStatusPending,
+		CreatedAt:     "2024-06-01T00:00:00Z",
+		UpdatedAt:     "2024-06-01T00:00:00Z",
+	}
+	id, err := repo.Create(job)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	job.ID = id
+	finished := &common.Job{Type: common.JobTypeBackfill, CompetitionID: 4, Status: common.JobStatusCompleted}
+	if _, err := repo.Create(finished); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	job.Status = common.JobStatusRunning
+	job.TotalEvents = 2000
+	job.ProcessedEvents = 1000
+	job.Error = "database is locked"
+	job.UpdatedAt = "2024-06-01T00:01:00Z"
+	if err := repo.Update(job); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	stored, err := repo.GetByID(id)
+	if err != nil {
+		t.Fatalf("GetByID failed: %v", err)
+	}
+	if *stored != *job {
+		t.Errorf("expected %+v, got %+v", *job, *stored)
+	}
+
+	unfinished, err := repo.GetUnfinished(common.JobTypeBackfill)
+	if err != nil {
+		t.Fatalf("GetUnfinished failed: %v", err)
+	}
+	if len(unfinished) != 1 || unfinished[0].ID != id {
+		t.Errorf("expected only job %d to be unfinished, got %+v", id, unfinished)
+	}
+
+	if _, err := repo.GetByID(id + 10); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected ErrJobNotFound, got %v", err)
+	}
+	if err := repo.Update(&common.Job{ID: id + 10}); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected ErrJobNotFound on update, got %v", err)
+	}
+}
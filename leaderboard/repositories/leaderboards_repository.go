@@ -2,6 +2,11 @@ package repositories
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
@@ -11,11 +16,62 @@ import (
 // LeaderboardsRepository defines the interface for updating user scores per competition
 // This allows for different implementations (e.g., in-memory, database, etc.)
 type LeaderboardsRepository interface {
-	Update(competitionID, userID uint, score float64) error
+	Update(competitionID, userID uint, score float64, reachedAt string, state *common.AggregationState) error
 	GetAll() (map[uint][]common.User, error)
+	GetAggregationStates() (map[uint]map[uint]*common.AggregationState, error)
+	GetRankingKeys() (map[uint]map[uint]*LeaderboardCursor, error)
+	UpdateActivity(competitionID, userID uint, activity *common.UserActivity) error
+	GetActivities() (map[uint]map[uint]*common.UserActivity, error)
 	GetTopN(competitionID uint, n int) ([]*common.User, error)
+	GetUserStanding(competitionID, userID uint, window int) (*common.UserStanding, error)
+	GetPage(competitionID uint, offset, limit int, after *LeaderboardCursor) (*LeaderboardPage, error)
 	HasBetEvent(eventID uint) (bool, error)
 	StoreBetEvent(event *common.BetEvent) error
+	GetBetEvents(eventIDs []uint) ([]common.BetEvent, error)
+	ScanBetEvents(filter BetEventFilter, visit func(event *common.BetEvent) error) error
+	CountBetEvents(filter BetEventFilter) (int, error)
+	LastReceivedAt() (time.Time, error)
+	ReplaceScores(competitionID uint, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error
+	Begin() (LeaderboardsUnitOfWork, error)
+}
+
+// ErrUserNotRanked is returned when a user has no score in the requested competition
+var ErrUserNotRanked = errors.New("user not ranked in competition")
+
+// BetEventFilter selects stored bet events by the time they happened and the time they were received.
+// Zero times leave their side open.
+type BetEventFilter struct {
+	From          time.Time // Events that happened at or after From
+	To            time.Time // Events that happened at or before To
+	ReceivedAfter time.Time // Events received after ReceivedAfter
+	ReceivedUntil time.Time // Events received at or before ReceivedUntil
+}
+
+// CompetitionScore is the score of a user in a competition with what it is ranked and aggregated from
+type CompetitionScore struct {
+	UserID    uint
+	Score     float64
+	ReachedAt string // Time of the event that brought the user to Score
+	Events    int    // Number of events that changed the score
+	State     common.AggregationState
+}
+
+// LeaderboardCursor is a position in the ranking of a competition. Users are ordered by greatest score, then by
+// the competition's tie-breaker: earliest time the score was reached or fewest scored events, then by user ID.
+type LeaderboardCursor struct {
+	Score     float64
+	ReachedAt string
+	Events    int
+	UserID    uint
+}
+
+// LeaderboardPage is a page of the ranking of a competition.
+// More is true if there are users ranked after the last one of the page, Next is then the position of that user.
+type LeaderboardPage struct {
+	Users []*common.RankedUser
+	Total int
+	More  bool
+	Next  *LeaderboardCursor
 }
 
 // SQLiteLeaderboards implements LeaderboardsRepository using a SQLite database
@@ -57,12 +113,66 @@ func (sr *SQLiteLeaderboards) GetAll() (map[uint][]common.User, error) {
 	return competitionsResults, nil
 }
 
-// Update inserts or updates the score for a user in a competition
-func (sr *SQLiteLeaderboards) Update(competitionID, userID uint, score float64) error {
-	_, err := sr.db.Exec(
-		`INSERT INTO Leaderboards (competition_id, user_id, score) VALUES (?, ?, ?)
-		ON CONFLICT(competition_id, user_id) DO UPDATE SET score=excluded.score;`,
-		competitionID, userID, score,
+// GetAggregationStates retrieves the aggregation states of the users of all competitions.
+// Users whose score was stored without a state are omitted.
+func (sr *SQLiteLeaderboards) GetAggregationStates() (map[uint]map[uint]*common.AggregationState, error) {
+	rows, err := sr.db.Query(`SELECT competition_id, user_id, aggregation_state FROM Leaderboards WHERE aggregation_state != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[uint]map[uint]*common.AggregationState)
+	for rows.Next() {
+		var competitionID, userID uint
+		var stateJSON string
+		if err := rows.Scan(&competitionID, &userID, &stateJSON); err != nil {
+			return nil, err
+		}
+		state := &common.AggregationState{}
+		if err := json.Unmarshal([]byte(stateJSON), state); err != nil {
+			return nil, fmt.Errorf("invalid aggregation state of user %d in competition %d: %w", userID, competitionID, err)
+		}
+		if states[competitionID] == nil {
+			states[competitionID] = make(map[uint]*common.AggregationState)
+		}
+		states[competitionID][userID] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// Update inserts or updates the score for a user in a competition, reached at the given time, and the
+// aggregation state the score is aggregated from.
+// reachedAt must be a fixed-width UTC time so that times compare in order as strings.
+// The number of scored events of the user is incremented, both are used by the tie-breakers. An event that leaves
+// the score unchanged, such as a lower result under the max aggregation, only updates the aggregation state: the
+// user keeps the time they reached the score and their number of events.
+// Scores of finished competitions are frozen and are left untouched.
+func (sr *SQLiteLeaderboards) Update(competitionID, userID uint, score float64, reachedAt string, state *common.AggregationState) error {
+	return updateScore(sr.db, competitionID, userID, score, reachedAt, state)
+}
+
+// updateScore stores a score, see SQLiteLeaderboards.Update
+func updateScore(e execer, competitionID, userID uint, score float64, reachedAt string, state *common.AggregationState) error {
+	stateJSON := ""
+	if state != nil {
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		stateJSON = string(data)
+	}
+	_, err := e.Exec(
+		`INSERT INTO Leaderboards (competition_id, user_id, score, reached_at, events, aggregation_state)
+		SELECT ?, ?, ?, ?, 1, ? WHERE NOT EXISTS (SELECT 1 FROM FinishedCompetitions WHERE competition_id = ?)
+		ON CONFLICT(competition_id, user_id) DO UPDATE SET
+			reached_at=CASE WHEN score = excluded.score THEN reached_at ELSE excluded.reached_at END,
+			events=CASE WHEN score = excluded.score THEN events ELSE events+1 END,
+			score=excluded.score, aggregation_state=excluded.aggregation_state;`,
+		competitionID, userID, score, reachedAt, stateJSON, competitionID,
 	)
 	if err != nil {
 		return err
@@ -70,9 +180,85 @@ func (sr *SQLiteLeaderboards) Update(competitionID, userID uint, score float64)
 	return nil
 }
 
-// GetTopN retrieves the top N users for a given competition, ordered by greatest score
+// GetRankingKeys retrieves the values the users of all competitions are ranked by: their score, the time they
+// reached it and their number of scored events
+func (sr *SQLiteLeaderboards) GetRankingKeys() (map[uint]map[uint]*LeaderboardCursor, error) {
+	rows, err := sr.db.Query(`SELECT competition_id, ` + rankingRowColumns + ` FROM Leaderboards`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[uint]map[uint]*LeaderboardCursor)
+	for rows.Next() {
+		var competitionID uint
+		key := &LeaderboardCursor{}
+		if err := rows.Scan(&competitionID, &key.UserID, &key.Score, &key.ReachedAt, &key.Events); err != nil {
+			return nil, err
+		}
+		if keys[competitionID] == nil {
+			keys[competitionID] = make(map[uint]*LeaderboardCursor)
+		}
+		keys[competitionID][key.UserID] = key
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// UpdateActivity inserts or replaces the history of a user in a competition.
+// Histories of finished competitions are frozen and are left untouched.
+func (sr *SQLiteLeaderboards) UpdateActivity(competitionID, userID uint, activity *common.UserActivity) error {
+	return updateActivity(sr.db, competitionID, userID, activity)
+}
+
+// updateActivity stores the history of a user, see SQLiteLeaderboards.UpdateActivity
+func updateActivity(e execer, competitionID, userID uint, activity *common.UserActivity) error {
+	_, err := e.Exec(
+		`INSERT INTO UserActivity (competition_id, user_id, events, bets, first_seen, last_seen, last_bet)
+		SELECT ?, ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM FinishedCompetitions WHERE competition_id = ?)
+		ON CONFLICT(competition_id, user_id) DO UPDATE SET
+			events=excluded.events, bets=excluded.bets, first_seen=excluded.first_seen, last_seen=excluded.last_seen, last_bet=excluded.last_bet;`,
+		competitionID, userID, activity.Events, activity.Bets, activity.FirstSeen, activity.LastSeen, activity.LastBet, competitionID,
+	)
+	return err
+}
+
+// GetActivities retrieves the histories of the users of all competitions
+func (sr *SQLiteLeaderboards) GetActivities() (map[uint]map[uint]*common.UserActivity, error) {
+	rows, err := sr.db.Query(`SELECT competition_id, user_id, events, bets, first_seen, last_seen, last_bet FROM UserActivity`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := make(map[uint]map[uint]*common.UserActivity)
+	for rows.Next() {
+		var competitionID, userID uint
+		activity := &common.UserActivity{}
+		if err := rows.Scan(&competitionID, &userID, &activity.Events, &activity.Bets, &activity.FirstSeen, &activity.LastSeen, &activity.LastBet); err != nil {
+			return nil, err
+		}
+		if activities[competitionID] == nil {
+			activities[competitionID] = make(map[uint]*common.UserActivity)
+		}
+		activities[competitionID][userID] = activity
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// GetTopN retrieves the top N users for a given competition, ordered by greatest score then by the competition's
+// tie-breaker and user ID. A negative n retrieves all users of the competition.
 func (sr *SQLiteLeaderboards) GetTopN(competitionID uint, n int) ([]*common.User, error) {
-	rows, err := sr.db.Query(`SELECT user_id, score FROM Leaderboards WHERE competition_id = ? ORDER BY score DESC LIMIT ?`, competitionID, n)
+	r, err := readRanking(sr.db, competitionID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sr.db.Query(`SELECT user_id, score FROM Leaderboards WHERE competition_id = ? ORDER BY `+r.orderBy(false)+` LIMIT ?`, competitionID, n)
 	if err != nil {
 		return nil, err
 	}
@@ -91,6 +277,144 @@ func (sr *SQLiteLeaderboards) GetTopN(competitionID uint, n int) ([]*common.User
 	return users, nil
 }
 
+// GetUserStanding retrieves the rank and score of a user in a competition, with up to window users ranked above
+// and below them. Users are ordered like in GetTopN and ranked with the competition's ranking mode.
+// The queries only walk the score indexes around the user instead of sorting the whole competition.
+// Returns ErrUserNotRanked if the user has no score in the competition.
+func (sr *SQLiteLeaderboards) GetUserStanding(competitionID, userID uint, window int) (*common.UserStanding, error) {
+	tx, err := sr.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // Read-only, the transaction only gives a consistent view of the scores
+
+	r, err := readRanking(tx, competitionID)
+	if err != nil {
+		return nil, err
+	}
+	standing := &common.UserStanding{CompetitionID: competitionID, UserID: userID}
+	user := rankingRow{user: &common.RankedUser{ID: userID}, key: LeaderboardCursor{UserID: userID}}
+	err = tx.QueryRow(`SELECT score, reached_at, events FROM Leaderboards WHERE competition_id = ? AND user_id = ?`, competitionID, userID).
+		Scan(&user.key.Score, &user.key.ReachedAt, &user.key.Events)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotRanked
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.user.Score = user.key.Score
+	standing.Score = user.key.Score
+
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM Leaderboards WHERE competition_id = ?`, competitionID).Scan(&standing.TotalParticipants); err != nil {
+		return nil, err
+	}
+
+	if window < 0 {
+		window = 0
+	}
+	aboveWindow := window
+	if aboveWindow == 0 {
+		aboveWindow = 1 // The user ranked just above is needed for the gap to the next rank
+	}
+	condition, args := beforeCondition(r.orderColumns(), user.key, false)
+	above, err := queryRankingRows(tx,
+		`SELECT `+rankingRowColumns+` FROM Leaderboards WHERE competition_id = ? AND `+condition+` ORDER BY `+r.orderBy(true)+` LIMIT ?`,
+		append(append([]any{competitionID}, args...), aboveWindow)...)
+	if err != nil {
+		return nil, err
+	}
+	// Above is read from the closest user, it is ranked from the highest ranked one
+	for i, j := 0, len(above)-1; i < j; i, j = i+1, j-1 {
+		above[i], above[j] = above[j], above[i]
+	}
+
+	condition, args = afterCondition(r.orderColumns(), user.key)
+	below, err := queryRankingRows(tx,
+		`SELECT `+rankingRowColumns+` FROM Leaderboards WHERE competition_id = ? AND `+condition+` ORDER BY `+r.orderBy(false)+` LIMIT ?`,
+		append(append([]any{competitionID}, args...), window)...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := append(append(above, user), below...)
+	rank, position, err := r.rankOf(tx, competitionID, rows[0].key)
+	if err != nil {
+		return nil, err
+	}
+	ranked := r.rankRows(rows, rank, position)
+	standing.Rank = user.user.Rank
+	standing.Above = ranked[:len(above)]
+	standing.Below = ranked[len(above)+1:]
+
+	if len(standing.Above) > 0 {
+		standing.GapToNextRank = standing.Above[len(standing.Above)-1].Score - standing.Score
+	}
+	standing.Above = standing.Above[len(standing.Above)-min(window, len(standing.Above)):]
+	return standing, nil
+}
+
+// GetPage retrieves up to limit users of a competition, ranked like in GetUserStanding, and the number of users
+// of the competition. A negative limit retrieves all users.
+// If after is set, the page starts with the user ranked right after that position and offset is ignored.
+// Cursors are positions rather than users, so pages read with them never repeat or skip users whose score
+// has not changed, even if other scores change between requests.
+func (sr *SQLiteLeaderboards) GetPage(competitionID uint, offset, limit int, after *LeaderboardCursor) (*LeaderboardPage, error) {
+	tx, err := sr.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // Read-only, the transaction only gives a consistent view of the scores
+
+	r, err := readRanking(tx, competitionID)
+	if err != nil {
+		return nil, err
+	}
+	page := &LeaderboardPage{Users: []*common.RankedUser{}}
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM Leaderboards WHERE competition_id = ?`, competitionID).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+	if limit < 0 {
+		limit = page.Total
+	}
+
+	// One more user than requested is read to know if there is a next page
+	var rows []rankingRow
+	if after != nil {
+		condition, args := afterCondition(r.orderColumns(), *after)
+		rows, err = queryRankingRows(tx,
+			`SELECT `+rankingRowColumns+` FROM Leaderboards WHERE competition_id = ? AND `+condition+` ORDER BY `+r.orderBy(false)+` LIMIT ?`,
+			append(append([]any{competitionID}, args...), limit+1)...)
+	} else {
+		if offset < 0 {
+			offset = 0
+		}
+		rows, err = queryRankingRows(tx,
+			`SELECT `+rankingRowColumns+` FROM Leaderboards WHERE competition_id = ? ORDER BY `+r.orderBy(false)+` LIMIT ? OFFSET ?`,
+			competitionID, limit+1, offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) > limit {
+		rows = rows[:limit]
+		page.More = true
+	}
+	if len(rows) == 0 {
+		return page, nil
+	}
+	if page.More {
+		next := rows[len(rows)-1].key
+		page.Next = &next
+	}
+	rank, position, err := r.rankOf(tx, competitionID, rows[0].key)
+	if err != nil {
+		return nil, err
+	}
+	page.Users = r.rankRows(rows, rank, position)
+	return page, nil
+}
+
 // HasBetEvent checks if a bet event with the given eventID exists
 func (sr *SQLiteLeaderboards) HasBetEvent(eventID uint) (bool, error) {
 	var count int
@@ -98,12 +422,223 @@ func (sr *SQLiteLeaderboards) HasBetEvent(eventID uint) (bool, error) {
 	return count > 0, err
 }
 
-// StoreBetEvent stores a bet event in the BetEvents table
+// betEventTimeLayout formats the times bet events happened and were received at. The times are in UTC
+// and have a fixed width, so they are ordered the same way as strings.
+const betEventTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// betEventColumns are the columns of a stored bet event, in the order scanBetEvent reads them
+const betEventColumns = `event_id, user_id, amount, event_type, currency, exchange_rate, game, distributor, studio, timestamp`
+
+// scanBetEventBatchSize is the number of bet events ScanBetEvents reads per query
+const scanBetEventBatchSize = 1000
+
+// StoreBetEvent stores a bet event in the BetEvents table, with the time it happened and the time it is received
 func (sr *SQLiteLeaderboards) StoreBetEvent(event *common.BetEvent) error {
-	_, err := sr.db.Exec("INSERT INTO BetEvents (event_id, user_id, amount) VALUES (?, ?, ?)", event.EventID, event.UserID, event.Amount)
+	return storeBetEvent(sr.db, event)
+}
+
+// storeBetEvent stores a bet event, see SQLiteLeaderboards.StoreBetEvent.
+// Events whose timestamp cannot be parsed are ordered by the time they are received.
+func storeBetEvent(e execer, event *common.BetEvent) error {
+	receivedAt := time.Now().UTC().Format(betEventTimeLayout)
+	occurredAt := receivedAt
+	if timestamp, err := time.Parse(time.RFC3339, event.Timestamp); err == nil {
+		occurredAt = timestamp.UTC().Format(betEventTimeLayout)
+	}
+	_, err := e.Exec(`INSERT INTO BetEvents (`+betEventColumns+`, occurred_at, received_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.EventID, event.UserID, event.Amount, event.EventType, event.Currency, event.ExchangeRate, event.Game, event.Distributor,
+		event.Studio, event.Timestamp, occurredAt, receivedAt)
 	return err
 }
 
+// scanBetEvent reads a bet event selected with betEventColumns, followed by the extra columns
+func scanBetEvent(rows *sql.Rows, extra ...any) (*common.BetEvent, error) {
+	event := &common.BetEvent{}
+	dest := []any{&event.EventID, &event.UserID, &event.Amount, &event.EventType, &event.Currency, &event.ExchangeRate,
+		&event.Game, &event.Distributor, &event.Studio, &event.Timestamp}
+	err := rows.Scan(append(dest, extra...)...)
+	return event, err
+}
+
+// ScanBetEvents calls visit with the stored bet events selected by filter, in the order they happened.
+// Events stored before their full payload was kept cannot be evaluated and are skipped.
+// The events are read in batches, so no query is left open while they are visited.
+func (sr *SQLiteLeaderboards) ScanBetEvents(filter BetEventFilter, visit func(event *common.BetEvent) error) error {
+	conditions, args := betEventConditions(filter)
+	afterOccurredAt, afterEventID := "", -1
+	if !filter.From.IsZero() {
+		afterOccurredAt = filter.From.UTC().Format(betEventTimeLayout)
+	}
+	// Each batch starts after the last event of the previous one, events at exactly From are included
+	// as event IDs are never negative
+	condition := strings.Join(append(conditions, `(occurred_at > ? OR (occurred_at = ? AND event_id > ?))`), " AND ")
+	for {
+		batchArgs := append(append([]any{}, args...), afterOccurredAt, afterOccurredAt, afterEventID, scanBetEventBatchSize)
+		events, occurredAts, err := sr.queryBetEventBatch(condition, batchArgs)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := visit(event); err != nil {
+				return err
+			}
+		}
+		if len(events) < scanBetEventBatchSize {
+			return nil
+		}
+		afterOccurredAt, afterEventID = occurredAts[len(occurredAts)-1], int(events[len(events)-1].EventID)
+	}
+}
+
+// CountBetEvents returns the number of stored bet events ScanBetEvents visits with the same filter
+func (sr *SQLiteLeaderboards) CountBetEvents(filter BetEventFilter) (int, error) {
+	conditions, args := betEventConditions(filter)
+	if !filter.From.IsZero() {
+		conditions = append(conditions, `occurred_at >= ?`)
+		args = append(args, filter.From.UTC().Format(betEventTimeLayout))
+	}
+	var count int
+	err := sr.db.QueryRow(`SELECT COUNT(*) FROM BetEvents WHERE `+strings.Join(conditions, " AND "), args...).Scan(&count)
+	return count, err
+}
+
+// betEventConditions returns the conditions selecting the stored bet events of filter that can be evaluated,
+// except for the From bound, which ScanBetEvents pages through
+func betEventConditions(filter BetEventFilter) ([]string, []any) {
+	conditions := []string{`event_type != ''`}
+	args := []any{}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, `occurred_at <= ?`)
+		args = append(args, filter.To.UTC().Format(betEventTimeLayout))
+	}
+	if !filter.ReceivedAfter.IsZero() {
+		conditions = append(conditions, `received_at > ?`)
+		args = append(args, filter.ReceivedAfter.UTC().Format(betEventTimeLayout))
+	}
+	if !filter.ReceivedUntil.IsZero() {
+		conditions = append(conditions, `received_at <= ?`)
+		args = append(args, filter.ReceivedUntil.UTC().Format(betEventTimeLayout))
+	}
+	return conditions, args
+}
+
+// LastReceivedAt returns the time the last stored bet event was received at, or the zero time if there is none.
+// Bet events are stored one at a time, so every event received later is stored after the ones received up to it.
+func (sr *SQLiteLeaderboards) LastReceivedAt() (time.Time, error) {
+	var receivedAt string
+	if err := sr.db.QueryRow(`SELECT COALESCE(MAX(received_at), '') FROM BetEvents`).Scan(&receivedAt); err != nil {
+		return time.Time{}, err
+	}
+	if receivedAt == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(betEventTimeLayout, receivedAt)
+}
+
+// queryBetEventBatch reads a batch of the bet events matching condition, in the order they happened,
+// with the times they happened at
+func (sr *SQLiteLeaderboards) queryBetEventBatch(condition string, args []any) ([]*common.BetEvent, []string, error) {
+	rows, err := sr.db.Query(`SELECT `+betEventColumns+`, occurred_at FROM BetEvents WHERE `+condition+` ORDER BY occurred_at, event_id LIMIT ?`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var events []*common.BetEvent
+	var occurredAts []string
+	for rows.Next() {
+		var occurredAt string
+		event, err := scanBetEvent(rows, &occurredAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, event)
+		occurredAts = append(occurredAts, occurredAt)
+	}
+	return events, occurredAts, rows.Err()
+}
+
+// ReplaceScores replaces the scores and user histories of a competition in a single transaction.
+// It returns ErrCompetitionNotFound if the competition does not exist, so no scores are left behind
+// for a competition deleted while they were computed.
+func (sr *SQLiteLeaderboards) ReplaceScores(competitionID uint, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error {
+	tx, err := sr.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM Competitions WHERE id = ?)`, competitionID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrCompetitionNotFound
+	}
+	if err := replaceScores(tx, "Leaderboards", "UserActivity", competitionID, scores, activities); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceScores replaces the scores and user histories of a competition in the given tables
+func replaceScores(e execer, scoresTable, activitiesTable string, competitionID uint, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error {
+	if _, err := e.Exec(`DELETE FROM `+scoresTable+` WHERE competition_id = ?`, competitionID); err != nil {
+		return err
+	}
+	if _, err := e.Exec(`DELETE FROM `+activitiesTable+` WHERE competition_id = ?`, competitionID); err != nil {
+		return err
+	}
+	for _, score := range scores {
+		state, err := json.Marshal(score.State)
+		if err != nil {
+			return err
+		}
+		if _, err := e.Exec(`INSERT INTO `+scoresTable+` (competition_id, user_id, score, reached_at, events, aggregation_state) VALUES (?, ?, ?, ?, ?, ?)`,
+			competitionID, score.UserID, score.Score, score.ReachedAt, score.Events, string(state)); err != nil {
+			return err
+		}
+	}
+	for userID, activity := range activities {
+		if _, err := e.Exec(`INSERT INTO `+activitiesTable+` (competition_id, user_id, events, bets, first_seen, last_seen, last_bet) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			competitionID, userID, activity.Events, activity.Bets, activity.FirstSeen, activity.LastSeen, activity.LastBet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBetEvents retrieves the stored bet events with the given IDs, ordered by event ID.
+// IDs that have not been stored are ignored, and so are the events stored before their full payload was kept,
+// as they cannot be evaluated.
+func (sr *SQLiteLeaderboards) GetBetEvents(eventIDs []uint) ([]common.BetEvent, error) {
+	if len(eventIDs) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(eventIDs)), ",")
+	args := make([]any, len(eventIDs))
+	for i, id := range eventIDs {
+		args[i] = id
+	}
+
+	rows, err := sr.db.Query(`SELECT `+betEventColumns+` FROM BetEvents WHERE event_type != '' AND event_id IN (`+placeholders+`) ORDER BY event_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []common.BetEvent
+	for rows.Next() {
+		event, err := scanBetEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
 // Close closes the SQLite database connection
 func (sr *SQLiteLeaderboards) Close() {
 	if sr.db != nil {
@@ -1,30 +1,52 @@
 package repositories
 
-import "common"
+import (
+	"common"
+	"sort"
+	"time"
+)
 
 // MockLeaderboardsRepo is a mock implementation of LeaderboardsRepository for testing purposes
 type MockLeaderboardsRepo struct {
 	Updates []struct {
 		compID, userID uint
 		score          float64
+		reachedAt      string
+		state          *common.AggregationState
 	}
 	AllUsers    map[uint][]common.User
+	AllStates   map[uint]map[uint]*common.AggregationState
+	RankingKeys map[uint]map[uint]*LeaderboardCursor
+	Activities  map[uint]map[uint]*common.UserActivity
 	TopNUsers   []*common.User
 	GetTopNFunc func(competitionID uint, n int) ([]*common.User, error)
+	Standing    *common.UserStanding
+	GetPageFunc func(competitionID uint, offset, limit int, after *LeaderboardCursor) (*LeaderboardPage, error)
 	BetEvents   map[uint]bool
 
 	ReturnErr           error
 	StoreBetEventErr    error
 	StoreBetEventCalled bool
 	LastStoredBetEvent  *common.BetEvent
+	StoredBetEvents     []common.BetEvent
+	ReceivedAt          map[uint]time.Time // map[eventID]time the event of StoredBetEvents was received at
+	ReplacedScores      map[uint][]*CompetitionScore
+	ReplacedActivities  map[uint]map[uint]*common.UserActivity
+	ReplaceScoresErr    error
+	BeginErr            error
+	CommitErr           error
+	UnitsOfWork         []*MockLeaderboardsUnitOfWork
+	DisabledRules       []uint // Competitions disabled by the units of work
 }
 
 // Update appends the update to the mock's updates slice and returns the configured error
-func (m *MockLeaderboardsRepo) Update(competitionID, userID uint, score float64) error {
+func (m *MockLeaderboardsRepo) Update(competitionID, userID uint, score float64, reachedAt string, state *common.AggregationState) error {
 	m.Updates = append(m.Updates, struct {
 		compID, userID uint
 		score          float64
-	}{competitionID, userID, score})
+		reachedAt      string
+		state          *common.AggregationState
+	}{competitionID, userID, score, reachedAt, state})
 	return m.ReturnErr
 }
 
@@ -33,6 +55,33 @@ func (m *MockLeaderboardsRepo) GetAll() (map[uint][]common.User, error) {
 	return m.AllUsers, m.ReturnErr
 }
 
+// GetAggregationStates returns AllStates and the configured error
+func (m *MockLeaderboardsRepo) GetAggregationStates() (map[uint]map[uint]*common.AggregationState, error) {
+	return m.AllStates, m.ReturnErr
+}
+
+// GetRankingKeys returns RankingKeys and the configured error
+func (m *MockLeaderboardsRepo) GetRankingKeys() (map[uint]map[uint]*LeaderboardCursor, error) {
+	return m.RankingKeys, m.ReturnErr
+}
+
+// UpdateActivity records the history of the user in Activities and returns the configured error
+func (m *MockLeaderboardsRepo) UpdateActivity(competitionID, userID uint, activity *common.UserActivity) error {
+	if m.Activities == nil {
+		m.Activities = map[uint]map[uint]*common.UserActivity{}
+	}
+	if m.Activities[competitionID] == nil {
+		m.Activities[competitionID] = map[uint]*common.UserActivity{}
+	}
+	m.Activities[competitionID][userID] = activity
+	return m.ReturnErr
+}
+
+// GetActivities returns Activities and the configured error
+func (m *MockLeaderboardsRepo) GetActivities() (map[uint]map[uint]*common.UserActivity, error) {
+	return m.Activities, m.ReturnErr
+}
+
 // GetTopN returns an empty slice and nil error for the mock implementation
 func (m *MockLeaderboardsRepo) GetTopN(competitionID uint, n int) ([]*common.User, error) {
 	if m.GetTopNFunc != nil {
@@ -41,6 +90,35 @@ func (m *MockLeaderboardsRepo) GetTopN(competitionID uint, n int) ([]*common.Use
 	return m.TopNUsers, m.ReturnErr
 }
 
+// GetUserStanding returns the configured Standing, or ErrUserNotRanked if it is nil
+func (m *MockLeaderboardsRepo) GetUserStanding(competitionID, userID uint, window int) (*common.UserStanding, error) {
+	if m.ReturnErr != nil {
+		return nil, m.ReturnErr
+	}
+	if m.Standing == nil {
+		return nil, ErrUserNotRanked
+	}
+	return m.Standing, nil
+}
+
+// GetPage calls GetPageFunc if it is set, otherwise it ranks the users returned by GetTopN by their position.
+// The cursor is ignored.
+func (m *MockLeaderboardsRepo) GetPage(competitionID uint, offset, limit int, after *LeaderboardCursor) (*LeaderboardPage, error) {
+	if m.GetPageFunc != nil {
+		return m.GetPageFunc(competitionID, offset, limit, after)
+	}
+	users, err := m.GetTopN(competitionID, -1)
+	if err != nil {
+		return nil, err
+	}
+	page := &LeaderboardPage{Users: []*common.RankedUser{}, Total: len(users)}
+	for i := offset; i < len(users) && (limit < 0 || i < offset+limit); i++ {
+		page.Users = append(page.Users, &common.RankedUser{Rank: i + 1, ID: users[i].ID, Score: users[i].Score})
+	}
+	page.More = offset+len(page.Users) < len(users)
+	return page, nil
+}
+
 // HasBetEvent returns true if the eventID is in the BetEvents map
 func (m *MockLeaderboardsRepo) HasBetEvent(eventID uint) (bool, error) {
 	if m.BetEvents == nil {
@@ -59,3 +137,150 @@ func (m *MockLeaderboardsRepo) StoreBetEvent(event *common.BetEvent) error {
 	m.BetEvents[event.EventID] = true
 	return m.StoreBetEventErr
 }
+
+// GetBetEvents returns the events of StoredBetEvents whose ID is in eventIDs, except those without an event type
+func (m *MockLeaderboardsRepo) GetBetEvents(eventIDs []uint) ([]common.BetEvent, error) {
+	var events []common.BetEvent
+	for _, event := range m.StoredBetEvents {
+		if event.EventType == "" {
+			continue
+		}
+		for _, id := range eventIDs {
+			if event.EventID == id {
+				events = append(events, event)
+				break
+			}
+		}
+	}
+	return events, m.ReturnErr
+}
+
+// ScanBetEvents visits the events of StoredBetEvents selected by filter, ordered by timestamp then event ID.
+// Events are received at their time in ReceivedAt, and without a valid timestamp they are only visited
+// when From and To are both open.
+func (m *MockLeaderboardsRepo) ScanBetEvents(filter BetEventFilter, visit func(event *common.BetEvent) error) error {
+	if m.ReturnErr != nil {
+		return m.ReturnErr
+	}
+	events := append([]common.BetEvent(nil), m.StoredBetEvents...)
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Timestamp != events[j].Timestamp {
+			return events[i].Timestamp < events[j].Timestamp
+		}
+		return events[i].EventID < events[j].EventID
+	})
+	for i := range events {
+		if !m.selects(filter, events[i]) {
+			continue
+		}
+		if err := visit(&events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountBetEvents returns the number of events ScanBetEvents visits with the same filter
+func (m *MockLeaderboardsRepo) CountBetEvents(filter BetEventFilter) (int, error) {
+	count := 0
+	for _, event := range m.StoredBetEvents {
+		if m.selects(filter, event) {
+			count++
+		}
+	}
+	return count, m.ReturnErr
+}
+
+// selects reports whether filter selects a stored event
+func (m *MockLeaderboardsRepo) selects(filter BetEventFilter, event common.BetEvent) bool {
+	receivedAt := m.ReceivedAt[event.EventID]
+	if (!filter.ReceivedAfter.IsZero() && !receivedAt.After(filter.ReceivedAfter)) ||
+		(!filter.ReceivedUntil.IsZero() && receivedAt.After(filter.ReceivedUntil)) {
+		return false
+	}
+	timestamp, err := time.Parse(time.RFC3339, event.Timestamp)
+	if err != nil {
+		return filter.From.IsZero() && filter.To.IsZero()
+	}
+	return (filter.From.IsZero() || !timestamp.Before(filter.From)) && (filter.To.IsZero() || !timestamp.After(filter.To))
+}
+
+// LastReceivedAt returns the latest time of ReceivedAt
+func (m *MockLeaderboardsRepo) LastReceivedAt() (time.Time, error) {
+	var last time.Time
+	for _, receivedAt := range m.ReceivedAt {
+		if receivedAt.After(last) {
+			last = receivedAt
+		}
+	}
+	return last, m.ReturnErr
+}
+
+// ReplaceScores records the scores and histories of the competition, or returns ReplaceScoresErr
+func (m *MockLeaderboardsRepo) ReplaceScores(competitionID uint, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error {
+	if m.ReplaceScoresErr != nil {
+		return m.ReplaceScoresErr
+	}
+	if m.ReplacedScores == nil {
+		m.ReplacedScores = make(map[uint][]*CompetitionScore)
+		m.ReplacedActivities = make(map[uint]map[uint]*common.UserActivity)
+	}
+	m.ReplacedScores[competitionID] = scores
+	m.ReplacedActivities[competitionID] = activities
+	return nil
+}
+
+// Begin returns a MockLeaderboardsUnitOfWork writing to the mock, or BeginErr
+func (m *MockLeaderboardsRepo) Begin() (LeaderboardsUnitOfWork, error) {
+	if m.BeginErr != nil {
+		return nil, m.BeginErr
+	}
+	unitOfWork := &MockLeaderboardsUnitOfWork{repo: m}
+	m.UnitsOfWork = append(m.UnitsOfWork, unitOfWork)
+	return unitOfWork, nil
+}
+
+// MockLeaderboardsUnitOfWork writes straight to its MockLeaderboardsRepo and records how it ended
+type MockLeaderboardsUnitOfWork struct {
+	repo       *MockLeaderboardsRepo
+	Committed  bool
+	RolledBack bool
+}
+
+// StoreBetEvent calls StoreBetEvent on the mock repository
+func (u *MockLeaderboardsUnitOfWork) StoreBetEvent(event *common.BetEvent) error {
+	return u.repo.StoreBetEvent(event)
+}
+
+// Update calls Update on the mock repository
+func (u *MockLeaderboardsUnitOfWork) Update(competitionID, userID uint, score float64, reachedAt string, state *common.AggregationState) error {
+	return u.repo.Update(competitionID, userID, score, reachedAt, state)
+}
+
+// UpdateActivity calls UpdateActivity on the mock repository
+func (u *MockLeaderboardsUnitOfWork) UpdateActivity(competitionID, userID uint, activity *common.UserActivity) error {
+	return u.repo.UpdateActivity(competitionID, userID, activity)
+}
+
+// DisableRule records the competition in the repository's DisabledRules
+func (u *MockLeaderboardsUnitOfWork) DisableRule(competitionID uint) error {
+	u.repo.DisabledRules = append(u.repo.DisabledRules, competitionID)
+	return nil
+}
+
+// Commit records the commit and returns the repository's CommitErr
+func (u *MockLeaderboardsUnitOfWork) Commit() error {
+	if u.repo.CommitErr != nil {
+		return u.repo.CommitErr
+	}
+	u.Committed = true
+	return nil
+}
+
+// Rollback records the rollback if the unit of work has not been committed
+func (u *MockLeaderboardsUnitOfWork) Rollback() error {
+	if !u.Committed {
+		u.RolledBack = true
+	}
+	return nil
+}
@@ -2,17 +2,20 @@ package repositories
 
 import (
 	"common"
+	"errors"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSQLiteLeaderboardsRepository_UpdateAndGetAll(t *testing.T) {
 	dbPath := "test_leaderboards.db"
 
-	// Call the init_db.sh script to create the schema for the test DB
-	err := runInitDBScript(dbPath)
+	// Migrate the test DB to create its schema
+	err := MigrateDatabase(dbPath)
 	if err != nil {
-		t.Fatalf("failed to run init_db.sh: %v", err)
+		t.Fatalf("failed to migrate test DB: %v", err)
 	}
 
 	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
@@ -25,15 +28,15 @@ func TestSQLiteLeaderboardsRepository_UpdateAndGetAll(t *testing.T) {
 	}()
 
 	// Update scores for different competitions and users
-	err = repo.Update(1, 10, 100.0)
+	err = repo.Update(1, 10, 100.0, "", nil)
 	if err != nil {
 		t.Errorf("failed to update score: %v", err)
 	}
-	err = repo.Update(1, 20, 200.0)
+	err = repo.Update(1, 20, 200.0, "", nil)
 	if err != nil {
 		t.Errorf("failed to update score: %v", err)
 	}
-	err = repo.Update(2, 10, 300.0)
+	err = repo.Update(2, 10, 300.0, "", &common.AggregationState{Count: 2, Sum: 400, Best: []float64{300}})
 	if err != nil {
 		t.Errorf("failed to update score: %v", err)
 	}
@@ -70,15 +73,159 @@ func TestSQLiteLeaderboardsRepository_UpdateAndGetAll(t *testing.T) {
 	if scores[2][0].ID != 10 || scores[2][0].Score != 300.0 {
 		t.Errorf("expected user 10 with score 300.0 in competition 2, got ID=%d, Score=%v", scores[2][0].ID, scores[2][0].Score)
 	}
+
+	// Only the scores stored with an aggregation state have one
+	states, err := repo.GetAggregationStates()
+	if err != nil {
+		t.Fatalf("failed to get aggregation states: %v", err)
+	}
+	expectedStates := map[uint]map[uint]*common.AggregationState{2: {10: {Count: 2, Sum: 400, Best: []float64{300}}}}
+	if !reflect.DeepEqual(states, expectedStates) {
+		t.Errorf("expected aggregation states %+v, got %+v", expectedStates, states)
+	}
+
+	// The ranking keys hold what the tie-breakers compare
+	keys, err := repo.GetRankingKeys()
+	if err != nil {
+		t.Fatalf("failed to get ranking keys: %v", err)
+	}
+	if len(keys[1]) != 2 || len(keys[2]) != 1 {
+		t.Fatalf("expected the ranking keys of 3 users, got %+v", keys)
+	}
+	if key := keys[2][10]; *key != (LeaderboardCursor{Score: 300, Events: 1, UserID: 10}) {
+		t.Errorf("expected the ranking key of user 10 in competition 2, got %+v", key)
+	}
+}
+
+func TestSQLiteLeaderboards_UpdateActivity(t *testing.T) {
+	dbPath := "test_leaderboards_activity.db"
+	os.Remove(dbPath)
+	if err := MigrateDatabase(dbPath); err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create SQLiteLeaderboardsRepository: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+
+	first := &common.UserActivity{Events: 1, Bets: 1, FirstSeen: "2024-06-01T10:00:00Z", LastSeen: "2024-06-01T10:00:00Z", LastBet: "2024-06-01T10:00:00Z"}
+	second := &common.UserActivity{Events: 2, Bets: 1, FirstSeen: "2024-06-01T10:00:00Z", LastSeen: "2024-06-01T11:00:00Z", LastBet: "2024-06-01T10:00:00Z"}
+	for _, activity := range []*common.UserActivity{first, second} {
+		if err := repo.UpdateActivity(1, 7, activity); err != nil {
+			t.Fatalf("failed to update activity: %v", err)
+		}
+	}
+	if err := repo.UpdateActivity(2, 7, first); err != nil {
+		t.Fatalf("failed to update activity: %v", err)
+	}
+
+	// Histories of finished competitions are frozen
+	if _, err := repo.db.Exec(`INSERT INTO FinishedCompetitions (competition_id, finished_at) VALUES (2, '2024-06-02T00:00:00Z')`); err != nil {
+		t.Fatalf("failed to finish competition: %v", err)
+	}
+	if err := repo.UpdateActivity(2, 7, second); err != nil {
+		t.Fatalf("failed to update activity: %v", err)
+	}
+
+	activities, err := repo.GetActivities()
+	if err != nil {
+		t.Fatalf("failed to get activities: %v", err)
+	}
+	expected := map[uint]map[uint]*common.UserActivity{1: {7: second}, 2: {7: first}}
+	if !reflect.DeepEqual(activities, expected) {
+		t.Errorf("expected activities %+v, got %+v", expected, activities)
+	}
+}
+
+func TestSQLiteLeaderboards_UnitOfWork(t *testing.T) {
+	dbPath := "test_leaderboards_unit_of_work.db"
+	os.Remove(dbPath)
+	if err := MigrateDatabase(dbPath); err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create SQLiteLeaderboardsRepository: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+	if _, err := repo.db.Exec(`INSERT INTO Competitions (id, name, scorerule) VALUES (1, 'First', 'amount'), (2, 'Second', 'amount')`); err != nil {
+		t.Fatalf("failed to create competitions: %v", err)
+	}
+	ruleDisabled := func(competitionID uint) bool {
+		var disabled bool
+		if err := repo.db.QueryRow(`SELECT rule_disabled FROM Competitions WHERE id = ?`, competitionID).Scan(&disabled); err != nil {
+			t.Fatalf("failed to read competition %d: %v", competitionID, err)
+		}
+		return disabled
+	}
+
+	// write stores an event with its score and history and disables the competition with the ID of the event,
+	// then commits or rolls back
+	write := func(eventID uint, commit bool) {
+		unitOfWork, err := repo.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin: %v", err)
+		}
+		defer unitOfWork.Rollback()
+		if err := unitOfWork.StoreBetEvent(&common.BetEvent{EventID: eventID, UserID: 7, Amount: 10}); err != nil {
+			t.Fatalf("failed to store bet event: %v", err)
+		}
+		if err := unitOfWork.Update(1, 7, float64(eventID), "", nil); err != nil {
+			t.Fatalf("failed to update score: %v", err)
+		}
+		if err := unitOfWork.UpdateActivity(1, 7, &common.UserActivity{Events: int(eventID)}); err != nil {
+			t.Fatalf("failed to update activity: %v", err)
+		}
+		if err := unitOfWork.DisableRule(eventID); err != nil {
+			t.Fatalf("failed to disable rule: %v", err)
+		}
+		if commit {
+			if err := unitOfWork.Commit(); err != nil {
+				t.Fatalf("failed to commit: %v", err)
+			}
+		}
+	}
+
+	write(1, false)
+	if exists, _ := repo.HasBetEvent(1); exists {
+		t.Error("expected a rolled back event not to be marked as processed")
+	}
+	if scores, _ := repo.GetAll(); len(scores[1]) != 0 {
+		t.Errorf("expected a rolled back score not to be stored, got %+v", scores[1])
+	}
+	if ruleDisabled(1) {
+		t.Error("expected a rolled back competition not to be disabled")
+	}
+
+	write(2, true)
+	if exists, _ := repo.HasBetEvent(2); !exists {
+		t.Error("expected a committed event to be marked as processed")
+	}
+	if scores, _ := repo.GetAll(); len(scores[1]) != 1 || scores[1][0].Score != 2 {
+		t.Errorf("expected the committed score to be stored, got %+v", scores[1])
+	}
+	if activities, _ := repo.GetActivities(); activities[1][7] == nil || activities[1][7].Events != 2 {
+		t.Errorf("expected the committed activity to be stored, got %+v", activities)
+	}
+	if !ruleDisabled(2) {
+		t.Error("expected the committed competition to be disabled")
+	}
 }
 
 func TestSQLiteLeaderboards_GetTopN(t *testing.T) {
 	dbPath := "test_leaderboards.db"
 
-	// Call the init_db.sh script to create the schema for the test DB
-	err := runInitDBScript(dbPath)
+	// Migrate the test DB to create its schema
+	err := MigrateDatabase(dbPath)
 	if err != nil {
-		t.Fatalf("failed to run init_db.sh: %v", err)
+		t.Fatalf("failed to migrate test DB: %v", err)
 	}
 
 	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
@@ -99,7 +246,7 @@ func TestSQLiteLeaderboards_GetTopN(t *testing.T) {
 		{ID: 4, Score: 50},
 	}
 	for _, u := range users {
-		err := repo.Update(competitionID, u.ID, u.Score)
+		err := repo.Update(competitionID, u.ID, u.Score, "", nil)
 		if err != nil {
 			t.Fatalf("failed to update user: %v", err)
 		}
@@ -130,9 +277,9 @@ func TestSQLiteLeaderboards_GetTopN(t *testing.T) {
 
 func TestSQLiteLeaderboards_HasBetEvent(t *testing.T) {
 	dbPath := "test_leaderboards_betevents_has.db"
-	err := runInitDBScript(dbPath)
+	err := MigrateDatabase(dbPath)
 	if err != nil {
-		t.Fatalf("failed to run init_db.sh: %v", err)
+		t.Fatalf("failed to migrate test DB: %v", err)
 	}
 
 	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
@@ -168,9 +315,9 @@ func TestSQLiteLeaderboards_HasBetEvent(t *testing.T) {
 
 func TestSQLiteLeaderboards_StoreBetEvent(t *testing.T) {
 	dbPath := "test_leaderboards_betevents_store.db"
-	err := runInitDBScript(dbPath)
+	err := MigrateDatabase(dbPath)
 	if err != nil {
-		t.Fatalf("failed to run init_db.sh: %v", err)
+		t.Fatalf("failed to migrate test DB: %v", err)
 	}
 
 	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
@@ -194,3 +341,525 @@ func TestSQLiteLeaderboards_StoreBetEvent(t *testing.T) {
 		// SQLite will error on duplicate primary key unless handled, so this is a valid test
 	}
 }
+
+func TestSQLiteLeaderboards_GetBetEvents(t *testing.T) {
+	dbPath := "test_leaderboards_betevents_get.db"
+	err := MigrateDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+
+	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create SQLiteLeaderboardsRepository: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+
+	full := common.BetEvent{EventID: 1, EventType: common.EventTypeWin, UserID: 10, Amount: 1.5, Currency: "EUR", ExchangeRate: 1.1,
+		Game: "Blackjack", Distributor: "evo", Studio: "StudioX", Timestamp: "2024-06-01T10:00:00+02:00"}
+	for _, event := range []*common.BetEvent{
+		{EventID: 3, EventType: common.EventTypeBet, UserID: 30, Amount: 3.5},
+		&full,
+		{EventID: 2, EventType: common.EventTypeBet, UserID: 20, Amount: 2.5},
+		{EventID: 4, UserID: 40, Amount: 4.5}, // Stored before the full payload was kept
+	} {
+		if err := repo.StoreBetEvent(event); err != nil {
+			t.Fatalf("StoreBetEvent failed: %v", err)
+		}
+	}
+
+	events, err := repo.GetBetEvents([]uint{3, 1, 4, 99})
+	if err != nil {
+		t.Fatalf("GetBetEvents failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0] != full || events[1].EventID != 3 {
+		t.Errorf("expected the full payload of the events, got %+v", events)
+	}
+
+	events, err = repo.GetBetEvents(nil)
+	if err != nil || len(events) != 0 {
+		t.Errorf("expected no events for empty IDs, got %+v, %v", events, err)
+	}
+}
+
+func TestSQLiteLeaderboards_ScanBetEvents(t *testing.T) {
+	dbPath := "test_leaderboards_betevents_scan.db"
+	os.Remove(dbPath)
+	if err := MigrateDatabase(dbPath); err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create SQLiteLeaderboardsRepository: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+
+	// More events than a batch, stored with decreasing times, half of them an hour ahead in another time zone
+	start, _ := time.Parse(time.RFC3339, "2024-06-01T00:00:00Z")
+	count := scanBetEventBatchSize + 10
+	unitOfWork, err := repo.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	for i := 0; i < count; i++ {
+		timestamp := start.Add(time.Duration(count-i) * time.Minute)
+		if i%2 == 0 {
+			timestamp = timestamp.In(time.FixedZone("CET", 3600))
+		}
+		event := &common.BetEvent{EventID: uint(i + 1), EventType: common.EventTypeBet, UserID: 7, Amount: 1, Timestamp: timestamp.Format(time.RFC3339)}
+		if err := unitOfWork.StoreBetEvent(event); err != nil {
+			t.Fatalf("failed to store bet event: %v", err)
+		}
+	}
+	if err := unitOfWork.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	// An event stored before the full payload was kept is not replayed
+	if _, err := repo.db.Exec(`INSERT INTO BetEvents (event_id, user_id, amount) VALUES (?, 7, 1)`, count+1); err != nil {
+		t.Fatalf("failed to store legacy bet event: %v", err)
+	}
+
+	var visited []uint
+	err = repo.ScanBetEvents(BetEventFilter{}, func(event *common.BetEvent) error {
+		visited = append(visited, event.EventID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanBetEvents failed: %v", err)
+	}
+	if len(visited) != count {
+		t.Fatalf("expected %d events, got %d", count, len(visited))
+	}
+	for i, eventID := range visited {
+		if eventID != uint(count-i) {
+			t.Fatalf("expected the events in the order they happened, got event %d at position %d", eventID, i)
+		}
+	}
+
+	// Both ends of the window are inclusive
+	visited = nil
+	window := BetEventFilter{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)}
+	err = repo.ScanBetEvents(window, func(event *common.BetEvent) error {
+		visited = append(visited, event.EventID)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(visited, []uint{uint(count), uint(count - 1), uint(count - 2)}) {
+		t.Errorf("expected the 3 events of the window, got %v, %v", visited, err)
+	}
+	if total, err := repo.CountBetEvents(window); err != nil || total != 3 {
+		t.Errorf("expected 3 events in the window, got %d, %v", total, err)
+	}
+
+	// The events of the window are split by the time they were received at
+	cutoff := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := repo.db.Exec(`UPDATE BetEvents SET received_at = ? WHERE event_id = ?`, cutoff.Format(betEventTimeLayout), count); err != nil {
+		t.Fatalf("failed to set received_at: %v", err)
+	}
+	if _, err := repo.db.Exec(`UPDATE BetEvents SET received_at = ? WHERE event_id = ?`, cutoff.Add(time.Second).Format(betEventTimeLayout), count-1); err != nil {
+		t.Fatalf("failed to set received_at: %v", err)
+	}
+	if _, err := repo.db.Exec(`UPDATE BetEvents SET received_at = ? WHERE event_id NOT IN (?, ?)`, cutoff.Add(-time.Hour).Format(betEventTimeLayout), count, count-1); err != nil {
+		t.Fatalf("failed to set received_at: %v", err)
+	}
+	if last, err := repo.LastReceivedAt(); err != nil || !last.Equal(cutoff.Add(time.Second)) {
+		t.Errorf("expected the last event to be received at %v, got %v, %v", cutoff.Add(time.Second), last, err)
+	}
+	before, after := window, window
+	before.ReceivedUntil, after.ReceivedAfter = cutoff, cutoff
+	for _, c := range []struct {
+		filter   BetEventFilter
+		expected []uint
+	}{
+		{before, []uint{uint(count), uint(count - 2)}},
+		{after, []uint{uint(count - 1)}},
+	} {
+		visited = nil
+		err = repo.ScanBetEvents(c.filter, func(event *common.BetEvent) error {
+			visited = append(visited, event.EventID)
+			return nil
+		})
+		if err != nil || !reflect.DeepEqual(visited, c.expected) {
+			t.Errorf("expected events %v, got %v, %v", c.expected, visited, err)
+		}
+		if total, err := repo.CountBetEvents(c.filter); err != nil || total != len(c.expected) {
+			t.Errorf("expected %d events, got %d, %v", len(c.expected), total, err)
+		}
+	}
+}
+
+func TestSQLiteLeaderboards_ReplaceScores(t *testing.T) {
+	dbPath := "test_leaderboards_replace.db"
+	os.Remove(dbPath)
+	if err := MigrateDatabase(dbPath); err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create SQLiteLeaderboardsRepository: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+
+	if _, err := repo.db.Exec(`INSERT INTO Competitions (id, name, scorerule) VALUES (1, 'Replaced', 'amount')`); err != nil {
+		t.Fatalf("failed to create competition: %v", err)
+	}
+	if err := repo.Update(1, 7, 500, "2024-06-01T00:00:00.000000000Z", nil); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := repo.UpdateActivity(1, 7, &common.UserActivity{Events: 9}); err != nil {
+		t.Fatalf("UpdateActivity failed: %v", err)
+	}
+	if err := repo.Update(2, 7, 42, "2024-06-01T00:00:00.000000000Z", nil); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	scores := []*CompetitionScore{
+		{UserID: 8, Score: 30, ReachedAt: "2024-06-01T00:02:00.000000000Z", Events: 2, State: common.AggregationState{Count: 2, Sum: 30}},
+		{UserID: 9, Score: 10, ReachedAt: "2024-06-01T00:01:00.000000000Z", Events: 1, State: common.AggregationState{Count: 1, Sum: 10}},
+	}
+	activities := map[uint]*common.UserActivity{8: {Events: 3, Bets: 2}}
+	if err := repo.ReplaceScores(1, scores, activities); err != nil {
+		t.Fatalf("ReplaceScores failed: %v", err)
+	}
+
+	all, err := repo.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	replaced := map[uint]float64{}
+	for _, user := range all[1] {
+		replaced[user.ID] = user.Score
+	}
+	if !reflect.DeepEqual(replaced, map[uint]float64{8: 30, 9: 10}) {
+		t.Errorf("expected the scores of competition 1 to be replaced, got %v", all[1])
+	}
+	if !reflect.DeepEqual(all[2], []common.User{{ID: 7, Score: 42}}) {
+		t.Errorf("expected the scores of competition 2 to be left untouched, got %v", all[2])
+	}
+	page, err := repo.GetPage(1, 0, 10, nil)
+	if err != nil || len(page.Users) != 2 || page.Users[0].ID != 8 {
+		t.Fatalf("unexpected page after ReplaceScores: %+v, %v", page, err)
+	}
+	states, err := repo.GetAggregationStates()
+	if err != nil || states[1][8] == nil || states[1][8].Sum != 30 {
+		t.Errorf("expected the aggregation states to be stored, got %v, %v", states[1], err)
+	}
+	stored, err := repo.GetActivities()
+	if err != nil || !reflect.DeepEqual(stored[1], activities) {
+		t.Errorf("expected the histories of competition 1 to be replaced, got %v, %v", stored[1], err)
+	}
+
+	if err := repo.ReplaceScores(3, scores, nil); !errors.Is(err, ErrCompetitionNotFound) {
+		t.Errorf("expected ErrCompetitionNotFound for a missing competition, got %v", err)
+	}
+}
+
+func TestSQLiteLeaderboards_GetUserStanding(t *testing.T) {
+	dbPath := "test_leaderboards_standing.db"
+
+	// Migrate the test DB to create its schema
+	err := MigrateDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+
+	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create repo: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+
+	// Ranked by score then user ID: 5 (500), 2 (300), 3 (300), 4 (200), 1 (100), 6 (50)
+	scores := map[uint]float64{1: 100, 2: 300, 3: 300, 4: 200, 5: 500, 6: 50}
+	for userID, score := range scores {
+		if err := repo.Update(1, userID, score, "", nil); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+	}
+	repo.Update(2, 4, 1000, "", nil) // Scores of other competitions are not ranked
+
+	standing, err := repo.GetUserStanding(1, 4, 2)
+	if err != nil {
+		t.Fatalf("GetUserStanding failed: %v", err)
+	}
+	if standing.Rank != 4 || standing.Score != 200 || standing.TotalParticipants != 6 || standing.GapToNextRank != 100 {
+		t.Errorf("unexpected standing: %+v", standing)
+	}
+	expectedAbove := []*common.RankedUser{{Rank: 2, ID: 2, Score: 300}, {Rank: 3, ID: 3, Score: 300}}
+	if !reflect.DeepEqual(standing.Above, expectedAbove) {
+		t.Errorf("expected users above %+v, got %+v", expectedAbove, standing.Above)
+	}
+	expectedBelow := []*common.RankedUser{{Rank: 5, ID: 1, Score: 100}, {Rank: 6, ID: 6, Score: 50}}
+	if !reflect.DeepEqual(standing.Below, expectedBelow) {
+		t.Errorf("expected users below %+v, got %+v", expectedBelow, standing.Below)
+	}
+
+	// Tied users are ranked by user ID
+	standing, _ = repo.GetUserStanding(1, 3, 1)
+	if standing.Rank != 3 || standing.GapToNextRank != 0 || standing.Above[0].ID != 2 || standing.Below[0].ID != 4 {
+		t.Errorf("unexpected standing of tied user: %+v", standing)
+	}
+
+	// The first user has nobody above, the gap is still computed without neighbours
+	standing, _ = repo.GetUserStanding(1, 5, 0)
+	if standing.Rank != 1 || standing.GapToNextRank != 0 || len(standing.Above) != 0 || len(standing.Below) != 0 {
+		t.Errorf("unexpected standing of first user: %+v", standing)
+	}
+	standing, _ = repo.GetUserStanding(1, 1, 0)
+	if standing.Rank != 5 || standing.GapToNextRank != 100 || len(standing.Above) != 0 {
+		t.Errorf("unexpected standing without neighbours: %+v", standing)
+	}
+
+	if _, err := repo.GetUserStanding(1, 42, 5); !errors.Is(err, ErrUserNotRanked) {
+		t.Errorf("expected ErrUserNotRanked, got %v", err)
+	}
+}
+
+func TestSQLiteLeaderboards_GetPage(t *testing.T) {
+	dbPath := "test_leaderboards_page.db"
+
+	// Migrate the test DB to create its schema
+	err := MigrateDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+
+	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create repo: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+
+	// Ranked by score then user ID: 5 (500), 2 (300), 3 (300), 4 (200), 1 (100), 6 (50)
+	scores := map[uint]float64{1: 100, 2: 300, 3: 300, 4: 200, 5: 500, 6: 50}
+	for userID, score := range scores {
+		if err := repo.Update(1, userID, score, "", nil); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+	}
+
+	userIDs := func(page *LeaderboardPage) []uint {
+		ids := []uint{}
+		for _, user := range page.Users {
+			ids = append(ids, user.ID)
+		}
+		return ids
+	}
+
+	page, err := repo.GetPage(1, 1, 3, nil)
+	if err != nil {
+		t.Fatalf("GetPage failed: %v", err)
+	}
+	if !reflect.DeepEqual(userIDs(page), []uint{2, 3, 4}) || page.Users[0].Rank != 2 || page.Total != 6 || !page.More {
+		t.Errorf("unexpected offset page: %+v (users %v)", page, userIDs(page))
+	}
+
+	page, _ = repo.GetPage(1, 4, 3, nil)
+	if !reflect.DeepEqual(userIDs(page), []uint{1, 6}) || page.Users[1].Rank != 6 || page.More {
+		t.Errorf("unexpected last offset page: %+v (users %v)", page, userIDs(page))
+	}
+
+	// Pages read after a cursor continue from its position even if scores change in between
+	page, _ = repo.GetPage(1, 0, 2, nil)
+	if page.Next == nil || page.Next.UserID != 2 {
+		t.Fatalf("expected the next cursor to be the position of user 2, got %+v", page.Next)
+	}
+	repo.Update(1, 4, 600, "", nil) // User 4 moves from rank 4 to rank 1
+	page, err = repo.GetPage(1, 0, 2, page.Next)
+	if err != nil {
+		t.Fatalf("GetPage failed: %v", err)
+	}
+	if !reflect.DeepEqual(userIDs(page), []uint{3, 1}) || page.Users[0].Rank != 4 || !page.More {
+		t.Errorf("unexpected cursor page: %+v (users %v)", page, userIDs(page))
+	}
+
+	page, _ = repo.GetPage(2, 0, 10, nil)
+	if len(page.Users) != 0 || page.Total != 0 || page.More {
+		t.Errorf("expected an empty page for a competition without scores, got %+v", page)
+	}
+}
+
+func TestSQLiteLeaderboards_RankingModes(t *testing.T) {
+	dbPath := "test_leaderboards_ranking.db"
+
+	// Migrate the test DB to create its schema
+	err := MigrateDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+
+	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create repo: %v", err)
+	}
+	competitionsRepo, err := NewSQLiteCompetitionsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create competitions repo: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		competitionsRepo.Close()
+		os.Remove(dbPath)
+	}()
+
+	const (
+		t1 = "2025-07-10T10:00:00.000000000Z"
+		t2 = "2025-07-10T11:00:00.000000000Z"
+		t3 = "2025-07-10T12:00:00.000000000Z"
+	)
+	// Users 1, 2 and 3 score 300: users 1 and 3 reached it first, user 1 with two events
+	updates := []struct {
+		userID    uint
+		score     float64
+		reachedAt string
+	}{
+		{1, 100, t1}, {1, 300, t1}, {2, 300, t2}, {3, 300, t1}, {4, 200, t3}, {5, 100, t1},
+	}
+
+	tests := []struct {
+		mode          common.RankingMode
+		tieBreaker    common.TieBreaker
+		expectedUsers []uint
+		expectedRanks []int
+	}{
+		{common.RankingOrdinal, common.TieBreakerUserID, []uint{1, 2, 3, 4, 5}, []int{1, 2, 3, 4, 5}},
+		{common.RankingStandard, common.TieBreakerNone, []uint{1, 2, 3, 4, 5}, []int{1, 1, 1, 4, 5}},
+		{common.RankingDense, common.TieBreakerNone, []uint{1, 2, 3, 4, 5}, []int{1, 1, 1, 2, 3}},
+		{common.RankingStandard, common.TieBreakerEarliest, []uint{1, 3, 2, 4, 5}, []int{1, 1, 3, 4, 5}},
+		{common.RankingDense, common.TieBreakerEarliest, []uint{1, 3, 2, 4, 5}, []int{1, 1, 2, 3, 4}},
+		{common.RankingOrdinal, common.TieBreakerEarliest, []uint{1, 3, 2, 4, 5}, []int{1, 2, 3, 4, 5}},
+		{common.RankingStandard, common.TieBreakerFewestEvents, []uint{2, 3, 1, 4, 5}, []int{1, 1, 3, 4, 5}},
+		{common.RankingDense, common.TieBreakerUserID, []uint{1, 2, 3, 4, 5}, []int{1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		name := string(tt.mode) + "/" + string(tt.tieBreaker)
+		competitionID, err := competitionsRepo.Create(&common.Competition{Name: name, RankingMode: tt.mode, TieBreaker: tt.tieBreaker})
+		if err != nil {
+			t.Fatalf("%s: failed to create competition: %v", name, err)
+		}
+		for _, u := range updates {
+			if err := repo.Update(competitionID, u.userID, u.score, u.reachedAt, nil); err != nil {
+				t.Fatalf("%s: failed to update user: %v", name, err)
+			}
+		}
+
+		page, err := repo.GetPage(competitionID, 0, -1, nil)
+		if err != nil {
+			t.Fatalf("%s: GetPage failed: %v", name, err)
+		}
+		users, ranks := []uint{}, []int{}
+		for _, user := range page.Users {
+			users = append(users, user.ID)
+			ranks = append(ranks, user.Rank)
+		}
+		if !reflect.DeepEqual(users, tt.expectedUsers) || !reflect.DeepEqual(ranks, tt.expectedRanks) {
+			t.Errorf("%s: expected users %v ranked %v, got %v ranked %v", name, tt.expectedUsers, tt.expectedRanks, users, ranks)
+		}
+
+		// Pages read with cursors and standings rank users the same way
+		page, _ = repo.GetPage(competitionID, 0, 2, nil)
+		for i := 0; page.Next != nil; i++ {
+			page, _ = repo.GetPage(competitionID, 0, 2, page.Next)
+			for j, user := range page.Users {
+				position := (i+1)*2 + j
+				if user.ID != tt.expectedUsers[position] || user.Rank != tt.expectedRanks[position] {
+					t.Errorf("%s: expected user %d ranked %d at position %d of the cursor pages, got %+v", name, tt.expectedUsers[position], tt.expectedRanks[position], position+1, user)
+				}
+			}
+		}
+		for i, userID := range tt.expectedUsers {
+			standing, err := repo.GetUserStanding(competitionID, userID, 1)
+			if err != nil {
+				t.Fatalf("%s: GetUserStanding failed: %v", name, err)
+			}
+			if standing.Rank != tt.expectedRanks[i] {
+				t.Errorf("%s: expected user %d ranked %d, got %d", name, userID, tt.expectedRanks[i], standing.Rank)
+			}
+			if len(standing.Below) == 1 && standing.Below[0].Rank != tt.expectedRanks[i+1] {
+				t.Errorf("%s: expected user below %d ranked %d, got %+v", name, userID, tt.expectedRanks[i+1], standing.Below[0])
+			}
+		}
+
+		topUsers, _ := repo.GetTopN(competitionID, 2)
+		if len(topUsers) != 2 || topUsers[0].ID != tt.expectedUsers[0] || topUsers[1].ID != tt.expectedUsers[1] {
+			t.Errorf("%s: expected top users %v, got %+v", name, tt.expectedUsers[:2], topUsers)
+		}
+	}
+}
+
+func TestSQLiteLeaderboards_UnchangedScoreKeepsTieBreakers(t *testing.T) {
+	dbPath := "test_leaderboards_unchanged_score.db"
+	os.Remove(dbPath)
+	if err := MigrateDatabase(dbPath); err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+	repo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create repo: %v", err)
+	}
+	competitionsRepo, err := NewSQLiteCompetitionsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create competitions repo: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		competitionsRepo.Close()
+		os.Remove(dbPath)
+	}()
+
+	for _, tieBreaker := range []common.TieBreaker{common.TieBreakerEarliest, common.TieBreakerFewestEvents} {
+		competitionID, err := competitionsRepo.Create(&common.Competition{Name: string(tieBreaker), Aggregation: common.AggregationMax,
+			RankingMode: common.RankingOrdinal, TieBreaker: tieBreaker})
+		if err != nil {
+			t.Fatalf("%s: failed to create competition: %v", tieBreaker, err)
+		}
+		// User 1 reaches 100 before user 2, then user 1 scores 50, which leaves their max at 100
+		updates := []struct {
+			userID    uint
+			reachedAt string
+			state     *common.AggregationState
+		}{
+			{1, "2025-07-10T10:00:00.000000000Z", &common.AggregationState{Count: 1, Sum: 100, Best: []float64{100}}},
+			{2, "2025-07-10T11:00:00.000000000Z", &common.AggregationState{Count: 1, Sum: 100, Best: []float64{100}}},
+			{1, "2025-07-10T12:00:00.000000000Z", &common.AggregationState{Count: 2, Sum: 150, Best: []float64{100}}},
+		}
+		for _, u := range updates {
+			if err := repo.Update(competitionID, u.userID, 100, u.reachedAt, u.state); err != nil {
+				t.Fatalf("%s: failed to update user: %v", tieBreaker, err)
+			}
+		}
+
+		page, err := repo.GetPage(competitionID, 0, -1, nil)
+		if err != nil {
+			t.Fatalf("%s: GetPage failed: %v", tieBreaker, err)
+		}
+		users := []uint{}
+		for _, user := range page.Users {
+			users = append(users, user.ID)
+		}
+		if !reflect.DeepEqual(users, []uint{1, 2}) {
+			t.Errorf("%s: expected user 1 to stay ahead of user 2, got %v", tieBreaker, users)
+		}
+		states, _ := repo.GetAggregationStates()
+		if state := states[competitionID][1]; state == nil || state.Count != 2 || state.Sum != 150 {
+			t.Errorf("%s: expected the aggregation state to be updated, got %+v", tieBreaker, state)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package repositories
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// migrationFiles holds the schema migrations, named <version>_<name>.sql. Versions start at 1 and have no gaps,
+// a schema change is always a new migration, applied migrations are never edited.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is a forward change of the schema. Its statements are separated by semicolons.
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// addColumnStatement matches the statements adding a column, which are skipped if the column already exists
+var addColumnStatement = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(\w+)\s+ADD\s+COLUMN\s+(\w+)`)
+
+// MigrateDatabase opens the SQLite DB at dbPath, creating it if needed, and applies the migrations it lacks
+func MigrateDatabase(dbPath string) error {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return Migrate(db)
+}
+
+// Migrate applies the migrations a database lacks, in order, each in its own transaction along with the record
+// of its version in the schema_version table. It returns an error if the database has a newer schema than the
+// migrations.
+//
+// Databases created by the init_db.sh script that preceded the migrations have no schema_version table. Every
+// migration is safe to apply to them: tables and indexes are only created if they do not exist, and columns are
+// only added if they do not exist.
+func Migrate(db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)`); err != nil {
+		return fmt.Errorf("error creating schema_version table: %w", err)
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if latest := len(migrations); current > latest {
+		return fmt.Errorf("database schema version %d is newer than the latest migration %d", current, latest)
+	}
+	for _, m := range migrations[current:] {
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("error applying migration %d (%s): %w", m.version, m.name, err)
+		}
+		fmt.Printf("Applied migration %d (%s)\n", m.version, m.name)
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last migration applied to a database, 0 if none has been applied
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// applyMigration runs the statements of a migration and records its version in a single transaction
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.statements {
+		if match := addColumnStatement.FindStringSubmatch(statement); match != nil {
+			var exists bool
+			if err := tx.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, match[1], match[2]).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadMigrations reads the embedded migrations, ordered by version
+func loadMigrations() ([]migration, error) {
+	files, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]migration, 0, len(files))
+	for _, file := range files {
+		base := strings.TrimSuffix(file.Name(), ".sql")
+		versionStr, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !found || err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q, expected <version>_<name>.sql", file.Name())
+		}
+		content, err := migrationFiles.ReadFile(path.Join("migrations", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, statements: splitStatements(string(content))})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %d (%s) is out of sequence, expected version %d", m.version, m.name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// splitStatements splits a migration into its statements, leaving out the comment lines
+func splitStatements(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	var statements []string
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS Leaderboards (
+    competition_id INTEGER,
+    user_id INTEGER,
+    score REAL,
+    PRIMARY KEY (competition_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS BetEvents (
+    event_id INTEGER PRIMARY KEY,
+    user_id INTEGER,
+    amount REAL
+);
+
+CREATE TABLE IF NOT EXISTS Competitions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    scorerule TEXT,
+    starttime TEXT,
+    endtime TEXT,
+    rewards TEXT
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS FinishedCompetitions (
+    competition_id INTEGER PRIMARY KEY,
+    finished_at TEXT,
+    published INTEGER DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS CompetitionResults (
+    competition_id INTEGER,
+    user_id INTEGER,
+    rank INTEGER,
+    score REAL,
+    reward INTEGER,
+    PRIMARY KEY (competition_id, user_id)
+);
@@ -0,0 +1,2 @@
+-- Walks the ranking of a competition
+CREATE INDEX IF NOT EXISTS LeaderboardsByScore ON Leaderboards (competition_id, score DESC, user_id);
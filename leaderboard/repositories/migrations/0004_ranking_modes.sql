@@ -0,0 +1,8 @@
+ALTER TABLE Leaderboards ADD COLUMN reached_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE Leaderboards ADD COLUMN events INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE Competitions ADD COLUMN rankingmode TEXT NOT NULL DEFAULT 'ordinal';
+ALTER TABLE Competitions ADD COLUMN tiebreaker TEXT NOT NULL DEFAULT 'user_id';
+
+-- Walk the ranking of a competition for each tie-breaker
+CREATE INDEX IF NOT EXISTS LeaderboardsByScoreReachedAt ON Leaderboards (competition_id, score DESC, reached_at, user_id);
+CREATE INDEX IF NOT EXISTS LeaderboardsByScoreEvents ON Leaderboards (competition_id, score DESC, events, user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE Leaderboards ADD COLUMN aggregation_state TEXT NOT NULL DEFAULT '';
+ALTER TABLE Competitions ADD COLUMN aggregation TEXT NOT NULL DEFAULT 'sum';
+ALTER TABLE Competitions ADD COLUMN bestn INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Loss events used to be dropped before the rules were evaluated, existing competitions keep skipping them.
+-- New competitions always store whether they skip losses.
+ALTER TABLE Competitions ADD COLUMN skiplosses INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS UserActivity (
+    competition_id INTEGER,
+    user_id INTEGER,
+    events INTEGER NOT NULL DEFAULT 0,
+    bets INTEGER NOT NULL DEFAULT 0,
+    first_seen TEXT NOT NULL DEFAULT '',
+    last_seen TEXT NOT NULL DEFAULT '',
+    last_bet TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (competition_id, user_id)
+);
@@ -0,0 +1,13 @@
+-- Events stored before the full payload was kept have an empty event_type and are not replayed
+ALTER TABLE BetEvents ADD COLUMN event_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE BetEvents ADD COLUMN currency TEXT NOT NULL DEFAULT '';
+ALTER TABLE BetEvents ADD COLUMN exchange_rate REAL NOT NULL DEFAULT 0;
+ALTER TABLE BetEvents ADD COLUMN game TEXT NOT NULL DEFAULT '';
+ALTER TABLE BetEvents ADD COLUMN distributor TEXT NOT NULL DEFAULT '';
+ALTER TABLE BetEvents ADD COLUMN studio TEXT NOT NULL DEFAULT '';
+ALTER TABLE BetEvents ADD COLUMN timestamp TEXT NOT NULL DEFAULT '';
+ALTER TABLE BetEvents ADD COLUMN occurred_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE BetEvents ADD COLUMN received_at TEXT NOT NULL DEFAULT '';
+
+-- Walks the bet events in the order they happened
+CREATE INDEX IF NOT EXISTS BetEventsByOccurredAt ON BetEvents (occurred_at, event_id);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS Jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type TEXT NOT NULL,
+    competition_id INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    total_events INTEGER NOT NULL DEFAULT 0,
+    processed_events INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+-- Finds the last received bet event
+CREATE INDEX IF NOT EXISTS BetEventsByReceivedAt ON BetEvents (received_at);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS Recalculations (
+    competition_id INTEGER PRIMARY KEY,
+    job_id INTEGER NOT NULL,
+    score_rule TEXT NOT NULL,
+    received_until TEXT NOT NULL DEFAULT ''
+);
+
+-- The shadow tables hold the recalculated scores and user histories until they replace the live ones
+CREATE TABLE IF NOT EXISTS ShadowLeaderboards (
+    competition_id INTEGER,
+    user_id INTEGER,
+    score REAL,
+    reached_at TEXT NOT NULL DEFAULT '',
+    events INTEGER NOT NULL DEFAULT 0,
+    aggregation_state TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (competition_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS ShadowUserActivity (
+    competition_id INTEGER,
+    user_id INTEGER,
+    events INTEGER NOT NULL DEFAULT 0,
+    bets INTEGER NOT NULL DEFAULT 0,
+    first_seen TEXT NOT NULL DEFAULT '',
+    last_seen TEXT NOT NULL DEFAULT '',
+    last_bet TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (competition_id, user_id)
+);
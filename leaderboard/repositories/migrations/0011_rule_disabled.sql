@@ -0,0 +1,2 @@
+-- Competitions whose score rule keeps failing are disabled, they stay disabled across restarts until enabled again.
+ALTER TABLE Competitions ADD COLUMN rule_disabled INTEGER NOT NULL DEFAULT 0;
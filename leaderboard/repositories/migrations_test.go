@@ -0,0 +1,168 @@
+package repositories
+
+import (
+	"common"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMigrate_EmptyDatabase(t *testing.T) {
+	dbPath := "test_migrations.db"
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+
+	if err := MigrateDatabase(dbPath); err != nil {
+		t.Fatalf("failed to migrate an empty DB: %v", err)
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	version, err := SchemaVersion(db)
+	db.Close()
+	if err != nil || version != len(migrations) {
+		t.Fatalf("expected schema version %d, got %d, %v", len(migrations), version, err)
+	}
+
+	// Every repository works against the migrated schema
+	competitionsRepo, _ := NewSQLiteCompetitionsRepository(dbPath)
+	defer competitionsRepo.Close()
+	competitionID, err := competitionsRepo.Create(&common.Competition{Name: "Migrated", ScoreRule: "amount", Aggregation: common.AggregationMax})
+	if err != nil {
+		t.Fatalf("failed to create competition: %v", err)
+	}
+	if comp, err := competitionsRepo.GetByID(competitionID); err != nil || comp.SkipLosses || comp.Aggregation != common.AggregationMax {
+		t.Errorf("unexpected competition: %+v, %v", comp, err)
+	}
+
+	leaderboardsRepo, _ := NewSQLiteLeaderboardsRepository(dbPath)
+	defer leaderboardsRepo.Close()
+	event := &common.BetEvent{EventID: 1, EventType: common.EventTypeBet, UserID: 7, Amount: 10, ExchangeRate: 1.0, Timestamp: "2024-06-01T00:00:00Z"}
+	if err := leaderboardsRepo.StoreBetEvent(event); err != nil {
+		t.Fatalf("failed to store bet event: %v", err)
+	}
+	if err := leaderboardsRepo.Update(competitionID, 7, 10, "2024-06-01T00:00:00.000000000Z", &common.AggregationState{Count: 1, Sum: 10}); err != nil {
+		t.Fatalf("failed to update score: %v", err)
+	}
+	if err := leaderboardsRepo.UpdateActivity(competitionID, 7, &common.UserActivity{Events: 1, Bets: 1}); err != nil {
+		t.Fatalf("failed to update activity: %v", err)
+	}
+	if page, err := leaderboardsRepo.GetPage(competitionID, 0, 10, nil); err != nil || len(page.Users) != 1 {
+		t.Errorf("unexpected leaderboard page: %+v, %v", page, err)
+	}
+	if last, err := leaderboardsRepo.LastReceivedAt(); err != nil || last.IsZero() {
+		t.Errorf("expected the received bet event to be found, got %v, %v", last, err)
+	}
+
+	jobsRepo, _ := NewSQLiteJobsRepository(dbPath)
+	defer jobsRepo.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	jobID, err := jobsRepo.Create(&common.Job{Type: common.JobTypeRecalculate, CompetitionID: competitionID, Status: common.JobStatusPending, CreatedAt: now, UpdatedAt: now})
+	if err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	recalculationsRepo, _ := NewSQLiteRecalculationsRepository(dbPath)
+	defer recalculationsRepo.Close()
+	recalculation := &Recalculation{CompetitionID: competitionID, JobID: jobID, ScoreRule: "amount * 2"}
+	if err := recalculationsRepo.Create(recalculation); err != nil {
+		t.Fatalf("failed to create recalculation: %v", err)
+	}
+	if err := recalculationsRepo.SaveShadowScores(recalculation, []*CompetitionScore{{UserID: 7, Score: 20, Events: 1}}, nil); err != nil {
+		t.Fatalf("failed to save shadow scores: %v", err)
+	}
+	if _, recalculated, err := recalculationsRepo.GetRankings(competitionID); err != nil || len(recalculated) != 1 {
+		t.Errorf("unexpected recalculated ranking: %+v, %v", recalculated, err)
+	}
+
+	resultsRepo, _ := NewSQLiteResultsRepository(dbPath)
+	defer resultsRepo.Close()
+	results := []*common.CompetitionResult{{CompetitionID: competitionID, UserID: 7, Rank: 1, Score: 10, Reward: 100}}
+	if err := resultsRepo.SaveResults(competitionID, now, results); err != nil {
+		t.Fatalf("failed to save results: %v", err)
+	}
+	if finished, err := resultsRepo.GetFinished(); err != nil || len(finished) != 1 {
+		t.Errorf("unexpected finished competitions: %+v, %v", finished, err)
+	}
+
+	if err := competitionsRepo.Delete(competitionID); err != nil {
+		t.Errorf("failed to delete competition: %v", err)
+	}
+}
+
+func TestMigrate_AppliesOnlyMissingMigrations(t *testing.T) {
+	dbPath := "test_migrations_missing.db"
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	if err := Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	latest, _ := SchemaVersion(db)
+
+	// Migrating an up to date database changes nothing
+	if err := Migrate(db); err != nil {
+		t.Fatalf("failed to migrate an up to date DB: %v", err)
+	}
+	var applied int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_version`).Scan(&applied); err != nil || applied != latest {
+		t.Errorf("expected each migration to be recorded once, got %d, %v", applied, err)
+	}
+
+	// A database with a newer schema is not migrated
+	if _, err := db.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'future', '')`, latest+1); err != nil {
+		t.Fatalf("failed to record a future migration: %v", err)
+	}
+	if err := Migrate(db); err == nil {
+		t.Errorf("expected an error for a database with a newer schema")
+	}
+}
+
+func TestMigrate_InitScriptDatabase(t *testing.T) {
+	dbPath := "test_migrations_legacy.db"
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+
+	// A database created by init_db.sh has the latest tables and columns, without a schema_version table
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+	if err := Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if _, err := db.Exec(`DROP TABLE schema_version; INSERT INTO Competitions (name, scorerule, skiplosses) VALUES ('Existing', 'amount', 0)`); err != nil {
+		t.Fatalf("failed to recreate the legacy schema: %v", err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("failed to migrate a database created by init_db.sh: %v", err)
+	}
+	migrations, _ := loadMigrations()
+	if version, err := SchemaVersion(db); err != nil || version != len(migrations) {
+		t.Errorf("expected schema version %d, got %d, %v", len(migrations), version, err)
+	}
+	var skipLosses bool
+	if err := db.QueryRow(`SELECT skiplosses FROM Competitions WHERE name = 'Existing'`).Scan(&skipLosses); err != nil || skipLosses {
+		t.Errorf("expected the existing competition to be left untouched, got %v, %v", skipLosses, err)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements("-- A comment; with a semicolon\nCREATE TABLE A (id INTEGER);\n\nALTER TABLE A ADD COLUMN b TEXT NOT NULL DEFAULT '';\n")
+	if len(statements) != 2 || statements[0] != "CREATE TABLE A (id INTEGER)" || !addColumnStatement.MatchString(statements[1]) {
+		t.Errorf("unexpected statements: %q", statements)
+	}
+}
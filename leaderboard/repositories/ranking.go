@@ -0,0 +1,281 @@
+package repositories
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"common"
+)
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// rankingColumn is a column the users of a competition are ordered by
+type rankingColumn struct {
+	name  string
+	desc  bool
+	value func(key LeaderboardCursor) any
+}
+
+var (
+	scoreColumn     = rankingColumn{name: "score", desc: true, value: func(key LeaderboardCursor) any { return key.Score }}
+	reachedAtColumn = rankingColumn{name: "reached_at", value: func(key LeaderboardCursor) any { return key.ReachedAt }}
+	eventsColumn    = rankingColumn{name: "events", value: func(key LeaderboardCursor) any { return key.Events }}
+	userIDColumn    = rankingColumn{name: "user_id", value: func(key LeaderboardCursor) any { return key.UserID }}
+)
+
+// before returns the operator comparing the column of a user ordered before a value
+func (c rankingColumn) before(inclusive bool) string {
+	operator := "<"
+	if c.desc {
+		operator = ">"
+	}
+	if inclusive {
+		operator += "="
+	}
+	return operator
+}
+
+// ranking is how the users of a competition are ordered and ranked
+type ranking struct {
+	mode       common.RankingMode
+	tieBreaker common.TieBreaker
+}
+
+// rankingRow is a user read from the Leaderboards table with their position in the ranking
+type rankingRow struct {
+	user *common.RankedUser
+	key  LeaderboardCursor
+}
+
+// rankingRowColumns are the columns read by queryRankingRows
+const rankingRowColumns = `user_id, score, reached_at, events`
+
+// readRanking reads the ranking mode and tie-breaker of a competition.
+// Competitions that do not exist or do not set them are ranked with the defaults.
+func readRanking(q queryer, competitionID uint) (ranking, error) {
+	r := ranking{}
+	err := q.QueryRow(`SELECT rankingmode, tiebreaker FROM Competitions WHERE id = ?`, competitionID).Scan(&r.mode, &r.tieBreaker)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ranking{}, err
+	}
+	if r.mode == "" {
+		r.mode = common.DefaultRankingMode
+	}
+	if r.tieBreaker == "" {
+		r.tieBreaker = common.DefaultTieBreaker
+	}
+	return r, nil
+}
+
+// tieColumns are the columns whose values users share when they are tied
+func (r ranking) tieColumns() []rankingColumn {
+	switch r.tieBreaker {
+	case common.TieBreakerNone:
+		return []rankingColumn{scoreColumn}
+	case common.TieBreakerEarliest:
+		return []rankingColumn{scoreColumn, reachedAtColumn}
+	case common.TieBreakerFewestEvents:
+		return []rankingColumn{scoreColumn, eventsColumn}
+	default:
+		return []rankingColumn{scoreColumn, userIDColumn}
+	}
+}
+
+// orderColumns are the columns users are ordered by. Tied users are ordered by user ID,
+// so every user has a distinct position even when they share a rank.
+func (r ranking) orderColumns() []rankingColumn {
+	columns := r.tieColumns()
+	if columns[len(columns)-1].name != userIDColumn.name {
+		columns = append(columns, userIDColumn)
+	}
+	return columns
+}
+
+// orderBy returns the ORDER BY clause of the ranking, from the last user if reverse is true
+func (r ranking) orderBy(reverse bool) string {
+	terms := []string{}
+	for _, column := range r.orderColumns() {
+		direction := "ASC"
+		if column.desc != reverse {
+			direction = "DESC"
+		}
+		terms = append(terms, column.name+" "+direction)
+	}
+	return strings.Join(terms, ", ")
+}
+
+// beforeCondition returns the condition of the users ordered before key by the columns,
+// including the users equal to key if inclusive is true
+func beforeCondition(columns []rankingColumn, key LeaderboardCursor, inclusive bool) (string, []any) {
+	last := columns[len(columns)-1]
+	condition := fmt.Sprintf("%s %s ?", last.name, last.before(inclusive))
+	args := []any{last.value(key)}
+	for i := len(columns) - 2; i >= 0; i-- {
+		column := columns[i]
+		condition = fmt.Sprintf("(%s %s ? OR (%s = ? AND %s))", column.name, column.before(false), column.name, condition)
+		args = append([]any{column.value(key), column.value(key)}, args...)
+	}
+	// The bound on the score lets the query walk the index from the key instead of the whole competition
+	return "score >= ? AND " + condition, append([]any{key.Score}, args...)
+}
+
+// afterCondition returns the condition of the users ordered after key by the columns
+func afterCondition(columns []rankingColumn, key LeaderboardCursor) (string, []any) {
+	condition, args := beforeCondition(columns, key, true)
+	return "score <= ? AND NOT (" + condition + ")", append([]any{key.Score}, args...)
+}
+
+// rankOf returns the rank and the 1-based position in the ranking of the user at key
+func (r ranking) rankOf(q queryer, competitionID uint, key LeaderboardCursor) (rank, position int, err error) {
+	condition, args := beforeCondition(r.orderColumns(), key, false)
+	err = q.QueryRow(`SELECT COUNT(*) FROM Leaderboards WHERE competition_id = ? AND `+condition, append([]any{competitionID}, args...)...).Scan(&position)
+	if err != nil {
+		return 0, 0, err
+	}
+	position++
+	if r.mode == common.RankingOrdinal || r.tieBreaker == common.TieBreakerUserID {
+		return position, position, nil // Users are never tied
+	}
+
+	columns := r.tieColumns()
+	condition, args = beforeCondition(columns, key, false)
+	args = append([]any{competitionID}, args...)
+	if r.mode == common.RankingDense {
+		names := make([]string, len(columns))
+		for i, column := range columns {
+			names[i] = column.name
+		}
+		err = q.QueryRow(`SELECT COUNT(*) FROM (SELECT DISTINCT `+strings.Join(names, ", ")+` FROM Leaderboards WHERE competition_id = ? AND `+condition+`)`, args...).Scan(&rank)
+	} else {
+		err = q.QueryRow(`SELECT COUNT(*) FROM Leaderboards WHERE competition_id = ? AND `+condition, args...).Scan(&rank)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return rank + 1, position, nil
+}
+
+// RankAmong returns the rank of the user at key among the users at keys, which may include key, in a competition
+// with the given ranking mode and tie-breaker. Users are ranked the same way as by the queries of the repository,
+// for rankings kept in memory.
+func RankAmong(mode common.RankingMode, tieBreaker common.TieBreaker, key LeaderboardCursor, keys []LeaderboardCursor) int {
+	r := ranking{mode: mode, tieBreaker: tieBreaker}
+	if r.mode == "" {
+		r.mode = common.DefaultRankingMode
+	}
+	if r.tieBreaker == "" {
+		r.tieBreaker = common.DefaultTieBreaker
+	}
+	columns := r.tieColumns()
+	if r.mode == common.RankingOrdinal || r.tieBreaker == common.TieBreakerUserID {
+		columns = r.orderColumns() // Users are never tied
+	}
+
+	rank := 1
+	ahead := map[string]bool{} // Distinct tie values of the users ahead, for the dense ranking
+	for _, other := range keys {
+		if !orderedBefore(columns, other, key) {
+			continue
+		}
+		if r.mode != common.RankingDense {
+			rank++
+			continue
+		}
+		values := make([]any, len(columns))
+		for i, column := range columns {
+			values[i] = column.value(other)
+		}
+		if tie := fmt.Sprint(values...); !ahead[tie] {
+			ahead[tie] = true
+			rank++
+		}
+	}
+	return rank
+}
+
+// orderedBefore reports whether the user at a is ordered before the user at b by the columns
+func orderedBefore(columns []rankingColumn, a, b LeaderboardCursor) bool {
+	for _, column := range columns {
+		comparison := compareValues(column.value(a), column.value(b))
+		if comparison == 0 {
+			continue
+		}
+		return (comparison < 0) != column.desc
+	}
+	return false
+}
+
+// compareValues compares two values of a ranking column, which have the same type
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case string:
+		return cmp.Compare(a, b.(string))
+	case int:
+		return cmp.Compare(a, b.(int))
+	case uint:
+		return cmp.Compare(a, b.(uint))
+	}
+	panic(fmt.Sprintf("unexpected ranking value of type %T", a))
+}
+
+// tied reports whether the users at a and b share a rank
+func (r ranking) tied(a, b LeaderboardCursor) bool {
+	if r.mode == common.RankingOrdinal {
+		return false
+	}
+	for _, column := range r.tieColumns() {
+		if column.value(a) != column.value(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// rankRows ranks consecutive rows of the ranking, the first of which has the given rank and position
+func (r ranking) rankRows(rows []rankingRow, rank, position int) []*common.RankedUser {
+	users := make([]*common.RankedUser, 0, len(rows))
+	for i, row := range rows {
+		if i > 0 {
+			position++
+			if !r.tied(rows[i-1].key, row.key) {
+				if r.mode == common.RankingDense {
+					rank++
+				} else {
+					rank = position
+				}
+			}
+		}
+		row.user.Rank = rank
+		users = append(users, row.user)
+	}
+	return users
+}
+
+// queryRankingRows reads the users returned by a query selecting rankingRowColumns
+func queryRankingRows(q queryer, query string, args ...any) ([]rankingRow, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []rankingRow
+	for rows.Next() {
+		row := rankingRow{user: &common.RankedUser{}}
+		if err := rows.Scan(&row.key.UserID, &row.key.Score, &row.key.ReachedAt, &row.key.Events); err != nil {
+			return nil, err
+		}
+		row.user.ID = row.key.UserID
+		row.user.Score = row.key.Score
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
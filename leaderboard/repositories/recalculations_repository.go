@@ -0,0 +1,278 @@
+package repositories
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"common"
+)
+
+// RecalculationsRepository defines the interface for rebuilding the scores of competitions in shadow tables,
+// where they can be compared with the live scores before they replace them
+// This allows for different implementations (e.g., in-memory, database, etc.)
+type RecalculationsRepository interface {
+	Create(recalculation *Recalculation) error
+	Get(competitionID uint) (*Recalculation, error)
+	SaveShadowScores(recalculation *Recalculation, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error
+	GetShadowScores(competitionID uint) ([]*CompetitionScore, map[uint]*common.UserActivity, error)
+	GetRankings(competitionID uint) (live, recalculated []*common.RankedUser, err error)
+	Apply(competitionID uint, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error
+	Discard(competitionID uint) error
+	Close()
+}
+
+var (
+	// ErrRecalculationNotFound is returned when a competition has no recalculation, or a different one
+	ErrRecalculationNotFound = errors.New("recalculation not found")
+	// ErrRecalculationExists is returned when a recalculation is created for a competition that already has one
+	ErrRecalculationExists = errors.New("competition already has a recalculation")
+	// ErrCompetitionFinished is returned when the scores of a finished competition would be changed
+	ErrCompetitionFinished = errors.New("competition is finished")
+)
+
+// Recalculation is a rebuild of the scores of a competition with a score rule. Its scores are kept in the
+// shadow tables until it is applied or discarded.
+type Recalculation struct {
+	CompetitionID uint
+	JobID         uint      // Job rebuilding the scores
+	ScoreRule     string    // Rule the scores are rebuilt with, the rule of the competition once it is applied
+	ReceivedUntil time.Time // The shadow scores include the bet events received until then, zero if there are none
+}
+
+// SQLiteRecalculations implements RecalculationsRepository using SQLite
+type SQLiteRecalculations struct {
+	db *sql.DB
+}
+
+// NewSQLiteRecalculationsRepository opens (or creates) a SQLite DB
+func NewSQLiteRecalculationsRepository(dbPath string) (*SQLiteRecalculations, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteRecalculations{db: db}, nil
+}
+
+// Create stores a new recalculation without scores, returning ErrRecalculationExists if the competition already
+// has one
+func (r *SQLiteRecalculations) Create(recalculation *Recalculation) error {
+	res, err := r.db.Exec(`INSERT INTO Recalculations (competition_id, job_id, score_rule, received_until) VALUES (?, ?, ?, '') ON CONFLICT(competition_id) DO NOTHING`,
+		recalculation.CompetitionID, recalculation.JobID, recalculation.ScoreRule)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrRecalculationExists
+	}
+	return nil
+}
+
+// Get retrieves the recalculation of a competition, returning ErrRecalculationNotFound if it has none
+func (r *SQLiteRecalculations) Get(competitionID uint) (*Recalculation, error) {
+	return getRecalculation(r.db, competitionID)
+}
+
+// getRecalculation reads the recalculation of a competition, see Get
+func getRecalculation(q queryer, competitionID uint) (*Recalculation, error) {
+	recalculation := &Recalculation{}
+	var receivedUntil string
+	err := q.QueryRow(`SELECT competition_id, job_id, score_rule, received_until FROM Recalculations WHERE competition_id = ?`, competitionID).
+		Scan(&recalculation.CompetitionID, &recalculation.JobID, &recalculation.ScoreRule, &receivedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecalculationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if receivedUntil != "" {
+		if recalculation.ReceivedUntil, err = time.Parse(betEventTimeLayout, receivedUntil); err != nil {
+			return nil, err
+		}
+	}
+	return recalculation, nil
+}
+
+// SaveShadowScores replaces the shadow scores and user histories of a recalculation, along with the time the
+// bet events they include were received until. It returns ErrRecalculationNotFound if the recalculation has been
+// discarded or replaced by another one.
+func (r *SQLiteRecalculations) SaveShadowScores(recalculation *Recalculation, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	receivedUntil := ""
+	if !recalculation.ReceivedUntil.IsZero() {
+		receivedUntil = recalculation.ReceivedUntil.UTC().Format(betEventTimeLayout)
+	}
+	res, err := tx.Exec(`UPDATE Recalculations SET received_until = ? WHERE competition_id = ? AND job_id = ?`,
+		receivedUntil, recalculation.CompetitionID, recalculation.JobID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrRecalculationNotFound
+	}
+	if err := replaceScores(tx, "ShadowLeaderboards", "ShadowUserActivity", recalculation.CompetitionID, scores, activities); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetShadowScores retrieves the shadow scores and user histories of a competition, ordered by user ID
+func (r *SQLiteRecalculations) GetShadowScores(competitionID uint) ([]*CompetitionScore, map[uint]*common.UserActivity, error) {
+	rows, err := r.db.Query(`SELECT user_id, score, reached_at, events, aggregation_state FROM ShadowLeaderboards WHERE competition_id = ? ORDER BY user_id`, competitionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var scores []*CompetitionScore
+	for rows.Next() {
+		score := &CompetitionScore{}
+		var state string
+		if err := rows.Scan(&score.UserID, &score.Score, &score.ReachedAt, &score.Events, &state); err != nil {
+			return nil, nil, err
+		}
+		if state != "" {
+			if err := json.Unmarshal([]byte(state), &score.State); err != nil {
+				return nil, nil, err
+			}
+		}
+		scores = append(scores, score)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	activityRows, err := r.db.Query(`SELECT user_id, events, bets, first_seen, last_seen, last_bet FROM ShadowUserActivity WHERE competition_id = ?`, competitionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer activityRows.Close()
+	activities := make(map[uint]*common.UserActivity)
+	for activityRows.Next() {
+		var userID uint
+		activity := &common.UserActivity{}
+		if err := activityRows.Scan(&userID, &activity.Events, &activity.Bets, &activity.FirstSeen, &activity.LastSeen, &activity.LastBet); err != nil {
+			return nil, nil, err
+		}
+		activities[userID] = activity
+	}
+	return scores, activities, activityRows.Err()
+}
+
+// GetRankings ranks every user of a competition by their live scores and by their shadow scores, with the
+// competition's ranking mode and tie-breaker. Both rankings are read from the same snapshot.
+func (r *SQLiteRecalculations) GetRankings(competitionID uint) (live, recalculated []*common.RankedUser, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback() // Read-only, the transaction only gives a consistent view of the scores
+
+	rank, err := readRanking(tx, competitionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	read := func(table string) ([]*common.RankedUser, error) {
+		rows, err := queryRankingRows(tx, `SELECT `+rankingRowColumns+` FROM `+table+` WHERE competition_id = ? ORDER BY `+rank.orderBy(false), competitionID)
+		if err != nil {
+			return nil, err
+		}
+		return rank.rankRows(rows, 1, 1), nil
+	}
+	if live, err = read("Leaderboards"); err != nil {
+		return nil, nil, err
+	}
+	if recalculated, err = read("ShadowLeaderboards"); err != nil {
+		return nil, nil, err
+	}
+	return live, recalculated, nil
+}
+
+// Apply replaces the live scores and user histories of a competition with the final scores of its recalculation,
+// sets the competition's score rule to the rule of the recalculation, enabling it if the old rule was disabled, and
+// removes the recalculation, in a single transaction. It returns ErrRecalculationNotFound if the competition has no recalculation and
+// ErrCompetitionFinished if it is finished, as the scores of finished competitions are frozen.
+func (r *SQLiteRecalculations) Apply(competitionID uint, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	recalculation, err := getRecalculation(tx, competitionID)
+	if err != nil {
+		return err
+	}
+	var finished bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM FinishedCompetitions WHERE competition_id = ?)`, competitionID).Scan(&finished); err != nil {
+		return err
+	}
+	if finished {
+		return ErrCompetitionFinished
+	}
+	res, err := tx.Exec(`UPDATE Competitions SET scorerule = ?, rule_disabled = 0 WHERE id = ?`, recalculation.ScoreRule, competitionID)
+	if err != nil {
+		return err
+	}
+	if err := checkCompetitionAffected(res); err != nil {
+		return err
+	}
+	if err := replaceScores(tx, "Leaderboards", "UserActivity", competitionID, scores, activities); err != nil {
+		return err
+	}
+	if err := deleteRecalculation(tx, competitionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Discard removes the recalculation of a competition and its shadow scores, returning ErrRecalculationNotFound
+// if the competition has none
+func (r *SQLiteRecalculations) Discard(competitionID uint) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := getRecalculation(tx, competitionID); err != nil {
+		return err
+	}
+	if err := deleteRecalculation(tx, competitionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteRecalculation removes the recalculation of a competition and its shadow scores, if it has one
+func deleteRecalculation(e execer, competitionID uint) error {
+	for _, table := range []string{"ShadowLeaderboards", "ShadowUserActivity"} {
+		if _, err := e.Exec(`DELETE FROM `+table+` WHERE competition_id = ?`, competitionID); err != nil {
+			return err
+		}
+	}
+	_, err := e.Exec(`DELETE FROM Recalculations WHERE competition_id = ?`, competitionID)
+	return err
+}
+
+// Close closes the SQLite database connection
+func (r *SQLiteRecalculations) Close() {
+	if r.db != nil {
+		r.db.Close()
+	}
+}
@@ -0,0 +1,129 @@
+package repositories
+
+import (
+	"common"
+	"sync"
+)
+
+// MockRecalculations is a mock implementation of RecalculationsRepository for testing.
+// It is safe for concurrent use, as shadow scores are saved by jobs running in the background.
+type MockRecalculations struct {
+	mutex            sync.Mutex
+	Recalculations   map[uint]*Recalculation
+	ShadowScores     map[uint][]*CompetitionScore
+	ShadowActivities map[uint]map[uint]*common.UserActivity
+	Finished         map[uint]bool // Competitions Apply returns ErrCompetitionFinished for
+
+	LiveRankings      map[uint][]*common.RankedUser // Live rankings returned by GetRankings
+	ShadowRankings    map[uint][]*common.RankedUser // Recalculated rankings returned by GetRankings
+	AppliedScores     map[uint][]*CompetitionScore
+	AppliedActivities map[uint]map[uint]*common.UserActivity
+
+	SaveErr  error
+	ApplyErr error
+}
+
+// Create stores a copy of the recalculation, or returns ErrRecalculationExists
+func (m *MockRecalculations) Create(recalculation *Recalculation) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.Recalculations[recalculation.CompetitionID]; exists {
+		return ErrRecalculationExists
+	}
+	if m.Recalculations == nil {
+		m.Recalculations = make(map[uint]*Recalculation)
+	}
+	stored := *recalculation
+	m.Recalculations[stored.CompetitionID] = &stored
+	return nil
+}
+
+// Get returns a copy of the recalculation of a competition, or ErrRecalculationNotFound
+func (m *MockRecalculations) Get(competitionID uint) (*Recalculation, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	recalculation, exists := m.Recalculations[competitionID]
+	if !exists {
+		return nil, ErrRecalculationNotFound
+	}
+	stored := *recalculation
+	return &stored, nil
+}
+
+// SaveShadowScores stores the shadow scores of a recalculation in the ShadowScores and ShadowActivities maps
+func (m *MockRecalculations) SaveShadowScores(recalculation *Recalculation, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.SaveErr != nil {
+		return m.SaveErr
+	}
+	stored, exists := m.Recalculations[recalculation.CompetitionID]
+	if !exists || stored.JobID != recalculation.JobID {
+		return ErrRecalculationNotFound
+	}
+	stored.ReceivedUntil = recalculation.ReceivedUntil
+	if m.ShadowScores == nil {
+		m.ShadowScores = make(map[uint][]*CompetitionScore)
+		m.ShadowActivities = make(map[uint]map[uint]*common.UserActivity)
+	}
+	m.ShadowScores[recalculation.CompetitionID] = scores
+	m.ShadowActivities[recalculation.CompetitionID] = activities
+	return nil
+}
+
+// GetShadowScores returns the shadow scores stored for a competition
+func (m *MockRecalculations) GetShadowScores(competitionID uint) ([]*CompetitionScore, map[uint]*common.UserActivity, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.ShadowScores[competitionID], m.ShadowActivities[competitionID], nil
+}
+
+// GetRankings returns the LiveRankings and ShadowRankings of a competition
+func (m *MockRecalculations) GetRankings(competitionID uint) ([]*common.RankedUser, []*common.RankedUser, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.LiveRankings[competitionID], m.ShadowRankings[competitionID], nil
+}
+
+// Apply stores the final scores in the AppliedScores and AppliedActivities maps and removes the recalculation
+func (m *MockRecalculations) Apply(competitionID uint, scores []*CompetitionScore, activities map[uint]*common.UserActivity) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.ApplyErr != nil {
+		return m.ApplyErr
+	}
+	if _, exists := m.Recalculations[competitionID]; !exists {
+		return ErrRecalculationNotFound
+	}
+	if m.Finished[competitionID] {
+		return ErrCompetitionFinished
+	}
+	if m.AppliedScores == nil {
+		m.AppliedScores = make(map[uint][]*CompetitionScore)
+		m.AppliedActivities = make(map[uint]map[uint]*common.UserActivity)
+	}
+	m.AppliedScores[competitionID] = scores
+	m.AppliedActivities[competitionID] = activities
+	m.discard(competitionID)
+	return nil
+}
+
+// Discard removes the recalculation of a competition and its shadow scores, or returns ErrRecalculationNotFound
+func (m *MockRecalculations) Discard(competitionID uint) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.Recalculations[competitionID]; !exists {
+		return ErrRecalculationNotFound
+	}
+	m.discard(competitionID)
+	return nil
+}
+
+func (m *MockRecalculations) discard(competitionID uint) {
+	delete(m.Recalculations, competitionID)
+	delete(m.ShadowScores, competitionID)
+	delete(m.ShadowActivities, competitionID)
+}
+
+// Close is a no-op for the mock implementation
+func (m *MockRecalculations) Close() {}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"common"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQLiteRecalculationsRepository(t *testing.T) {
+	dbPath := "test_recalculations.db"
+	os.Remove(dbPath)
+	if err := MigrateDatabase(dbPath); err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+	repo, err := NewSQLiteRecalculationsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create SQLiteRecalculationsRepository: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		os.Remove(dbPath)
+	}()
+
+	if _, err := repo.db.Exec(`INSERT INTO Competitions (id, name, scorerule) VALUES (1, 'Recalculated', 'amount')`); err != nil {
+		t.Fatalf("failed to create competition: %v", err)
+	}
+	if _, err := repo.db.Exec(`INSERT INTO Leaderboards (competition_id, user_id, score) VALUES (1, 7, 100), (1, 8, 50)`); err != nil {
+		t.Fatalf("failed to store live scores: %v", err)
+	}
+
+	recalculation := &Recalculation{CompetitionID: 1, JobID: 3, ScoreRule: "amount * 2"}
+	if err := repo.Create(recalculation); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := repo.Create(&Recalculation{CompetitionID: 1, JobID: 4, ScoreRule: "amount"}); !errors.Is(err, ErrRecalculationExists) {
+		t.Errorf("expected ErrRecalculationExists, got %v", err)
+	}
+	stored, err := repo.Get(1)
+	if err != nil || !reflect.DeepEqual(stored, recalculation) {
+		t.Errorf("expected %+v, got %+v, %v", recalculation, stored, err)
+	}
+	if _, err := repo.Get(2); !errors.Is(err, ErrRecalculationNotFound) {
+		t.Errorf("expected ErrRecalculationNotFound, got %v", err)
+	}
+
+	// Shadow scores are only saved by the job of the recalculation
+	recalculation.ReceivedUntil = time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	scores := []*CompetitionScore{
+		{UserID: 8, Score: 200, ReachedAt: "2024-06-01T00:00:00.000000000Z", Events: 2, State: common.AggregationState{Count: 2, Sum: 200}},
+		{UserID: 9, Score: 40, ReachedAt: "2024-06-02T00:00:00.000000000Z", Events: 1, State: common.AggregationState{Count: 1, Sum: 40}},
+	}
+	activities := map[uint]*common.UserActivity{8: {Events: 2, Bets: 2}}
+	if err := repo.SaveShadowScores(&Recalculation{CompetitionID: 1, JobID: 4}, scores, activities); !errors.Is(err, ErrRecalculationNotFound) {
+		t.Errorf("expected ErrRecalculationNotFound for another job, got %v", err)
+	}
+	if err := repo.SaveShadowScores(recalculation, scores, activities); err != nil {
+		t.Fatalf("SaveShadowScores failed: %v", err)
+	}
+	if stored, _ := repo.Get(1); !stored.ReceivedUntil.Equal(recalculation.ReceivedUntil) {
+		t.Errorf("expected the shadow scores to include the events received until %v, got %v", recalculation.ReceivedUntil, stored.ReceivedUntil)
+	}
+	shadowScores, shadowActivities, err := repo.GetShadowScores(1)
+	if err != nil || !reflect.DeepEqual(shadowScores, scores) || !reflect.DeepEqual(shadowActivities, activities) {
+		t.Errorf("unexpected shadow scores: %+v, %+v, %v", shadowScores, shadowActivities, err)
+	}
+
+	live, recalculated, err := repo.GetRankings(1)
+	if err != nil {
+		t.Fatalf("GetRankings failed: %v", err)
+	}
+	expectedLive := []*common.RankedUser{{Rank: 1, ID: 7, Score: 100}, {Rank: 2, ID: 8, Score: 50}}
+	expectedRecalculated := []*common.RankedUser{{Rank: 1, ID: 8, Score: 200}, {Rank: 2, ID: 9, Score: 40}}
+	if !reflect.DeepEqual(live, expectedLive) || !reflect.DeepEqual(recalculated, expectedRecalculated) {
+		t.Errorf("unexpected rankings: %+v, %+v", live, recalculated)
+	}
+
+	// Applying swaps the live scores and the rule of the competition
+	if err := repo.Apply(1, scores, activities); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	var scoreRule string
+	if err := repo.db.QueryRow(`SELECT scorerule FROM Competitions WHERE id = 1`).Scan(&scoreRule); err != nil || scoreRule != "amount * 2" {
+		t.Errorf("expected the rule of the recalculation to be applied, got %q, %v", scoreRule, err)
+	}
+	live, recalculated, err = repo.GetRankings(1)
+	if err != nil || !reflect.DeepEqual(live, expectedRecalculated) || len(recalculated) != 0 {
+		t.Errorf("expected the shadow scores to replace the live scores, got %+v, %+v, %v", live, recalculated, err)
+	}
+	if _, err := repo.Get(1); !errors.Is(err, ErrRecalculationNotFound) {
+		t.Errorf("expected the recalculation to be removed once applied, got %v", err)
+	}
+	if err := repo.Apply(1, scores, activities); !errors.Is(err, ErrRecalculationNotFound) {
+		t.Errorf("expected ErrRecalculationNotFound, got %v", err)
+	}
+
+	// Finished competitions keep their scores
+	if err := repo.Create(&Recalculation{CompetitionID: 1, JobID: 5, ScoreRule: "amount"}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := repo.db.Exec(`INSERT INTO FinishedCompetitions (competition_id, finished_at, published) VALUES (1, '2024-07-01T00:00:00Z', 0)`); err != nil {
+		t.Fatalf("failed to finish competition: %v", err)
+	}
+	if err := repo.Apply(1, nil, nil); !errors.Is(err, ErrCompetitionFinished) {
+		t.Errorf("expected ErrCompetitionFinished, got %v", err)
+	}
+
+	if err := repo.Discard(1); err != nil {
+		t.Fatalf("Discard failed: %v", err)
+	}
+	if err := repo.Discard(1); !errors.Is(err, ErrRecalculationNotFound) {
+		t.Errorf("expected ErrRecalculationNotFound, got %v", err)
+	}
+}
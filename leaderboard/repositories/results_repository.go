@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"common"
+)
+
+// ResultsRepository defines the interface for storing the final results of finished competitions
+// This allows for different implementations (e.g., in-memory, database, etc.)
+type ResultsRepository interface {
+	SaveResults(competitionID uint, finishedAt string, results []*common.CompetitionResult) error
+	GetResults(competitionID uint) ([]*common.CompetitionResult, error)
+	GetFinished() ([]*FinishedCompetition, error)
+	MarkPublished(competitionID uint) error
+	Close()
+}
+
+// FinishedCompetition records when a competition was finalized and whether its results
+// have been published to downstream systems
+type FinishedCompetition struct {
+	CompetitionID uint
+	FinishedAt    string
+	Published     bool
+}
+
+// SQLiteResults implements ResultsRepository using SQLite
+type SQLiteResults struct {
+	db *sql.DB
+}
+
+// NewSQLiteResultsRepository opens (or creates) a SQLite DB
+func NewSQLiteResultsRepository(dbPath string) (*SQLiteResults, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteResults{db: db}, nil
+}
+
+// SaveResults marks a competition as finished and stores its final results in a single transaction.
+// Once a competition is marked as finished its scores in the Leaderboards table are frozen.
+func (r *SQLiteResults) SaveResults(competitionID uint, finishedAt string, results []*common.CompetitionResult) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO FinishedCompetitions (competition_id, finished_at, published) VALUES (?, ?, 0)`,
+		competitionID, finishedAt); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if _, err := tx.Exec(`INSERT INTO CompetitionResults (competition_id, user_id, rank, score, reward) VALUES (?, ?, ?, ?, ?)`,
+			competitionID, result.UserID, result.Rank, result.Score, result.Reward); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetResults retrieves the final results of a competition, ordered by rank
+func (r *SQLiteResults) GetResults(competitionID uint) ([]*common.CompetitionResult, error) {
+	rows, err := r.db.Query(`SELECT competition_id, user_id, rank, score, reward FROM CompetitionResults WHERE competition_id = ? ORDER BY rank`, competitionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []*common.CompetitionResult
+	for rows.Next() {
+		var result common.CompetitionResult
+		if err := rows.Scan(&result.CompetitionID, &result.UserID, &result.Rank, &result.Score, &result.Reward); err != nil {
+			return nil, err
+		}
+		results = append(results, &result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetFinished retrieves all the competitions that have been finalized
+func (r *SQLiteResults) GetFinished() ([]*FinishedCompetition, error) {
+	rows, err := r.db.Query(`SELECT competition_id, finished_at, published FROM FinishedCompetitions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var finished []*FinishedCompetition
+	for rows.Next() {
+		var f FinishedCompetition
+		if err := rows.Scan(&f.CompetitionID, &f.FinishedAt, &f.Published); err != nil {
+			return nil, err
+		}
+		finished = append(finished, &f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return finished, nil
+}
+
+// MarkPublished records that the results of a competition have been published
+func (r *SQLiteResults) MarkPublished(competitionID uint) error {
+	_, err := r.db.Exec(`UPDATE FinishedCompetitions SET published = 1 WHERE competition_id = ?`, competitionID)
+	return err
+}
+
+// Close closes the SQLite database connection
+func (r *SQLiteResults) Close() {
+	if r.db != nil {
+		r.db.Close()
+	}
+}
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"common"
+	"sort"
+)
+
+// MockResults is a mock implementation of ResultsRepository for testing
+type MockResults struct {
+	Finished map[uint]*FinishedCompetition
+	Results  map[uint][]*common.CompetitionResult
+
+	SaveResultsErr error
+	ReturnErr      error
+}
+
+// SaveResults records the competition as finished along with its results
+func (m *MockResults) SaveResults(competitionID uint, finishedAt string, results []*common.CompetitionResult) error {
+	if m.SaveResultsErr != nil {
+		return m.SaveResultsErr
+	}
+	if m.Finished == nil {
+		m.Finished = make(map[uint]*FinishedCompetition)
+	}
+	if m.Results == nil {
+		m.Results = make(map[uint][]*common.CompetitionResult)
+	}
+	m.Finished[competitionID] = &FinishedCompetition{CompetitionID: competitionID, FinishedAt: finishedAt}
+	m.Results[competitionID] = results
+	return nil
+}
+
+// GetResults returns the results stored for the competition
+func (m *MockResults) GetResults(competitionID uint) ([]*common.CompetitionResult, error) {
+	return m.Results[competitionID], m.ReturnErr
+}
+
+// GetFinished returns every competition stored in the Finished map, ordered by competition ID
+func (m *MockResults) GetFinished() ([]*FinishedCompetition, error) {
+	var finished []*FinishedCompetition
+	for _, f := range m.Finished {
+		finished = append(finished, f)
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].CompetitionID < finished[j].CompetitionID })
+	return finished, m.ReturnErr
+}
+
+// MarkPublished flags the competition as published
+func (m *MockResults) MarkPublished(competitionID uint) error {
+	if f, ok := m.Finished[competitionID]; ok {
+		f.Published = true
+	}
+	return m.ReturnErr
+}
+
+// Close is a no-op for the mock implementation
+func (m *MockResults) Close() {}
@@ -0,0 +1,88 @@
+package repositories
+
+import (
+	"common"
+	"os"
+	"testing"
+)
+
+func TestSQLiteResultsRepository(t *testing.T) {
+	dbPath := "test_results.db"
+	os.Remove(dbPath)
+
+	// Migrate the test DB to create its schema
+	err := MigrateDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate test DB: %v", err)
+	}
+
+	repo, err := NewSQLiteResultsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create repo: %v", err)
+	}
+	leaderboardsRepo, err := NewSQLiteLeaderboardsRepository(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create leaderboards repo: %v", err)
+	}
+	defer func() {
+		repo.Close()
+		leaderboardsRepo.Close()
+		os.Remove(dbPath)
+	}()
+
+	if err := leaderboardsRepo.Update(1, 10, 100.0, "", nil); err != nil {
+		t.Fatalf("failed to update score: %v", err)
+	}
+
+	results := []*common.CompetitionResult{
+		{CompetitionID: 1, UserID: 10, Rank: 1, Score: 100.0, Reward: 50},
+		{CompetitionID: 1, UserID: 20, Rank: 2, Score: 80.0, Reward: 0},
+	}
+	if err := repo.SaveResults(1, "2023-08-01T00:00:00Z", results); err != nil {
+		t.Fatalf("failed to save results: %v", err)
+	}
+
+	// A competition can only be finished once
+	if err := repo.SaveResults(1, "2023-08-02T00:00:00Z", results); err == nil {
+		t.Errorf("expected error when finishing a competition twice")
+	}
+
+	got, err := repo.GetResults(1)
+	if err != nil {
+		t.Fatalf("failed to get results: %v", err)
+	}
+	if len(got) != 2 || *got[0] != *results[0] || *got[1] != *results[1] {
+		t.Errorf("unexpected results: %+v", got)
+	}
+
+	finished, err := repo.GetFinished()
+	if err != nil {
+		t.Fatalf("failed to get finished competitions: %v", err)
+	}
+	if len(finished) != 1 || finished[0].CompetitionID != 1 || finished[0].FinishedAt != "2023-08-01T00:00:00Z" || finished[0].Published {
+		t.Errorf("unexpected finished competitions: %+v", finished)
+	}
+
+	if err := repo.MarkPublished(1); err != nil {
+		t.Fatalf("failed to mark published: %v", err)
+	}
+	finished, _ = repo.GetFinished()
+	if len(finished) != 1 || !finished[0].Published {
+		t.Errorf("expected competition to be marked as published: %+v", finished)
+	}
+
+	// Scores of a finished competition are frozen
+	if err := leaderboardsRepo.Update(1, 10, 500.0, "", nil); err != nil {
+		t.Fatalf("failed to update score: %v", err)
+	}
+	if err := leaderboardsRepo.Update(1, 30, 500.0, "", nil); err != nil {
+		t.Fatalf("failed to update score: %v", err)
+	}
+	users, err := leaderboardsRepo.GetTopN(1, -1)
+	if err != nil {
+		t.Fatalf("failed to get top users: %v", err)
+	}
+	if len(users) != 1 || users[0].Score != 100.0 {
+		t.Errorf("expected frozen scores to be unchanged, got %+v", users)
+	}
+}
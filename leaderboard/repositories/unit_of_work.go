@@ -0,0 +1,73 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+
+	"common"
+)
+
+// LeaderboardsUnitOfWork groups the writes of a bet event: the event is marked as processed and the scores
+// and user histories it changes are committed together, or none of them is.
+// Rollback discards the writes, it does nothing once the unit of work has been committed.
+type LeaderboardsUnitOfWork interface {
+	StoreBetEvent(event *common.BetEvent) error
+	Update(competitionID, userID uint, score float64, reachedAt string, state *common.AggregationState) error
+	UpdateActivity(competitionID, userID uint, activity *common.UserActivity) error
+	DisableRule(competitionID uint) error
+	Commit() error
+	Rollback() error
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// sqliteLeaderboardsUnitOfWork implements LeaderboardsUnitOfWork with a SQLite transaction
+type sqliteLeaderboardsUnitOfWork struct {
+	tx *sql.Tx
+}
+
+// Begin starts a unit of work in a new transaction
+func (sr *SQLiteLeaderboards) Begin() (LeaderboardsUnitOfWork, error) {
+	tx, err := sr.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteLeaderboardsUnitOfWork{tx: tx}, nil
+}
+
+// StoreBetEvent marks a bet event as processed
+func (u *sqliteLeaderboardsUnitOfWork) StoreBetEvent(event *common.BetEvent) error {
+	return storeBetEvent(u.tx, event)
+}
+
+// Update stores the score of a user in a competition, see SQLiteLeaderboards.Update
+func (u *sqliteLeaderboardsUnitOfWork) Update(competitionID, userID uint, score float64, reachedAt string, state *common.AggregationState) error {
+	return updateScore(u.tx, competitionID, userID, score, reachedAt, state)
+}
+
+// UpdateActivity stores the history of a user in a competition, see SQLiteLeaderboards.UpdateActivity
+func (u *sqliteLeaderboardsUnitOfWork) UpdateActivity(competitionID, userID uint, activity *common.UserActivity) error {
+	return updateActivity(u.tx, competitionID, userID, activity)
+}
+
+// DisableRule marks a competition whose score rule kept failing as disabled, so it stays disabled on restart
+func (u *sqliteLeaderboardsUnitOfWork) DisableRule(competitionID uint) error {
+	_, err := u.tx.Exec(`UPDATE Competitions SET rule_disabled = 1 WHERE id = ?`, competitionID)
+	return err
+}
+
+// Commit commits the writes of the unit of work
+func (u *sqliteLeaderboardsUnitOfWork) Commit() error {
+	return u.tx.Commit()
+}
+
+// Rollback discards the writes of the unit of work if it has not been committed
+func (u *sqliteLeaderboardsUnitOfWork) Rollback() error {
+	if err := u.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return err
+	}
+	return nil
+}